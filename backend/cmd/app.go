@@ -0,0 +1,100 @@
+package main
+
+import (
+	"sentimenta/internal/ai"
+	"sentimenta/internal/config"
+	"sentimenta/internal/encryption"
+	"sentimenta/internal/hash"
+	"sentimenta/internal/mail"
+	"sentimenta/internal/metrics"
+	"sentimenta/internal/privacy"
+	"sentimenta/internal/prompts"
+	"sentimenta/internal/repository"
+	"sentimenta/internal/safety"
+	"sentimenta/internal/service"
+	"sentimenta/internal/strength"
+	"sentimenta/internal/ws"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// app — зависимости, общие для HTTP-сервера и команд CLI.
+type app struct {
+	cfg        *config.Config
+	logger     *zap.SugaredLogger
+	prometheus *metrics.Prometheus
+	db         *gorm.DB
+
+	wsConnManager *ws.ConnectionManager
+	aiClient      *ai.Client
+	redactor      *privacy.Redactor
+	safetyChecker *safety.Checker
+	envelope      *encryption.Envelope
+	passwords     *hash.Registry
+	passwordRules *strength.Policy
+	mailer        mail.Mailer
+	prompts       *prompts.Store
+
+	userRepo          repository.UserRepository
+	moodRepo          repository.MoodRepository
+	adviceRepo        repository.AdviceRepository
+	deletionRepo      repository.DeletionRepository
+	securityEventRepo repository.SecurityEventRepository
+	statsRepo         repository.StatsRepository
+	passkeyRepo       repository.PasskeyRepository
+	magicLinkRepo     repository.MagicLinkRepository
+	promptRepo        repository.PromptRepository
+	aiUsageRepo       repository.AIUsageRepository
+
+	userService          service.UserService
+	securityEventService service.SecurityEventService
+	adviceService        service.AdviceService
+	moodService          service.MoodService
+	adminService         service.AdminService
+	deletionService      service.DeletionService
+	exportService        service.ExportService
+	passkeyService       service.PasskeyService
+	magicLinkService     service.MagicLinkService
+	aiUsageService       service.AIUsageService
+}
+
+func newApp(cfg *config.Config, logger *zap.SugaredLogger, prometheus *metrics.Prometheus, db *gorm.DB) *app {
+	a := &app{cfg: cfg, logger: logger, prometheus: prometheus, db: db}
+
+	a.wsConnManager = ws.NewConnectionManager()
+	a.aiClient = ai.NewClient(cfg, prometheus, logger)
+	a.redactor = privacy.NewRedactor(cfg, logger)
+	a.envelope = encryption.NewEnvelope(cfg, repository.NewUserKeyRepository(db), logger)
+	a.mailer = mail.NewMailer(cfg, logger)
+	a.passwords = hash.NewRegistry(cfg, logger)
+	a.passwordRules = strength.NewPolicy(cfg, logger)
+
+	a.userRepo = repository.NewUserRepository(db)
+	a.moodRepo = repository.NewMoodRepository(db, a.envelope)
+	a.adviceRepo = repository.NewAdviceRepository(db, a.envelope)
+	a.deletionRepo = repository.NewDeletionRepository(db)
+	a.securityEventRepo = repository.NewSecurityEventRepository(db)
+	a.statsRepo = repository.NewStatsRepository(db)
+	a.passkeyRepo = repository.NewPasskeyRepository(db)
+	a.magicLinkRepo = repository.NewMagicLinkRepository(db)
+	a.promptRepo = repository.NewPromptRepository(db)
+	a.aiUsageRepo = repository.NewAIUsageRepository(db)
+
+	a.aiUsageService = service.NewAIUsageService(a.aiUsageRepo, cfg, prometheus, logger)
+	a.safetyChecker = safety.NewChecker(cfg, a.aiClient, a.aiUsageService, a.redactor, prometheus, logger)
+
+	a.prompts = prompts.NewStore(cfg, a.promptRepo, logger)
+
+	a.securityEventService = service.NewSecurityEventService(a.securityEventRepo, logger)
+	a.userService = service.NewUserService(a.userRepo, a.passwords, a.passwordRules, a.securityEventService, logger)
+	a.adviceService = service.NewAdviceService(a.adviceRepo, a.moodRepo, a.userRepo, a.aiUsageService, cfg, logger, prometheus, a.aiClient, a.safetyChecker, a.redactor, a.prompts, a.wsConnManager)
+	a.moodService = service.NewMoodService(a.moodRepo, a.userRepo, a.adviceRepo, a.adviceService, logger, a.wsConnManager, a.safetyChecker)
+	a.adminService = service.NewAdminService(a.userRepo, a.statsRepo, a.wsConnManager, logger)
+	a.deletionService = service.NewDeletionService(a.deletionRepo, a.userRepo, a.passwords, a.mailer, a.wsConnManager, cfg, logger)
+	a.exportService = service.NewExportService(a.userRepo, a.moodRepo, a.adviceRepo, a.securityEventRepo)
+	a.passkeyService = service.NewPasskeyService(a.passkeyRepo, a.userRepo, cfg, logger)
+	a.magicLinkService = service.NewMagicLinkService(a.magicLinkRepo, a.userRepo, a.mailer, cfg, logger)
+
+	return a
+}
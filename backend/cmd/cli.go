@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sentimenta/internal/db"
+	"sentimenta/internal/encryption"
+	errs "sentimenta/internal/errors"
+	"sentimenta/internal/models"
+	"sentimenta/internal/prompts"
+	"sentimenta/internal/security"
+	"sentimenta/internal/strength"
+	"strconv"
+	"time"
+)
+
+type command func(a *app, args []string) (any, error)
+
+var commands = map[string]command{
+	"migrate": migrateCmd,
+	"user": subcommands("user", map[string]command{
+		"create":         userCreateCmd,
+		"disable":        userDisableCmd(true),
+		"enable":         userDisableCmd(false),
+		"reset-password": userResetPasswordCmd,
+		"promote":        userPromoteCmd,
+	}),
+	"advice": subcommands("advice", map[string]command{
+		"backfill": adviceBackfillCmd,
+	}),
+	"jwt": subcommands("jwt", map[string]command{
+		"rotate": jwtRotateCmd,
+	}),
+	"password": subcommands("password", map[string]command{
+		"bloom": passwordBloomCmd,
+	}),
+	"prompt": subcommands("prompt", map[string]command{
+		"list":   promptListCmd,
+		"import": promptImportCmd,
+	}),
+	"export-user":   exportUserCmd,
+	"purge-deleted": purgeDeletedCmd,
+	"reencrypt":     reencryptCmd,
+}
+
+var (
+	errUserRequired    = errors.New("не указан пользователь (-id)")
+	errJWTKeysDisabled = errors.New("ключи подписи не настроены (JWT_KEYS_DIR)")
+	errBloomFiles      = errors.New("не указаны файлы (-in, -out)")
+	errPromptFile      = errors.New("не указаны версия и файл (-version, -file)")
+)
+
+func subcommands(name string, cmds map[string]command) command {
+	return func(a *app, args []string) (any, error) {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("не указана подкоманда %s", name)
+		}
+		cmd, ok := cmds[args[0]]
+		if !ok {
+			return nil, fmt.Errorf("неизвестная подкоманда %s %s", name, args[0])
+		}
+		return cmd(a, args[1:])
+	}
+}
+
+func printJSON(v any) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "не удалось вывести результат: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// cliEvent — запись журнала безопасности о действии, выполненном из CLI.
+func cliEvent(eventType string, userID int) models.SecurityEvent {
+	return models.SecurityEvent{
+		Type:    eventType,
+		UserID:  &userID,
+		Details: map[string]string{"source": "cli"},
+	}
+}
+
+func migrateCmd(a *app, args []string) (any, error) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	flags.Parse(args)
+
+	db.Migrate(a.db, a.logger)
+	return map[string]string{"status": "ok"}, nil
+}
+
+func userCreateCmd(a *app, args []string) (any, error) {
+	flags := flag.NewFlagSet("user create", flag.ExitOnError)
+	email := flags.String("email", "", "email of the new user")
+	username := flags.String("username", "", "username (defaults to email)")
+	password := flags.String("password", "", "password (generated when empty)")
+	timezone := flags.String("timezone", "UTC", "user timezone")
+	role := flags.String("role", models.RoleUser, "user role")
+	flags.Parse(args)
+
+	if *username == "" {
+		*username = *email
+	}
+	generated := *password == ""
+	if generated {
+		var err error
+		if *password, err = randomPassword(); err != nil {
+			return nil, err
+		}
+	}
+
+	user, err := a.userService.CreateUser(*username, *email, password, *timezone)
+	if err != nil {
+		return nil, err
+	}
+	uid := strconv.Itoa(user.Uid)
+	a.securityEventService.Record(cliEvent(models.SecurityRegister, user.Uid))
+
+	if *role != models.RoleUser {
+		if _, err := a.adminService.SetRole("", uid, *role); err != nil {
+			return nil, err
+		}
+	}
+	result, err := a.adminService.GetUser(uid)
+	if err != nil {
+		return nil, err
+	}
+	if generated {
+		return map[string]any{"user": result, "password": *password}, nil
+	}
+	return result, nil
+}
+
+func userDisableCmd(disabled bool) command {
+	return func(a *app, args []string) (any, error) {
+		flags := flag.NewFlagSet("user disable", flag.ExitOnError)
+		id := flags.Int("id", 0, "user id")
+		flags.Parse(args)
+		if *id == 0 {
+			return nil, errUserRequired
+		}
+
+		user, err := a.adminService.SetDisabled("", strconv.Itoa(*id), disabled)
+		if err != nil {
+			return nil, err
+		}
+		eventType := models.SecurityAccountEnable
+		if disabled {
+			eventType = models.SecurityAccountDisable
+		}
+		a.securityEventService.Record(cliEvent(eventType, *id))
+		return user, nil
+	}
+}
+
+func userResetPasswordCmd(a *app, args []string) (any, error) {
+	flags := flag.NewFlagSet("user reset-password", flag.ExitOnError)
+	id := flags.Int("id", 0, "user id")
+	password := flags.String("password", "", "new password (generated when empty)")
+	flags.Parse(args)
+	if *id == 0 {
+		return nil, errUserRequired
+	}
+
+	generated := *password == ""
+	if generated {
+		var err error
+		if *password, err = randomPassword(); err != nil {
+			return nil, err
+		}
+	}
+
+	uid := strconv.Itoa(*id)
+	if err := a.userService.ResetPassword(uid, *password); err != nil {
+		return nil, err
+	}
+	// Старые сессии могли быть получены со старым паролем
+	if err := a.adminService.ForceLogout(uid); err != nil {
+		return nil, err
+	}
+	a.securityEventService.Record(cliEvent(models.SecurityPasswordChange, *id))
+
+	result := map[string]any{"uid": *id, "status": "ok"}
+	if generated {
+		result["password"] = *password
+	}
+	return result, nil
+}
+
+func userPromoteCmd(a *app, args []string) (any, error) {
+	flags := flag.NewFlagSet("user promote", flag.ExitOnError)
+	id := flags.Int("id", 0, "user id")
+	role := flags.String("role", models.RoleAdmin, "role to assign")
+	flags.Parse(args)
+	if *id == 0 {
+		return nil, errUserRequired
+	}
+
+	user, err := a.adminService.SetRole("", strconv.Itoa(*id), *role)
+	if err != nil {
+		return nil, err
+	}
+	event := cliEvent(models.SecurityRoleChange, *id)
+	event.Details["role"] = *role
+	a.securityEventService.Record(event)
+	return user, nil
+}
+
+type backfillResult struct {
+	UserID  int    `json:"user_id"`
+	Created int    `json:"created"`
+	Error   string `json:"error,omitempty"`
+}
+
+func adviceBackfillCmd(a *app, args []string) (any, error) {
+	flags := flag.NewFlagSet("advice backfill", flag.ExitOnError)
+	fromStr := flags.String("from", "", "first date, YYYY-MM-DD")
+	toStr := flags.String("to", "", "last date, YYYY-MM-DD (defaults to today)")
+	id := flags.Int("id", 0, "only this user")
+	flags.Parse(args)
+
+	from, err := time.Parse(time.DateOnly, *fromStr)
+	if err != nil {
+		return nil, fmt.Errorf("неверная дата -from: %w", err)
+	}
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	if *toStr != "" {
+		if to, err = time.Parse(time.DateOnly, *toStr); err != nil {
+			return nil, fmt.Errorf("неверная дата -to: %w", err)
+		}
+	}
+
+	var users []models.User
+	if *id != 0 {
+		user, err := a.userRepo.GetUser(strconv.Itoa(*id))
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	} else if users, err = a.userRepo.GetAllUsers(); err != nil {
+		return nil, err
+	}
+
+	results := []backfillResult{}
+	for _, user := range users {
+		if *id == 0 && !user.UseAI {
+			continue
+		}
+		advices, err := a.adviceService.BackfillAdvice(context.Background(), strconv.Itoa(user.Uid), from, to)
+		result := backfillResult{UserID: user.Uid, Created: len(advices)}
+		if err != nil {
+			// Ошибка одного пользователя не останавливает остальных
+			result.Error = err.Error()
+			a.logger.Warnf("Пользователь %d: не удалось сгенерировать советы: %v", user.Uid, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func exportUserCmd(a *app, args []string) (any, error) {
+	flags := flag.NewFlagSet("export-user", flag.ExitOnError)
+	id := flags.Int("id", 0, "user id")
+	flags.Parse(args)
+	if *id == 0 {
+		return nil, errUserRequired
+	}
+
+	export, err := a.exportService.ExportUser(strconv.Itoa(*id))
+	if err != nil {
+		return nil, err
+	}
+	a.securityEventService.Record(cliEvent(models.SecurityDataExport, *id))
+	return export, nil
+}
+
+func purgeDeletedCmd(a *app, args []string) (any, error) {
+	flags := flag.NewFlagSet("purge-deleted", flag.ExitOnError)
+	flags.Parse(args)
+
+	purged, err := a.deletionService.PurgeDue()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]int{"purged": purged}, nil
+}
+
+type reencryptResult struct {
+	UserID  int `json:"user_id"`
+	Keys    int `json:"keys"`
+	Moods   int `json:"moods"`
+	Advices int `json:"advices"`
+}
+
+// reencryptCmd перешифровывает ключи данных текущим мастер-ключом (после
+// смены ENCRYPTION_MASTER_KEY_ID) и шифрует записи, сохранённые до
+// включения шифрования. С флагом -rotate-data-keys каждому пользователю
+// создаётся новая версия ключа данных.
+func reencryptCmd(a *app, args []string) (any, error) {
+	flags := flag.NewFlagSet("reencrypt", flag.ExitOnError)
+	rotateDataKeys := flags.Bool("rotate-data-keys", false, "create a new data key version for every user")
+	id := flags.Int("id", 0, "re-encrypt only this user")
+	flags.Parse(args)
+
+	if !a.envelope.Enabled() {
+		return nil, encryption.ErrDisabled
+	}
+
+	var users []models.User
+	if *id != 0 {
+		user, err := a.userRepo.GetUser(strconv.Itoa(*id))
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	} else {
+		var err error
+		if users, err = a.userRepo.GetAllUsers(); err != nil {
+			return nil, err
+		}
+	}
+
+	results := []reencryptResult{}
+	for _, user := range users {
+		rewrapped, err := a.envelope.RewrapUserKeys(user.Uid)
+		if err != nil {
+			return results, fmt.Errorf("пользователь %d: не удалось перешифровать ключи: %w", user.Uid, err)
+		}
+		if *rotateDataKeys {
+			if _, err := a.envelope.RotateUserKey(user.Uid); err != nil {
+				return results, fmt.Errorf("пользователь %d: не удалось создать новый ключ: %w", user.Uid, err)
+			}
+		}
+
+		moods, err := a.moodRepo.ReencryptMoods(user.Uid)
+		if err != nil {
+			return results, fmt.Errorf("пользователь %d: не удалось перешифровать записи: %w", user.Uid, err)
+		}
+		advices, err := a.adviceRepo.ReencryptAdvices(user.Uid)
+		if err != nil {
+			return results, fmt.Errorf("пользователь %d: не удалось перешифровать советы: %w", user.Uid, err)
+		}
+		results = append(results, reencryptResult{UserID: user.Uid, Keys: rewrapped, Moods: moods, Advices: advices})
+	}
+	return results, nil
+}
+
+// jwtRotateCmd создаёт новый ключ подписи, не дожидаясь плановой
+// ротации. Запущенные серверы подхватят его при следующей проверке.
+func jwtRotateCmd(a *app, args []string) (any, error) {
+	flags := flag.NewFlagSet("jwt rotate", flag.ExitOnError)
+	flags.Parse(args)
+
+	keys := security.NewJWT(a.cfg, a.logger).Keys()
+	if keys == nil {
+		return nil, errJWTKeysDisabled
+	}
+	kid, err := keys.Rotate()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"kid": kid}, nil
+}
+
+// passwordBloomCmd строит фильтр утёкших паролей для
+// PASSWORD_BREACHED_FILE. Во входном файле по строке на пароль или SHA-1
+// в hex, как в выгрузке Have I Been Pwned. Файл читается дважды, чтобы
+// не держать список в памяти.
+func passwordBloomCmd(a *app, args []string) (any, error) {
+	flags := flag.NewFlagSet("password bloom", flag.ExitOnError)
+	in := flags.String("in", "", "breached password list")
+	out := flags.String("out", "", "output filter file")
+	fp := flags.Float64("fp", 0.001, "false positive rate")
+	flags.Parse(args)
+	if *in == "" || *out == "" {
+		return nil, errBloomFiles
+	}
+
+	input, err := os.Open(*in)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+
+	count, err := strength.CountLines(input)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := input.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	bloom := strength.NewBloom(count, *fp)
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		if digest, ok := strength.ParseBreachedLine(scanner.Text()); ok {
+			bloom.Add(digest)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	output, err := os.Create(*out)
+	if err != nil {
+		return nil, err
+	}
+	size, err := bloom.WriteTo(output)
+	if err != nil {
+		output.Close()
+		return nil, err
+	}
+	if err := output.Close(); err != nil {
+		return nil, err
+	}
+	return map[string]any{"entries": count, "bytes": size}, nil
+}
+
+// promptListCmd выводит загруженные версии промпта и веса эксперимента.
+func promptListCmd(a *app, args []string) (any, error) {
+	flags := flag.NewFlagSet("prompt list", flag.ExitOnError)
+	flags.Parse(args)
+
+	return a.prompts.List(), nil
+}
+
+// promptImportCmd сохраняет шаблон из файла в БД как новую версию.
+// Запущенные серверы подхватят её при следующей перезагрузке промптов.
+// Имя не должно совпадать со встроенной версией или файлом из PROMPTS_DIR,
+// иначе серверы перестанут перезагружать промпты.
+func promptImportCmd(a *app, args []string) (any, error) {
+	flags := flag.NewFlagSet("prompt import", flag.ExitOnError)
+	version := flags.String("version", "", "prompt version")
+	file := flags.String("file", "", "template file")
+	flags.Parse(args)
+	if *version == "" || *file == "" {
+		return nil, errPromptFile
+	}
+	if a.prompts.Has(*version) {
+		return nil, fmt.Errorf("%w: %s", errs.ErrPromptVersionExists, *version)
+	}
+
+	body, err := os.ReadFile(*file)
+	if err != nil {
+		return nil, err
+	}
+	if err := prompts.Validate(string(body)); err != nil {
+		return nil, err
+	}
+
+	prompt := models.PromptTemplate{Version: *version, Body: string(body)}
+	if err := a.promptRepo.CreatePrompt(&prompt); err != nil {
+		return nil, err
+	}
+	return prompt, nil
+}
+
+func randomPassword() (string, error) {
+	buf := make([]byte, 18)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
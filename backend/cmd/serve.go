@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sentimenta/internal/auth"
+	"sentimenta/internal/handlers"
+	middlewares "sentimenta/internal/middleware"
+	"sentimenta/internal/models"
+	"sentimenta/internal/problem"
+	"sentimenta/internal/ratelimit"
+	"sentimenta/internal/security"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	swagger "github.com/swaggo/echo-swagger"
+)
+
+// serve запускает HTTP API.
+func serve(a *app) {
+	cfg, logger := a.cfg, a.logger
+
+	jwt := security.NewJWT(cfg, logger)
+	oauth := auth.NewOAuth(cfg)
+	responser := handlers.NewResponser(a.prometheus, logger)
+
+	wsHandler := handlers.NewWSHandler(cfg, logger, a.wsConnManager)
+	userHandler := handlers.NewUserHandler(a.userService, cfg, logger, responser)
+	authHandler := handlers.NewAuthHandler(a.userService, a.securityEventService, a.passkeyService, a.magicLinkService, cfg, logger, oauth, jwt, responser)
+	moodHandler := handlers.NewMoodHandler(a.moodService, cfg, logger, responser)
+	adviceHandler := handlers.NewAdviceHandler(a.adviceService, logger, responser)
+	deletionHandler := handlers.NewDeletionHandler(a.deletionService, a.securityEventService, logger, responser)
+	securityEventHandler := handlers.NewSecurityEventHandler(a.securityEventService, logger, responser)
+	passkeyHandler := handlers.NewPasskeyHandler(a.passkeyService, a.securityEventService, logger, responser)
+	exportHandler := handlers.NewExportHandler(a.exportService, a.securityEventService, logger, responser)
+	adminHandler := handlers.NewAdminHandler(a.adminService, a.adviceService, a.deletionService, a.securityEventService, logger, responser)
+	jwksHandler := handlers.NewJWKSHandler(jwt)
+	statusHandler := handlers.NewStatusHandler()
+
+	jwtAuth := middlewares.NewJWTMiddleware(cfg, jwt, a.userService)
+
+	limiter := ratelimit.NewLimiter(cfg, a.db, logger)
+	policies := ratelimit.NewPolicies(cfg)
+	authLimit := middlewares.NewRateLimitMiddleware(limiter, policies.Auth, true, nil, a.prometheus, logger)
+	loginLimit := middlewares.NewRateLimitMiddleware(limiter, policies.Auth, true, middlewares.LoginEmailKey, a.prometheus, logger)
+	aiLimit := middlewares.NewRateLimitMiddleware(limiter, policies.AI, false, middlewares.UserIDKey, a.prometheus, logger)
+	exportLimit := middlewares.NewRateLimitMiddleware(limiter, policies.Export, false, middlewares.UserIDKey, a.prometheus, logger)
+
+	a.adminService.PromoteAdmins(cfg.ADMIN_USER_IDS)
+	go a.deletionService.RunPurger(context.Background(), time.Hour)
+	go a.prompts.RunReload(context.Background(), time.Minute)
+	if keys := jwt.Keys(); keys != nil {
+		go keys.RunRotation(context.Background(), time.Hour)
+	}
+
+	e := echo.New()
+	e.HTTPErrorHandler = problem.HTTPErrorHandler
+	e.IPExtractor = middlewares.NewIPExtractor(cfg, logger)
+	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOrigins:     cfg.ALLOWED_ORIGINS,
+		AllowMethods:     []string{http.MethodGet, http.MethodPost, http.MethodPatch, http.MethodPut, http.MethodDelete},
+		AllowHeaders:     []string{"*"},
+		AllowCredentials: true,
+	}))
+	e.Use(middleware.Logger())
+	e.Use(a.prometheus.Middleware())
+	if cfg.CSRF_ENABLED {
+		e.Use(middlewares.NewCSRFMiddleware(cfg))
+	}
+
+	e.POST("/api/auth/login", authHandler.Login, loginLimit)
+	e.POST("/api/auth/register", authHandler.Register, authLimit)
+	e.POST("/api/auth/passkey/begin", authHandler.PostPasskeyLoginBegin, authLimit)
+	e.POST("/api/auth/passkey/finish", authHandler.PostPasskeyLoginFinish, authLimit)
+	e.POST("/api/auth/magic-link", authHandler.PostMagicLink, loginLimit)
+	e.POST("/api/auth/magic-link/verify", authHandler.PostMagicLinkVerify, authLimit)
+	e.POST("/api/auth/logout", authHandler.PostLogout)
+
+	e.POST("/api/auth/google/callback", authHandler.GoogleAuthCallback, authLimit)
+	e.POST("/api/auth/github/callback", authHandler.GithubAuthCallback, authLimit)
+
+	userGroup := e.Group("/api/user")
+	userGroup.Use(jwtAuth)
+	userGroup.GET("/get", userHandler.GetUser)
+	userGroup.PATCH("/update", userHandler.PatchUpdateUser)
+	userGroup.PUT("/update/password", userHandler.PutUpdatePasswordUser)
+	userGroup.DELETE("", deletionHandler.DeleteUser)
+	userGroup.POST("/deletion/code", deletionHandler.PostDeletionCode, authLimit)
+	userGroup.POST("/deletion/cancel", deletionHandler.PostCancelDeletion)
+	userGroup.GET("/security-events", securityEventHandler.GetUserEvents)
+	userGroup.GET("/export", exportHandler.GetExport, exportLimit)
+	userGroup.GET("/passkeys", passkeyHandler.GetPasskeys)
+	userGroup.POST("/passkeys/register/begin", passkeyHandler.PostRegisterBegin)
+	userGroup.POST("/passkeys/register/finish", passkeyHandler.PostRegisterFinish)
+	userGroup.DELETE("/passkeys/:id", passkeyHandler.DeletePasskey)
+
+	e.GET("/api/user/deletion/receipt/:id", deletionHandler.GetReceipt)
+
+	moodGroup := e.Group("/api/moods")
+	moodGroup.Use(jwtAuth)
+	moodGroup.POST("/add", moodHandler.PostAddMood)
+	moodGroup.GET("/get", moodHandler.GetMoods)
+	moodGroup.PUT("/update", moodHandler.PutUpdateMood)
+	moodGroup.DELETE("/delete/:id", moodHandler.DeleteMood)
+
+	e.GET("/ws", wsHandler.HandleWS, jwtAuth)
+
+	adviceGroup := e.Group("/api/advice")
+	adviceGroup.Use(jwtAuth)
+	adviceGroup.GET("", adviceHandler.GetAdvice)
+	adviceGroup.POST("/generate", adviceHandler.PostGenerateAdvice, aiLimit)
+	adviceGroup.POST("/:id/regenerate", adviceHandler.PostRegenerateAdvice, aiLimit)
+	adviceGroup.GET("/:id/history", adviceHandler.GetAdviceHistory)
+	adviceGroup.POST("/:id/feedback", adviceHandler.PostAdviceFeedback)
+
+	adminGroup := e.Group("/api/admin")
+	adminGroup.Use(jwtAuth, middlewares.NewRoleMiddleware(a.userService, models.RoleAdmin))
+	adminGroup.GET("/users", adminHandler.GetUsers)
+	adminGroup.GET("/users/:id", adminHandler.GetUser)
+	adminGroup.POST("/users/:id/disable", adminHandler.PostDisableUser)
+	adminGroup.POST("/users/:id/enable", adminHandler.PostEnableUser)
+	adminGroup.POST("/users/:id/logout", adminHandler.PostLogoutUser)
+	adminGroup.PATCH("/users/:id/role", adminHandler.PatchUserRole)
+	adminGroup.PATCH("/users/:id/ai", adminHandler.PatchUserAI)
+	adminGroup.GET("/stats", adminHandler.GetSystemStats)
+	adminGroup.GET("/stats/advice-feedback", adminHandler.GetAdviceFeedbackStats)
+	adminGroup.GET("/deletions", adminHandler.GetDeletions)
+	adminGroup.GET("/security-events", adminHandler.GetSecurityEvents)
+
+	e.GET("/.well-known/jwks.json", jwksHandler.GetJWKS)
+	e.GET("/api/status", statusHandler.GetStatus)
+	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
+	e.GET("/swagger/*any", swagger.WrapHandler)
+
+	e.Logger.Fatal(e.Start("0.0.0.0:8000"))
+}
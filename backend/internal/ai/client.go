@@ -0,0 +1,476 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"sentimenta/internal/config"
+	"sentimenta/internal/metrics"
+	"sentimenta/internal/utils"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const openRouterURL = "https://openrouter.ai/api/v1/chat/completions"
+
+// retryBaseDelay — пауза перед первым повтором, дальше она удваивается.
+const retryBaseDelay = 500 * time.Millisecond
+
+// maxErrorBody — сколько байт ответа с ошибкой читается ради сообщения.
+const maxErrorBody = 64 << 10
+
+// maxStreamEvent — предельный размер одного события потока.
+const maxStreamEvent = 1 << 20
+
+var errStreamStalled = errors.New("модель перестала присылать ответ")
+
+// Client — клиент OpenAI-совместимого API чат-комплишенов.
+type Client struct {
+	config     *config.Config
+	httpClient *http.Client
+	metrics    *metrics.Prometheus
+	logger     *zap.SugaredLogger
+
+	// models — AI_MODEL и за ним AI_FALLBACK_MODELS в порядке перебора
+	models   []string
+	breakers map[string]*breaker
+	prices   map[string]price
+}
+
+// apiError — тело ошибки провайдера. OpenRouter присылает такое тело и с
+// кодом 200, если ошибка случилась у конечного провайдера модели.
+type apiError struct {
+	Code    json.RawMessage `json:"code"`
+	Message string          `json:"message"`
+}
+
+// Complete отправляет сообщения модели AI_MODEL и возвращает текст ответа.
+// Completion возвращается и вместе с ошибкой: в нём расход на попытки,
+// которые модель начала, но не закончила.
+//
+// Каждая попытка ограничена AI_TIMEOUT_SECONDS. Ответы 429 и 5xx, таймауты
+// и сетевые ошибки повторяются до AI_MAX_RETRIES раз с паузой со случайным
+// разбросом, не короче Retry-After. Если модель так и не ответила или её
+// автомат защиты открыт, по очереди пробуются AI_FALLBACK_MODELS. Ошибки
+// провайдера возвращаются как *ProviderError.
+func (c *Client) Complete(ctx context.Context, messages []utils.OpenRouterMessage) (Completion, error) {
+	return c.complete(ctx, messages, nil)
+}
+
+// Stream запрашивает ответ потоком (SSE) и передаёт его части в onDelta по
+// мере генерации. Повторы и запасные модели работают как в Complete, но
+// только пока не пришла первая часть: после этого ошибка возвращается
+// сразу, иначе пользователь увидел бы начало другого ответа.
+func (c *Client) Stream(ctx context.Context, messages []utils.OpenRouterMessage, onDelta func(string)) (Completion, error) {
+	return c.complete(ctx, messages, onDelta)
+}
+
+func (c *Client) complete(ctx context.Context, messages []utils.OpenRouterMessage, onDelta func(string)) (Completion, error) {
+	streamed := false
+	if onDelta != nil {
+		forward := onDelta
+		onDelta = func(delta string) {
+			streamed = true
+			forward(delta)
+		}
+	}
+
+	// Расход оборвавшихся попыток тоже оплачивается и учитывается
+	failed := Completion{Model: c.models[0]}
+	var lastErr error
+	for _, model := range c.models {
+		completion, err := c.completeWith(ctx, model, messages, onDelta, &streamed)
+		if completion.Usage.Tokens() > 0 {
+			failed.Model = model
+		}
+		failed.Usage.add(completion.Usage)
+		if err == nil {
+			completion.Usage = failed.Usage
+			return completion, nil
+		}
+		if ctx.Err() != nil {
+			return failed, ctx.Err()
+		}
+		c.logger.Warnf("AI: модель %s не ответила: %v", model, err)
+		if streamed {
+			return failed, err
+		}
+		lastErr = err
+	}
+	return failed, lastErr
+}
+
+// observeUsage дополняет расход попытки стоимостью и записывает его в
+// метрики. Если провайдер не прислал стоимость, она оценивается по
+// AI_PRICES.
+func (c *Client) observeUsage(model string, usage *Usage) {
+	if usage.Cost == 0 {
+		if p, ok := c.prices[model]; ok {
+			usage.Cost = p.cost(*usage)
+		}
+	}
+
+	c.metrics.AITokensTotal.WithLabelValues(model, "prompt").Add(float64(usage.PromptTokens))
+	c.metrics.AITokensTotal.WithLabelValues(model, "completion").Add(float64(usage.CompletionTokens))
+	c.metrics.AICostDollarsTotal.WithLabelValues(model).Add(usage.Cost)
+}
+
+// completeWith запрашивает ответ у модели model с повторами. При ошибке
+// возвращает Completion с суммарным расходом неудачных попыток.
+func (c *Client) completeWith(ctx context.Context, model string, messages []utils.OpenRouterMessage, onDelta func(string), streamed *bool) (Completion, error) {
+	failed := Completion{Model: model}
+	b := c.breakers[model]
+	if !b.allow() {
+		err := &ProviderError{Kind: KindCircuitOpen, Model: model}
+		c.metrics.AIErrorsTotal.WithLabelValues(model, err.Kind).Inc()
+		return failed, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		var completion Completion
+		var err error
+		if onDelta != nil {
+			completion, err = c.sendStream(ctx, model, messages, onDelta)
+		} else {
+			completion, err = c.send(ctx, model, messages)
+		}
+		c.metrics.AIRequestDuration.WithLabelValues(model).Observe(time.Since(start).Seconds())
+		c.observeUsage(model, &completion.Usage)
+		failed.Usage.add(completion.Usage)
+		if err == nil {
+			c.metrics.AIRequestsTotal.WithLabelValues(model, "ok").Inc()
+			b.success()
+			c.metrics.AICircuitOpen.WithLabelValues(model).Set(0)
+			completion.Usage = failed.Usage
+			return completion, nil
+		}
+		if ctx.Err() != nil {
+			c.metrics.AIRequestsTotal.WithLabelValues(model, "cancelled").Inc()
+			b.release()
+			return failed, ctx.Err()
+		}
+
+		perr, _ := asProviderError(err)
+		c.metrics.AIRequestsTotal.WithLabelValues(model, "error").Inc()
+		c.metrics.AIErrorsTotal.WithLabelValues(model, perr.Kind).Inc()
+		if !perr.retryable() {
+			// Провайдер ответил, значит он доступен: дело в запросе
+			b.success()
+			return failed, err
+		}
+
+		wait, ok := c.retryDelay(attempt, perr.RetryAfter)
+		if !ok || *streamed {
+			if b.failure() {
+				c.metrics.AICircuitOpen.WithLabelValues(model).Set(1)
+				c.logger.Warnf("AI: запросы к модели %s приостановлены на %v", model, b.cooldown)
+			}
+			return failed, err
+		}
+
+		c.metrics.AIRetriesTotal.WithLabelValues(model).Inc()
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			b.release()
+			return failed, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryDelay возвращает паузу перед повтором attempt или false, если
+// повторять не нужно: попытки кончились или провайдер просит ждать дольше
+// AI_RETRY_MAX_WAIT_SECONDS. Во втором случае лучше сразу перейти к
+// запасной модели.
+func (c *Client) retryDelay(attempt int, retryAfter time.Duration) (time.Duration, bool) {
+	if attempt >= c.config.AI_MAX_RETRIES {
+		return 0, false
+	}
+	if retryAfter > 0 {
+		if retryAfter > time.Duration(c.config.AI_RETRY_MAX_WAIT_SECONDS)*time.Second {
+			return 0, false
+		}
+		return retryAfter + rand.N(retryBaseDelay), true
+	}
+
+	// Половина паузы фиксирована, половина случайна, чтобы повторы разных
+	// запросов не приходили к провайдеру одновременно
+	delay := retryBaseDelay << attempt
+	return delay/2 + rand.N(delay/2), true
+}
+
+// send выполняет одну попытку запроса к модели.
+func (c *Client) send(ctx context.Context, model string, messages []utils.OpenRouterMessage) (Completion, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.config.AI_TIMEOUT_SECONDS)*time.Second)
+	defer cancel()
+
+	resp, err := c.post(ctx, utils.OpenRouterRequest{Model: model, Messages: messages})
+	if err != nil {
+		return Completion{}, err
+	}
+	defer c.closeBody(resp)
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Completion{}, transportError(model, err)
+	}
+
+	var result struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+		Usage Usage     `json:"usage"`
+		Error *apiError `json:"error"`
+	}
+
+	if err := json.Unmarshal(bodyBytes, &result); err != nil {
+		return Completion{}, &ProviderError{Kind: KindBadResponse, Model: model, Status: resp.StatusCode, Err: err}
+	}
+	if result.Error != nil {
+		return Completion{}, result.Error.providerError(model, resp.StatusCode)
+	}
+	if len(result.Choices) == 0 {
+		return Completion{}, &ProviderError{Kind: KindBadResponse, Model: model, Status: resp.StatusCode, Message: "AI вернул пустой результат"}
+	}
+
+	return Completion{Text: result.Choices[0].Message.Content, Model: model, Usage: result.Usage}, nil
+}
+
+// sendStream выполняет одну попытку потокового запроса. Поток — события
+// SSE "data: {...}" с частями ответа, последнее — "data: [DONE]".
+// Строки-комментарии, которыми OpenRouter поддерживает соединение,
+// пропускаются.
+//
+// Общего срока у потока нет: длинный ответ может генерироваться дольше
+// AI_TIMEOUT_SECONDS. Ограничены ожидание первого события
+// (AI_TIMEOUT_SECONDS) и пауза между событиями
+// (AI_STREAM_IDLE_TIMEOUT_SECONDS).
+func (c *Client) sendStream(ctx context.Context, model string, messages []utils.OpenRouterMessage, onDelta func(string)) (Completion, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	stall := time.AfterFunc(time.Duration(c.config.AI_TIMEOUT_SECONDS)*time.Second, func() {
+		cancel(errStreamStalled)
+	})
+	defer stall.Stop()
+	streamError := func(err error) error {
+		if errors.Is(context.Cause(ctx), errStreamStalled) {
+			return &ProviderError{Kind: KindTimeout, Model: model, Err: errStreamStalled}
+		}
+		return err
+	}
+
+	resp, err := c.post(ctx, utils.OpenRouterRequest{
+		Model:         model,
+		Messages:      messages,
+		Stream:        true,
+		StreamOptions: &utils.StreamOptions{IncludeUsage: true},
+	})
+	if err != nil {
+		return Completion{}, streamError(err)
+	}
+	defer c.closeBody(resp)
+	idle := time.Duration(c.config.AI_STREAM_IDLE_TIMEOUT_SECONDS) * time.Second
+
+	completion := Completion{Model: model}
+	var text strings.Builder
+	done := false
+
+	// Модель уже начала отвечать, и запрос будет оплачен. Если поток
+	// оборвался до события с usage, расход оценивается по длине текста
+	fail := func(err error) (Completion, error) {
+		if completion.Usage.Tokens() == 0 {
+			completion.Usage = Usage{
+				PromptTokens:     EstimatePromptTokens(messages),
+				CompletionTokens: EstimateTokens(text.String()),
+			}
+			completion.Usage.TotalTokens = completion.Usage.Tokens()
+		}
+		return Completion{Model: model, Usage: completion.Usage}, err
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxStreamEvent)
+	for !done && scanner.Scan() {
+		stall.Reset(idle)
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			done = true
+			continue
+		}
+
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+			} `json:"choices"`
+			Usage *Usage    `json:"usage"`
+			Error *apiError `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fail(&ProviderError{Kind: KindBadResponse, Model: model, Status: resp.StatusCode, Err: err})
+		}
+		if chunk.Error != nil {
+			return fail(chunk.Error.providerError(model, resp.StatusCode))
+		}
+		if chunk.Usage != nil {
+			completion.Usage = *chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != "" {
+				text.WriteString(choice.Delta.Content)
+				onDelta(choice.Delta.Content)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fail(streamError(transportError(model, err)))
+	}
+	if !done {
+		return fail(&ProviderError{Kind: KindNetwork, Model: model, Message: "поток ответа оборвался"})
+	}
+	if text.Len() == 0 {
+		return fail(&ProviderError{Kind: KindBadResponse, Model: model, Status: resp.StatusCode, Message: "AI вернул пустой результат"})
+	}
+
+	completion.Text = text.String()
+	return completion, nil
+}
+
+// post отправляет запрос и возвращает ответ со статусом 200. Иначе тело
+// закрывается, а ошибка возвращается как *ProviderError.
+func (c *Client) post(ctx context.Context, body utils.OpenRouterRequest) (*http.Response, error) {
+	reqBytes, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, openRouterURL, bytes.NewBuffer(reqBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.config.AI_API_KEY)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, transportError(body.Model, err)
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer c.closeBody(resp)
+
+	errBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	var result struct {
+		Error *apiError `json:"error"`
+	}
+	perr := &ProviderError{
+		Kind:       statusKind(resp.StatusCode),
+		Model:      body.Model,
+		Status:     resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+	if json.Unmarshal(errBody, &result) == nil && result.Error != nil {
+		perr.Message = result.Error.Message
+	}
+	return nil, perr
+}
+
+func (c *Client) closeBody(resp *http.Response) {
+	if err := resp.Body.Close(); err != nil {
+		c.logger.Errorf("Failed to close response body: %v", err)
+	}
+}
+
+// providerError — ошибка, присланная в теле ответа со статусом status.
+// Класс определяется по коду ошибки, если он числовой.
+func (e *apiError) providerError(model string, status int) *ProviderError {
+	kind := KindServer
+	if code, err := strconv.Atoi(strings.Trim(string(e.Code), `"`)); err == nil {
+		kind = statusKind(code)
+	}
+	return &ProviderError{Kind: kind, Model: model, Status: status, Message: e.Message}
+}
+
+func transportError(model string, err error) *ProviderError {
+	kind := KindNetwork
+	if errors.Is(err, context.DeadlineExceeded) {
+		kind = KindTimeout
+	}
+	return &ProviderError{Kind: kind, Model: model, Err: err}
+}
+
+func statusKind(status int) string {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return KindRateLimited
+	case status == http.StatusRequestTimeout:
+		return KindTimeout
+	case status >= 500:
+		return KindServer
+	default:
+		return KindClient
+	}
+}
+
+// parseRetryAfter разбирает Retry-After в секундах или в виде HTTP-даты.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
+
+func NewClient(cfg *config.Config, prometheus *metrics.Prometheus, logger *zap.SugaredLogger) *Client {
+	c := &Client{
+		config:     cfg,
+		httpClient: &http.Client{},
+		metrics:    prometheus,
+		logger:     logger,
+		breakers:   map[string]*breaker{},
+	}
+
+	prices, err := parsePrices(cfg.AI_PRICES)
+	if err != nil {
+		logger.Fatalf("Не удалось разобрать AI_PRICES: %v", err)
+	}
+	c.prices = prices
+
+	for _, model := range append([]string{cfg.AI_MODEL}, cfg.AI_FALLBACK_MODELS...) {
+		if slices.Contains(c.models, model) {
+			continue
+		}
+		c.models = append(c.models, model)
+		c.breakers[model] = &breaker{
+			threshold: cfg.AI_BREAKER_FAILURES,
+			cooldown:  time.Duration(cfg.AI_BREAKER_COOLDOWN_SECONDS) * time.Second,
+		}
+	}
+	return c
+}
@@ -0,0 +1,68 @@
+package ai
+
+import (
+	"errors"
+	"fmt"
+	errs "sentimenta/internal/errors"
+	"time"
+)
+
+// Классы ошибок провайдера. Используются как метка kind в метрике
+// ai_errors_total.
+const (
+	KindTimeout     = "timeout"
+	KindNetwork     = "network"
+	KindRateLimited = "rate_limited"
+	KindServer      = "server"
+	KindClient      = "client"
+	KindBadResponse = "bad_response"
+	KindCircuitOpen = "circuit_open"
+)
+
+// ProviderError — неудачный запрос к провайдеру. Для вызывающего кода
+// любая такая ошибка — errs.ErrAIUnavailable.
+type ProviderError struct {
+	Kind       string
+	Model      string
+	Status     int
+	RetryAfter time.Duration
+	Message    string
+	Err        error
+}
+
+func (e *ProviderError) Error() string {
+	msg := fmt.Sprintf("AI %s: %s", e.Model, e.Kind)
+	if e.Status != 0 {
+		msg += fmt.Sprintf(" (HTTP %d)", e.Status)
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *ProviderError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{errs.ErrAIUnavailable}
+	}
+	return []error{errs.ErrAIUnavailable, e.Err}
+}
+
+// retryable сообщает, имеет ли смысл повторить запрос. Ошибки 4xx, кроме
+// 429, повторять бесполезно: тот же запрос получит тот же ответ.
+func (e *ProviderError) retryable() bool {
+	switch e.Kind {
+	case KindTimeout, KindNetwork, KindRateLimited, KindServer:
+		return true
+	}
+	return false
+}
+
+func asProviderError(err error) (*ProviderError, bool) {
+	var perr *ProviderError
+	ok := errors.As(err, &perr)
+	return perr, ok
+}
@@ -0,0 +1,96 @@
+package ai
+
+import (
+	"fmt"
+	"sentimenta/internal/utils"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Usage — расход токенов на один ответ модели.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+	// Стоимость в долларах. OpenRouter присылает её сам, для остальных
+	// провайдеров она оценивается по AI_PRICES
+	Cost float64 `json:"cost"`
+}
+
+// Tokens — число токенов запроса и ответа.
+func (u Usage) Tokens() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+func (u *Usage) add(other Usage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+	u.Cost += other.Cost
+}
+
+// charsPerToken — грубая оценка длины токена. Для кириллицы токены
+// короче, чем для латиницы, поэтому оценка взята с запасом.
+const charsPerToken = 3
+
+// EstimateTokens оценивает число токенов в тексте, когда точного расхода
+// нет: ответ ещё не получен или оборвался до события с usage.
+func EstimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + charsPerToken - 1) / charsPerToken
+}
+
+// EstimatePromptTokens оценивает число токенов в сообщениях запроса.
+func EstimatePromptTokens(messages []utils.OpenRouterMessage) int {
+	tokens := 0
+	for _, message := range messages {
+		tokens += EstimateTokens(message.Content)
+	}
+	return tokens
+}
+
+// Completion — ответ модели. Если запрос не удался, но модель уже начала
+// отвечать, Usage содержит расход на оборвавшиеся ответы.
+type Completion struct {
+	Text string
+	// Model — модель, которая ответила: AI_MODEL или одна из запасных
+	Model string
+	Usage Usage
+}
+
+// price — цена модели в долларах за миллион токенов.
+type price struct {
+	prompt     float64
+	completion float64
+}
+
+func (p price) cost(u Usage) float64 {
+	return (float64(u.PromptTokens)*p.prompt + float64(u.CompletionTokens)*p.completion) / 1e6
+}
+
+// parsePrices разбирает AI_PRICES: "model=prompt:completion,..." в
+// долларах за миллион токенов.
+func parsePrices(value string) (map[string]price, error) {
+	prices := map[string]price{}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		model, rates, ok := strings.Cut(part, "=")
+		promptStr, completionStr, ok2 := strings.Cut(rates, ":")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("неверная цена модели %q", part)
+		}
+		prompt, err := strconv.ParseFloat(promptStr, 64)
+		if err != nil {
+			return nil, fmt.Errorf("неверная цена модели %q: %w", part, err)
+		}
+		completion, err := strconv.ParseFloat(completionStr, 64)
+		if err != nil {
+			return nil, fmt.Errorf("неверная цена модели %q: %w", part, err)
+		}
+		prices[strings.TrimSpace(model)] = price{prompt: prompt, completion: completion}
+	}
+	return prices, nil
+}
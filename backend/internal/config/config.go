@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"net/http"
 	"os"
 	"strconv"
 	"strings"
@@ -16,8 +17,17 @@ type Config struct {
 	POSTGRES_PASSWORD string
 	POSTGRES_DB       string
 
-	JWT_COOKIE_NAME string
-	JWT_SECRET      string
+	JWT_COOKIE_NAME    string
+	JWT_SECRET         string
+	JWT_ISSUER         string
+	JWT_AUDIENCE       string
+	JWT_LEEWAY_SECONDS int
+
+	// Если задан JWT_KEYS_DIR, токены подписываются ключами из каталога
+	JWT_KEYS_DIR          string
+	JWT_ALG               string
+	JWT_KEY_ROTATION_DAYS int
+	JWT_KEY_OVERLAP_DAYS  int
 
 	GOOGLE_CLIENT_ID       string
 	GOOGLE_CLIENT_SECRET   string
@@ -27,128 +37,149 @@ type Config struct {
 	GITHUB_CLIENT_SECRET   string
 	GITHUB_CLIENT_CALLBACK string
 
-	SYSTEM_PROMPT string
-	AI_API_KEY    string
-	AI_ENABLED    bool
-	AI_MODEL      string
+	AI_API_KEY string
+	AI_ENABLED bool
+	AI_MODEL   string
+	// Модели, которые пробуются по порядку, если AI_MODEL недоступна
+	AI_FALLBACK_MODELS []string
+	// Таймаут одной попытки запроса к модели. При потоковой генерации —
+	// ожидание первой части ответа
+	AI_TIMEOUT_SECONDS int
+	// Предельная пауза между частями потокового ответа
+	AI_STREAM_IDLE_TIMEOUT_SECONDS int
+	// Сколько раз повторять запрос при 429, 5xx и сетевых ошибках
+	AI_MAX_RETRIES int
+	// Если Retry-After длиннее, запрос не повторяется, а уходит запасной модели
+	AI_RETRY_MAX_WAIT_SECONDS int
+	// После стольких неудачных запросов подряд модель пропускается на
+	// AI_BREAKER_COOLDOWN_SECONDS
+	AI_BREAKER_FAILURES         int
+	AI_BREAKER_COOLDOWN_SECONDS int
+	// Цены моделей для оценки расходов: "model=prompt:completion,..." в
+	// долларах за миллион токенов. OpenRouter присылает стоимость сам
+	AI_PRICES string
+	// Лимиты токенов на пользователя за сутки и календарный месяц (UTC),
+	// 0 — без лимита
+	AI_QUOTA_DAILY_TOKENS   int
+	AI_QUOTA_MONTHLY_TOKENS int
+	// Отправлять совет по WebSocket частями по мере генерации
+	AI_STREAMING_ENABLED bool
+
+	// Версия системного промпта по умолчанию. Шаблоны встроены в
+	// internal/prompts, могут лежать в PROMPTS_DIR (<версия>.tmpl) или в БД
+	AI_PROMPT_VERSION string
+	// A/B-тест промптов: "v3:90,v4:10". Пустой — всем AI_PROMPT_VERSION
+	AI_PROMPT_EXPERIMENT string
+	// Тон советов, подставляется в шаблон как {{.Style}}
+	AI_ADVICE_STYLE     string
+	PROMPTS_DIR         string
+	AI_FEEDBACK_ENABLED bool
+
+	ADVICE_GENERATE_LIMIT_PER_HOUR int
+	ADVICE_FEEDBACK_LENGTH_MAX     int
+
+	SAFETY_ENABLED          bool
+	SAFETY_AI_CHECK_ENABLED bool
+	SAFETY_CRISIS_MODE      string
+	SAFETY_LEXICON_DIR      string
+	SAFETY_RESOURCES_PATH   string
+	// Проверка моделью идёт внутри запроса на сохранение записи, поэтому
+	// у неё свой короткий таймаут
+	SAFETY_AI_TIMEOUT_SECONDS int
+
+	PII_REDACTION_ENABLED bool
+	PII_NAMES_PATH        string
+
+	ENCRYPTION_MASTER_KEY    string
+	ENCRYPTION_MASTER_KEY_ID string
+	ENCRYPTION_KEY_FILE      string
+
+	ACCOUNT_DELETION_GRACE_DAYS int
+
+	RATE_LIMIT_STORE                string
+	RATE_LIMIT_AUTH_PER_MINUTE      int
+	RATE_LIMIT_AUTH_DELAY_AFTER     int
+	RATE_LIMIT_AUTH_LOCKOUT_AFTER   int
+	RATE_LIMIT_AUTH_LOCKOUT_MINUTES int
+	// Неудачи входа в один аккаунт со всех IP: после DELAY_AFTER неудач
+	// растущая пауза, но не дольше MAX_DELAY_SECONDS и без блокировки
+	RATE_LIMIT_ACCOUNT_DELAY_AFTER       int
+	RATE_LIMIT_ACCOUNT_MAX_DELAY_SECONDS int
+	RATE_LIMIT_EXPORT_PER_DAY            int
 
 	PASSWORD_LENGTH_MIN    int
 	MOOD_DESC_LENGTH_MAX   int
 	MOOD_EMOTES_LENGTH_MAX int
 
+	// Минимальная оценка энтропии нового пароля в битах, 0 — не проверять
+	PASSWORD_MIN_ENTROPY_BITS int
+	// Фильтр Блума утёкших паролей, см. команду password bloom
+	PASSWORD_BREACHED_FILE string
+
+	// Алгоритм для новых хешей паролей: argon2id или bcrypt. Хеши с
+	// другим алгоритмом или параметрами пересчитываются при входе
+	PASSWORD_HASH_ALG  string
+	ARGON2_MEMORY_KB   int
+	ARGON2_ITERATIONS  int
+	ARGON2_PARALLELISM int
+	BCRYPT_COST        int
+
 	JWT_HTTP_ONLY bool
 	JWT_SECURE    bool
+	JWT_SAME_SITE http.SameSite
+
+	// Двойная отправка CSRF-токена: cookie csrf_token и заголовок
+	// X-CSRF-Token, который отправляет клиент
+	CSRF_ENABLED bool
 
 	REGISTRATION_ENABLED bool
 
 	ALLOWED_ORIGINS []string
+	// Адреса прокси (CIDR), которым можно верить в X-Forwarded-For. Пустой
+	// список — loopback и частные сети, как у nginx из docker-compose
+	TRUSTED_PROXIES []string
+
+	// Вход по ссылке из письма. Ссылка ведёт на страницу клиента
+	// MAGIC_LINK_URL, которая передаёт токен в /api/auth/magic-link/verify
+	MAGIC_LINK_ENABLED     bool
+	MAGIC_LINK_URL         string
+	MAGIC_LINK_TTL_MINUTES int
+
+	SMTP_HOST     string
+	SMTP_PORT     string
+	SMTP_USERNAME string
+	SMTP_PASSWORD string
+	SMTP_FROM     string
+
+	// Ключи доступа (passkeys) включены, если задан WEBAUTHN_RP_ID — домен
+	// сайта без схемы и порта
+	WEBAUTHN_RP_ID   string
+	WEBAUTHN_RP_NAME string
+	WEBAUTHN_ORIGINS []string
+
+	// Пользователи, которым при запуске назначается роль администратора
+	ADMIN_USER_IDS []string
 }
 
 func NewConfig() *Config {
 	err := godotenv.Load()
 	if err != nil {
-		fmt.Printf("не удалось загрузить .env: %v\n", err)
+		fmt.Fprintf(os.Stderr, "не удалось загрузить .env: %v\n", err)
 	}
 
 	passwordLenMin, err := strconv.Atoi(os.Getenv("PUBLIC_PASSWORD_LENGTH_MIN"))
 	if err != nil {
-		fmt.Printf("не удалось преобразовать переменную PASSWORD_LENGTH_MIN в целое число: %v\n", err)
+		fmt.Fprintf(os.Stderr, "не удалось преобразовать переменную PASSWORD_LENGTH_MIN в целое число: %v\n", err)
 	}
 	moodDescLenMax, err := strconv.Atoi(os.Getenv("PUBLIC_MOOD_DESC_LENGTH_MAX"))
 	if err != nil {
-		fmt.Printf("не удалось преобразовать переменную MOOD_DESC_LENGTH_MAX в целое число: %v\n", err)
+		fmt.Fprintf(os.Stderr, "не удалось преобразовать переменную MOOD_DESC_LENGTH_MAX в целое число: %v\n", err)
 	}
 	moodEmotesLenMax, err := strconv.Atoi(os.Getenv("PUBLIC_MOOD_EMOTES_LENGTH_MAX"))
 	if err != nil {
-		fmt.Printf("не удалось преобразовать переменную MOOD_EMOTES_LENGTH_MAX в целое число: %v\n", err)
+		fmt.Fprintf(os.Stderr, "не удалось преобразовать переменную MOOD_EMOTES_LENGTH_MAX в целое число: %v\n", err)
 	}
 
-	systemPrompt := `
-
-You are a caring mental health assistant. You receive an "AdviceRequest" object containing:
-
-* "previous_advice": a previous piece of advice, if any.
-* "last_mood": the most recent mood entry.
-* "moods": an array of previous mood entries (excluding "last_mood").
-
-Each mood entry (both "last_mood" and items in "moods") has the following structure:
-
-* "score" — mood level (from 1 to 5),
-* "emotions" — emotions experienced,
-* "description" — user's comment (can be empty),
-* "date" — date of the entry.
-
-Your task is to generate a short but helpful piece of advice that is **primarily based on the "last_mood" entry**, while **also lightly considering the general trend in "moods"**. If "previous_advice" is present, avoid repeating it. Do **not** summarize or restate the input data — only provide a direct and meaningful conclusion.
-
-The advice should be concise (2–3 sentences) and supportive — aimed at improving or maintaining the person's emotional well-being.
-
-**Important:Respond in the same language as last_mood.
-
-Examples:
-
-Input:
-{
-  "previous_advice": "Старайся больше гулять на свежем воздухе.",
-  "last_mood": {
-    "score": 2,
-    "emotions": "усталость, тревога",
-    "description": "Много дел, ничего не успеваю.",
-    "date": "21.05.2025"
-  },
-  "moods": [
-    {
-      "score": 3,
-      "emotions": "раздражение",
-      "description": "Сложный день.",
-      "date": "20.05.2025"
-    },
-    {
-      "score": 4,
-      "emotions": "спокойствие",
-      "description": "",
-      "date": "19.05.2025"
-    }
-  ]
-}
-
-Your response:
-Сейчас важно не гнаться за всем сразу — выбери одну-две задачи, которые реально посильны, и дай себе право отложить остальное. Ты не обязан быть продуктивным всё время, особенно когда усталость и тревога накапливаются.
-
-Input:
-{
-  "previous_advice": "Try disconnecting from social media for a bit and going for a walk.",
-  "last_mood": {
-    "score": 1,
-    "emotions": "overwhelmed, anxious",
-    "description": "Felt like everything was crashing down. Too many tasks, not enough time or energy.",
-    "date": "2025-06-28"
-  },
-  "moods": [
-    {
-      "score": 3,
-      "emotions": "frustration, fatigue",
-      "description": "Had trouble focusing. Kept getting distracted. Still managed to push through a bit.",
-      "date": "2025-06-27"
-    },
-    {
-      "score": 5,
-      "emotions": "motivated, optimistic",
-      "description": "Woke up feeling like I could actually handle things. Got a lot done.",
-      "date": "2025-06-26"
-    },
-    {
-      "score": 2,
-      "emotions": "loneliness, restlessness",
-      "description": "Felt disconnected from everyone. Music helped a little.",
-      "date": "2025-06-25"
-    }
-  ]
-}
-
-Your response:
-You're carrying a lot right now — it’s okay to stop and *just breathe*. Try picking the single smallest task you can do, and let that be enough for today. You deserve compassion, especially from yourself.
-`
-
 	return &Config{
 		POSTGRES_HOST:     os.Getenv("POSTGRES_HOST"),
 		POSTGRES_PORT:     os.Getenv("POSTGRES_PORT"),
@@ -156,8 +187,16 @@ You're carrying a lot right now — it’s okay to stop and *just breathe*. Try
 		POSTGRES_PASSWORD: os.Getenv("POSTGRES_PASSWORD"),
 		POSTGRES_DB:       os.Getenv("POSTGRES_DB"),
 
-		JWT_COOKIE_NAME: "access_token",
-		JWT_SECRET:      os.Getenv("JWT_SECRET"),
+		JWT_COOKIE_NAME:    "access_token",
+		JWT_SECRET:         os.Getenv("JWT_SECRET"),
+		JWT_ISSUER:         envString("JWT_ISSUER", "sentimenta"),
+		JWT_AUDIENCE:       envString("JWT_AUDIENCE", "sentimenta-api"),
+		JWT_LEEWAY_SECONDS: envInt("JWT_LEEWAY_SECONDS", 30),
+
+		JWT_KEYS_DIR:          os.Getenv("JWT_KEYS_DIR"),
+		JWT_ALG:               envString("JWT_ALG", "EdDSA"),
+		JWT_KEY_ROTATION_DAYS: envInt("JWT_KEY_ROTATION_DAYS", 30),
+		JWT_KEY_OVERLAP_DAYS:  envInt("JWT_KEY_OVERLAP_DAYS", 30),
 
 		GOOGLE_CLIENT_ID:       os.Getenv("PUBLIC_GOOGLE_CLIENT_ID"),
 		GOOGLE_CLIENT_SECRET:   os.Getenv("GOOGLE_CLIENT_SECRET"),
@@ -167,22 +206,147 @@ You're carrying a lot right now — it’s okay to stop and *just breathe*. Try
 		GITHUB_CLIENT_SECRET:   os.Getenv("GITHUB_CLIENT_SECRET"),
 		GITHUB_CLIENT_CALLBACK: os.Getenv("GITHUB_CLIENT_CALLBACK"),
 
-		SYSTEM_PROMPT: systemPrompt,
-		AI_API_KEY:    os.Getenv("AI_API_KEY"),
-		AI_ENABLED:    os.Getenv("PUBLIC_AI_ENABLED") == "true",
-		AI_MODEL:      os.Getenv("AI_MODEL"),
+		AI_API_KEY: os.Getenv("AI_API_KEY"),
+		AI_ENABLED: os.Getenv("PUBLIC_AI_ENABLED") == "true",
+		AI_MODEL:   os.Getenv("AI_MODEL"),
+
+		AI_FALLBACK_MODELS:             envList("AI_FALLBACK_MODELS"),
+		AI_TIMEOUT_SECONDS:             envInt("AI_TIMEOUT_SECONDS", 30),
+		AI_STREAM_IDLE_TIMEOUT_SECONDS: envInt("AI_STREAM_IDLE_TIMEOUT_SECONDS", 15),
+		AI_MAX_RETRIES:                 envInt("AI_MAX_RETRIES", 2),
+		AI_RETRY_MAX_WAIT_SECONDS:      envInt("AI_RETRY_MAX_WAIT_SECONDS", 20),
+		AI_BREAKER_FAILURES:            envInt("AI_BREAKER_FAILURES", 5),
+		AI_BREAKER_COOLDOWN_SECONDS:    envInt("AI_BREAKER_COOLDOWN_SECONDS", 60),
+		AI_PRICES:                      os.Getenv("AI_PRICES"),
+		AI_QUOTA_DAILY_TOKENS:          envInt("AI_QUOTA_DAILY_TOKENS", 0),
+		AI_QUOTA_MONTHLY_TOKENS:        envInt("AI_QUOTA_MONTHLY_TOKENS", 0),
+		AI_STREAMING_ENABLED:           os.Getenv("AI_STREAMING_ENABLED") != "false",
+
+		AI_PROMPT_VERSION:    envString("AI_PROMPT_VERSION", "v3"),
+		AI_PROMPT_EXPERIMENT: os.Getenv("AI_PROMPT_EXPERIMENT"),
+		AI_ADVICE_STYLE:      envString("AI_ADVICE_STYLE", "supportive"),
+		PROMPTS_DIR:          os.Getenv("PROMPTS_DIR"),
+		AI_FEEDBACK_ENABLED:  os.Getenv("AI_FEEDBACK_ENABLED") == "true",
+
+		ADVICE_GENERATE_LIMIT_PER_HOUR: envInt("ADVICE_GENERATE_LIMIT_PER_HOUR", 5),
+		ADVICE_FEEDBACK_LENGTH_MAX:     envInt("ADVICE_FEEDBACK_LENGTH_MAX", 1000),
+
+		SAFETY_ENABLED:          os.Getenv("SAFETY_ENABLED") != "false",
+		SAFETY_AI_CHECK_ENABLED: os.Getenv("SAFETY_AI_CHECK_ENABLED") == "true",
+		SAFETY_CRISIS_MODE:      envString("SAFETY_CRISIS_MODE", "replace"),
+		SAFETY_LEXICON_DIR:      os.Getenv("SAFETY_LEXICON_DIR"),
+		SAFETY_RESOURCES_PATH:   os.Getenv("SAFETY_RESOURCES_PATH"),
+
+		SAFETY_AI_TIMEOUT_SECONDS: envInt("SAFETY_AI_TIMEOUT_SECONDS", 5),
+
+		PII_REDACTION_ENABLED: os.Getenv("PII_REDACTION_ENABLED") != "false",
+		PII_NAMES_PATH:        os.Getenv("PII_NAMES_PATH"),
+
+		ENCRYPTION_MASTER_KEY:    os.Getenv("ENCRYPTION_MASTER_KEY"),
+		ENCRYPTION_MASTER_KEY_ID: os.Getenv("ENCRYPTION_MASTER_KEY_ID"),
+		ENCRYPTION_KEY_FILE:      os.Getenv("ENCRYPTION_KEY_FILE"),
+
+		ACCOUNT_DELETION_GRACE_DAYS: envInt("ACCOUNT_DELETION_GRACE_DAYS", 14),
+
+		RATE_LIMIT_STORE:                     envString("RATE_LIMIT_STORE", "memory"),
+		RATE_LIMIT_AUTH_PER_MINUTE:           envInt("RATE_LIMIT_AUTH_PER_MINUTE", 10),
+		RATE_LIMIT_AUTH_DELAY_AFTER:          envInt("RATE_LIMIT_AUTH_DELAY_AFTER", 3),
+		RATE_LIMIT_AUTH_LOCKOUT_AFTER:        envInt("RATE_LIMIT_AUTH_LOCKOUT_AFTER", 10),
+		RATE_LIMIT_AUTH_LOCKOUT_MINUTES:      envInt("RATE_LIMIT_AUTH_LOCKOUT_MINUTES", 15),
+		RATE_LIMIT_ACCOUNT_DELAY_AFTER:       envInt("RATE_LIMIT_ACCOUNT_DELAY_AFTER", 5),
+		RATE_LIMIT_ACCOUNT_MAX_DELAY_SECONDS: envInt("RATE_LIMIT_ACCOUNT_MAX_DELAY_SECONDS", 30),
+		RATE_LIMIT_EXPORT_PER_DAY:            envInt("RATE_LIMIT_EXPORT_PER_DAY", 5),
 
 		PASSWORD_LENGTH_MIN:    passwordLenMin,
 		MOOD_DESC_LENGTH_MAX:   moodDescLenMax,
 		MOOD_EMOTES_LENGTH_MAX: moodEmotesLenMax,
 
-		JWT_HTTP_ONLY: false,
-		JWT_SECURE:    false,
+		PASSWORD_MIN_ENTROPY_BITS: envInt("PASSWORD_MIN_ENTROPY_BITS", 28),
+		PASSWORD_BREACHED_FILE:    os.Getenv("PASSWORD_BREACHED_FILE"),
+
+		PASSWORD_HASH_ALG:  envString("PASSWORD_HASH_ALG", "argon2id"),
+		ARGON2_MEMORY_KB:   envInt("ARGON2_MEMORY_KB", 64*1024),
+		ARGON2_ITERATIONS:  envInt("ARGON2_ITERATIONS", 3),
+		ARGON2_PARALLELISM: envInt("ARGON2_PARALLELISM", 2),
+		BCRYPT_COST:        envInt("BCRYPT_COST", 12),
+
+		JWT_HTTP_ONLY: os.Getenv("JWT_HTTP_ONLY") != "false",
+		JWT_SECURE:    os.Getenv("JWT_SECURE") != "false",
+		JWT_SAME_SITE: envSameSite("JWT_SAME_SITE", http.SameSiteLaxMode),
+
+		CSRF_ENABLED: os.Getenv("CSRF_ENABLED") != "false",
 
 		REGISTRATION_ENABLED: os.Getenv("PUBLIC_REGISTRATION_ENABLED") == "true",
 
 		ALLOWED_ORIGINS: strings.Split(os.Getenv("ALLOWED_ORIGINS"), ","),
+		TRUSTED_PROXIES: envList("TRUSTED_PROXIES"),
+
+		MAGIC_LINK_ENABLED:     os.Getenv("MAGIC_LINK_ENABLED") == "true",
+		MAGIC_LINK_URL:         os.Getenv("MAGIC_LINK_URL"),
+		MAGIC_LINK_TTL_MINUTES: envInt("MAGIC_LINK_TTL_MINUTES", 15),
+
+		SMTP_HOST:     os.Getenv("SMTP_HOST"),
+		SMTP_PORT:     envString("SMTP_PORT", "587"),
+		SMTP_USERNAME: os.Getenv("SMTP_USERNAME"),
+		SMTP_PASSWORD: os.Getenv("SMTP_PASSWORD"),
+		SMTP_FROM:     envString("SMTP_FROM", "Sentimenta <no-reply@localhost>"),
+
+		WEBAUTHN_RP_ID:   os.Getenv("WEBAUTHN_RP_ID"),
+		WEBAUTHN_RP_NAME: envString("WEBAUTHN_RP_NAME", "Sentimenta"),
+		WEBAUTHN_ORIGINS: envList("WEBAUTHN_ORIGINS"),
+
+		ADMIN_USER_IDS: envList("ADMIN_USER_IDS"),
+	}
+}
+
+func envString(name string, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// envList читает список значений, разделённых запятыми, пропуская пустые.
+func envList(name string) []string {
+	var result []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// envSameSite читает политику SameSite для cookie: lax, strict или none.
+func envSameSite(name string, def http.SameSite) http.SameSite {
+	switch strings.ToLower(os.Getenv(name)) {
+	case "":
+		return def
+	case "lax":
+		return http.SameSiteLaxMode
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		fmt.Fprintf(os.Stderr, "неизвестное значение %s: %s\n", name, os.Getenv(name))
+		return def
+	}
+}
+
+// envInt читает целочисленную переменную окружения, возвращая def,
+// если переменная не задана или не является числом.
+func envInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "не удалось преобразовать переменную %s в целое число: %v\n", name, err)
+		return def
 	}
+	return result
 }
 
 //  = 5432
@@ -12,14 +12,23 @@ import (
 	gormLogger "gorm.io/gorm/logger"
 )
 
+// InitDB подключается к БД и применяет миграции.
 func InitDB(cfg *c.Config, log *zap.SugaredLogger, prometheus *metrics.Prometheus) *gorm.DB {
+	db := Connect(cfg, log, prometheus, gormLogger.Default.LogMode(gormLogger.Info))
+	Migrate(db, log)
+	return db
+}
+
+// Connect подключается к БД без миграций. sqlLogger задаёт, куда и
+// насколько подробно логировать запросы.
+func Connect(cfg *c.Config, log *zap.SugaredLogger, prometheus *metrics.Prometheus, sqlLogger gormLogger.Interface) *gorm.DB {
 	dsn := fmt.Sprintf("host=%v user=%v password=%v dbname=%v port=%v",
 		cfg.POSTGRES_HOST, cfg.POSTGRES_USER, cfg.POSTGRES_PASSWORD, cfg.POSTGRES_DB, cfg.POSTGRES_PORT)
 
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
 		Logger: &metrics.GormLogger{
 			Prometheus: prometheus,
-			Interface:  gormLogger.Default.LogMode(gormLogger.Info),
+			Interface:  sqlLogger,
 		},
 	})
 	if err != nil {
@@ -27,9 +36,89 @@ func InitDB(cfg *c.Config, log *zap.SugaredLogger, prometheus *metrics.Prometheu
 	}
 
 	log.Info("БД: Подключение | Успешно.")
-	if err := db.AutoMigrate(models.User{}, models.Mood{}, models.Advice{}); err != nil {
+	return db
+}
+
+func Migrate(db *gorm.DB, log *zap.SugaredLogger) {
+	if err := dedupeAdvices(db); err != nil {
+		log.Fatalf("Не удалось произвести миграцию: %v", err)
+	}
+	if err := db.AutoMigrate(models.User{}, models.Mood{}, models.Advice{}, models.AdviceVersion{}, models.UserKey{}, models.AccountDeletion{}, models.DeletionCode{}, models.SecurityEvent{}, models.RateLimit{}, models.Passkey{}, models.WebAuthnSession{}, models.MagicLink{}, models.PromptTemplate{}, models.AIUsage{}); err != nil {
 		log.Fatalf("Не удалось произвести миграцию: %v", err)
 	}
 	log.Info("БД: Автомиграция | Успешно.")
-	return db
+}
+
+// dedupeAdvices готовит советы к уникальному индексу (user_id, date).
+// Раньше на одну дату могло быть несколько советов; теперь остаётся самый
+// новый, а остальные становятся его прежними версиями в advice_versions.
+// Миграция выполняется один раз: после неё AutoMigrate создаёт индекс, и
+// при следующих запусках она пропускается.
+//
+// Тексты советов переносятся как есть. Шифртекст, связанный со строкой
+// (enc:v2), появился вместе с индексом, поэтому в переносимых советах его
+// нет: v1 и открытый текст от строки не зависят.
+func dedupeAdvices(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&models.Advice{}) || db.Migrator().HasIndex(&models.Advice{}, "idx_advices_user_date") {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, column := range []string{"Model", "PromptVersion", "Version", "Rating", "FeedbackComment"} {
+			if !tx.Migrator().HasColumn(&models.Advice{}, column) {
+				if err := tx.Migrator().AddColumn(&models.Advice{}, column); err != nil {
+					return err
+				}
+			}
+		}
+		if err := tx.Migrator().AutoMigrate(&models.AdviceVersion{}); err != nil {
+			return err
+		}
+
+		const duplicates = `
+			WITH dup AS (
+				SELECT user_id, date FROM advices GROUP BY user_id, date HAVING COUNT(*) > 1
+			)`
+		// Текущий текст каждого совета на такую дату становится версией,
+		// если её ещё нет в истории
+		err := tx.Exec(duplicates + `
+			INSERT INTO advice_versions (advice_id, version, text, model, prompt_version, rating, feedback_comment, created_at)
+			SELECT a.uid, a.version, a.text, a.model, a.prompt_version, a.rating, a.feedback_comment, a.updated_at
+			FROM advices a JOIN dup ON a.user_id = dup.user_id AND a.date = dup.date
+			WHERE NOT EXISTS (
+				SELECT 1 FROM advice_versions v WHERE v.advice_id = a.uid AND v.version = a.version
+			)`).Error
+		if err != nil {
+			return err
+		}
+
+		// Вся история даты переходит к самому новому совету и нумеруется
+		// заново: сначала версии старых советов, последними — его собственные
+		err = tx.Exec(duplicates + `
+			UPDATE advice_versions v SET advice_id = h.survivor, version = h.rn
+			FROM (
+				SELECT v.uid,
+					MAX(a.uid) OVER (PARTITION BY a.user_id, a.date) AS survivor,
+					ROW_NUMBER() OVER (PARTITION BY a.user_id, a.date ORDER BY a.uid, v.version, v.uid) AS rn
+				FROM advice_versions v
+				JOIN advices a ON a.uid = v.advice_id
+				JOIN dup ON a.user_id = dup.user_id AND a.date = dup.date
+			) AS h
+			WHERE v.uid = h.uid`).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Exec(duplicates + `
+			UPDATE advices a SET version = (SELECT MAX(v.version) FROM advice_versions v WHERE v.advice_id = a.uid)
+			FROM dup
+			WHERE a.user_id = dup.user_id AND a.date = dup.date
+				AND a.uid = (SELECT MAX(b.uid) FROM advices b WHERE b.user_id = a.user_id AND b.date = a.date)`).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Exec(`
+			DELETE FROM advices a USING advices b
+			WHERE a.user_id = b.user_id AND a.date = b.date AND a.uid < b.uid`).Error
+	})
 }
@@ -0,0 +1,40 @@
+// Package encryption реализует шифрование полей на уровне репозиториев.
+//
+// Используется конвертное шифрование: у каждого пользователя есть свой
+// ключ данных (AES-256), которым шифруются Mood.Description, Mood.Emotions,
+// Advice.Text и AdviceVersion.Text. Ключи данных хранятся в таблице
+// user_keys в зашифрованном мастер-ключом виде. Мастер-ключ берётся из
+// ENCRYPTION_MASTER_KEY или из файла ENCRYPTION_KEY_FILE и в БД не попадает.
+//
+// Зашифрованное значение имеет вид "enc:v2:<версия ключа>:<base64>".
+// AAD шифртекста — таблица, колонка, uid записи и id пользователя, так что
+// значение, перенесённое в другую запись, не расшифруется. Ключи данных
+// так же связаны с пользователем и версией. Значения "enc:v1:" записаны
+// без AAD: они читаются и переводятся в v2 командой reencrypt.
+//
+// Значения без префикса "enc:" считаются открытым текстом, поэтому
+// включение шифрования не требует миграции: старые записи читаются как
+// есть и шифруются командой reencrypt (cmd). Открытый текст, который сам
+// начинается с "enc:", сохраняется с префиксом "enc:raw:".
+//
+// Ротация:
+//   - смена мастер-ключа: новый ключ добавляется в ENCRYPTION_KEY_FILE и
+//     назначается текущим через ENCRYPTION_MASTER_KEY_ID, после чего
+//     команда reencrypt перешифровывает ключи данных новым мастер-ключом;
+//   - смена ключей данных: reencrypt -rotate-data-keys создаёт новую
+//     версию ключа для каждого пользователя и перешифровывает все поля.
+//     Работающие серверы перечитывают активную версию раз в activeKeyTTL,
+//     перезапуск не нужен; значения, записанные старой версией за это
+//     время, читаются по-прежнему и перешифровываются повторным reencrypt.
+//
+// Поиск и статистика продолжают работать: репозитории расшифровывают поля
+// при чтении, поэтому сервисы, генерация советов и проверки безопасности
+// получают открытый текст. Статистика (оценки, score, даты, модели)
+// строится по незашифрованным колонкам. Фильтрация по тексту на стороне
+// SQL (LIKE по description или emotions) невозможна, такой поиск нужно
+// выполнять после чтения записей пользователя.
+//
+// Записи в режиме сквозного шифрования (Mood.E2E) приходят уже
+// зашифрованными на клиенте. Конверт шифрует их ещё раз, а после
+// расшифровки сервисы получают шифротекст клиента, а не открытый текст.
+package encryption
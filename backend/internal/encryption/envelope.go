@@ -0,0 +1,455 @@
+package encryption
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"sentimenta/internal/config"
+	"sentimenta/internal/models"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// prefix — общий префикс значений, которые записывает Envelope.
+	prefix = "enc:"
+	// prefixV2 — шифртекст, связанный с полем записи через AAD.
+	prefixV2 = "enc:v2:"
+	// prefixV1 — шифртекст первой версии, без AAD. Такие значения только
+	// читаются и заменяются на v2 командой reencrypt.
+	prefixV1 = "enc:v1:"
+	// prefixRaw помечает открытый текст, который сам начинается с prefix,
+	// чтобы его не приняли за шифртекст.
+	prefixRaw = "enc:raw:"
+	keySize   = 32
+	// activeKeyTTL — как долго Envelope доверяет запомненной активной
+	// версии ключа, прежде чем перечитать её из хранилища. Так серверы
+	// подхватывают ротацию, сделанную другим процессом (reencrypt).
+	activeKeyTTL = time.Minute
+)
+
+var ErrDisabled = errors.New("шифрование не настроено: нет мастер-ключа")
+var ErrUnknownMasterKey = errors.New("неизвестный мастер-ключ")
+
+// KeyStore хранит зашифрованные ключи данных пользователей.
+type KeyStore interface {
+	GetActiveUserKey(userID int) (models.UserKey, error)
+	GetUserKey(userID, version int) (models.UserKey, error)
+	GetUserKeys(userID int) ([]models.UserKey, error)
+	CreateUserKey(key *models.UserKey) error
+	UpdateUserKey(key *models.UserKey) error
+}
+
+// Field указывает, в каком поле какой записи хранится значение. Поле
+// входит в AAD шифртекста, поэтому значение нельзя перенести в другую
+// запись, колонку или к другому пользователю: оно не расшифруется.
+type Field struct {
+	Table  string
+	Column string
+	RowID  int
+}
+
+func (f Field) aad(userID int) []byte {
+	return []byte(fmt.Sprintf("%s.%s:%d:%d", f.Table, f.Column, f.RowID, userID))
+}
+
+// Cipher шифрует и расшифровывает поля записей конкретного пользователя.
+type Cipher interface {
+	Encrypt(userID int, field Field, plaintext string) (string, error)
+	Decrypt(userID int, field Field, value string) (string, error)
+}
+
+type Envelope struct {
+	store           KeyStore
+	masterKeys      map[string][]byte
+	currentMasterID string
+
+	mu     sync.RWMutex
+	keys   map[int]map[int][]byte
+	active map[int]activeVersion
+}
+
+// activeVersion — запомненная активная версия ключа пользователя и время,
+// когда она была прочитана из хранилища.
+type activeVersion struct {
+	version   int
+	checkedAt time.Time
+}
+
+func (e *Envelope) Enabled() bool {
+	return e.currentMasterID != ""
+}
+
+// Encrypt шифрует значение активным ключом пользователя. Если шифрование
+// выключено, значение возвращается как есть, а открытый текст, похожий на
+// шифртекст, помечается prefixRaw.
+func (e *Envelope) Encrypt(userID int, field Field, plaintext string) (string, error) {
+	if !e.Enabled() || plaintext == "" {
+		if strings.HasPrefix(plaintext, prefix) {
+			return prefixRaw + plaintext, nil
+		}
+		return plaintext, nil
+	}
+
+	version, key, err := e.activeKey(userID)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(key, []byte(plaintext), field.aad(userID))
+	if err != nil {
+		return "", err
+	}
+	return prefixV2 + strconv.Itoa(version) + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt расшифровывает значение поля field. Значения без префикса
+// считаются открытым текстом.
+func (e *Envelope) Decrypt(userID int, field Field, value string) (string, error) {
+	if plaintext, ok := strings.CutPrefix(value, prefixRaw); ok {
+		return plaintext, nil
+	}
+	var aad []byte
+	rest, ok := strings.CutPrefix(value, prefixV2)
+	if ok {
+		aad = field.aad(userID)
+	} else if rest, ok = strings.CutPrefix(value, prefixV1); !ok {
+		return value, nil
+	}
+	if !e.Enabled() {
+		return "", ErrDisabled
+	}
+
+	versionStr, encoded, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", fmt.Errorf("некорректный формат зашифрованного значения")
+	}
+	version, err := strconv.Atoi(versionStr)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := e.userKey(userID, version)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(key, sealed, aad)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// IsEncrypted сообщает, зашифровано ли значение.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefixV2) || strings.HasPrefix(value, prefixV1)
+}
+
+// KeyVersion возвращает версию ключа, которым зашифровано значение.
+func KeyVersion(value string) int {
+	rest, ok := strings.CutPrefix(value, prefixV2)
+	if !ok {
+		if rest, ok = strings.CutPrefix(value, prefixV1); !ok {
+			return 0
+		}
+	}
+	versionStr, _, _ := strings.Cut(rest, ":")
+	version, _ := strconv.Atoi(versionStr)
+	return version
+}
+
+// ActiveVersion возвращает версию активного ключа пользователя,
+// создавая ключ при необходимости.
+func (e *Envelope) ActiveVersion(userID int) (int, error) {
+	version, _, err := e.activeKey(userID)
+	return version, err
+}
+
+// RotateUserKey создаёт новую версию ключа данных пользователя.
+// Старые версии остаются для расшифровки ещё не перешифрованных данных.
+func (e *Envelope) RotateUserKey(userID int) (int, error) {
+	if !e.Enabled() {
+		return 0, ErrDisabled
+	}
+	keys, err := e.store.GetUserKeys(userID)
+	if err != nil {
+		return 0, err
+	}
+	version := 1
+	for _, k := range keys {
+		version = max(version, k.Version+1)
+	}
+	if _, err := e.createUserKey(userID, version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// RewrapUserKeys перешифровывает все ключи данных пользователя текущим
+// мастер-ключом, заодно связывая их с пользователем и версией через AAD.
+// Возвращает количество изменённых ключей.
+func (e *Envelope) RewrapUserKeys(userID int) (int, error) {
+	if !e.Enabled() {
+		return 0, ErrDisabled
+	}
+	keys, err := e.store.GetUserKeys(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, k := range keys {
+		if k.MasterKeyID == e.currentMasterID && k.Bound {
+			continue
+		}
+		dek, err := e.unwrap(k)
+		if err != nil {
+			return count, err
+		}
+		wrapped, err := seal(e.masterKeys[e.currentMasterID], dek, wrapAAD(k.UserID, k.Version))
+		if err != nil {
+			return count, err
+		}
+		k.WrappedKey = wrapped
+		k.MasterKeyID = e.currentMasterID
+		k.Bound = true
+		if err := e.store.UpdateUserKey(&k); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (e *Envelope) activeKey(userID int) (int, []byte, error) {
+	e.mu.RLock()
+	active, ok := e.active[userID]
+	key := e.keys[userID][active.version]
+	e.mu.RUnlock()
+	if ok && time.Since(active.checkedAt) < activeKeyTTL {
+		return active.version, key, nil
+	}
+
+	stored, err := e.store.GetActiveUserKey(userID)
+	if err != nil {
+		// Ключа ещё нет: создаём первую версию. Если его параллельно
+		// создал другой запрос, берём созданный.
+		if key, createErr := e.createUserKey(userID, 1); createErr == nil {
+			return 1, key, nil
+		}
+		if stored, err = e.store.GetActiveUserKey(userID); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	e.mu.RLock()
+	key, ok = e.keys[userID][stored.Version]
+	e.mu.RUnlock()
+	if !ok {
+		if key, err = e.unwrap(stored); err != nil {
+			return 0, nil, err
+		}
+	}
+	e.remember(userID, stored.Version, key, true)
+	return stored.Version, key, nil
+}
+
+func (e *Envelope) userKey(userID, version int) ([]byte, error) {
+	e.mu.RLock()
+	key, ok := e.keys[userID][version]
+	e.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	stored, err := e.store.GetUserKey(userID, version)
+	if err != nil {
+		return nil, err
+	}
+	key, err = e.unwrap(stored)
+	if err != nil {
+		return nil, err
+	}
+	e.remember(userID, version, key, false)
+	return key, nil
+}
+
+func (e *Envelope) createUserKey(userID, version int) ([]byte, error) {
+	dek := make([]byte, keySize)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+	wrapped, err := seal(e.masterKeys[e.currentMasterID], dek, wrapAAD(userID, version))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := e.store.CreateUserKey(&models.UserKey{
+		UserID:      userID,
+		Version:     version,
+		MasterKeyID: e.currentMasterID,
+		WrappedKey:  wrapped,
+		Bound:       true,
+		Active:      true,
+	}); err != nil {
+		return nil, err
+	}
+	e.remember(userID, version, dek, true)
+	return dek, nil
+}
+
+func (e *Envelope) unwrap(key models.UserKey) ([]byte, error) {
+	master, ok := e.masterKeys[key.MasterKeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMasterKey, key.MasterKeyID)
+	}
+	var aad []byte
+	if key.Bound {
+		aad = wrapAAD(key.UserID, key.Version)
+	}
+	return open(master, key.WrappedKey, aad)
+}
+
+// wrapAAD связывает зашифрованный ключ данных с его владельцем и версией,
+// чтобы строку user_keys нельзя было подменить чужим ключом.
+func wrapAAD(userID, version int) []byte {
+	return []byte(fmt.Sprintf("user_keys:%d:%d", userID, version))
+}
+
+func (e *Envelope) remember(userID, version int, key []byte, active bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.keys[userID] == nil {
+		e.keys[userID] = map[int][]byte{}
+	}
+	e.keys[userID][version] = key
+	if active {
+		e.active[userID] = activeVersion{version: version, checkedAt: time.Now()}
+	}
+}
+
+// seal шифрует данные AES-GCM с дополнительными данными aad и возвращает
+// nonce вместе с шифртекстом.
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(key, sealed, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("шифртекст слишком короткий")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// loadMasterKeys читает мастер-ключи из конфигурации. В файле ключей
+// каждая строка имеет вид "<id>:<ключ в base64>"; текущим считается ключ
+// ENCRYPTION_MASTER_KEY_ID, а если он не задан — последний в файле.
+func loadMasterKeys(cfg *config.Config) (map[string][]byte, string, error) {
+	keys := map[string][]byte{}
+	current := cfg.ENCRYPTION_MASTER_KEY_ID
+
+	add := func(id, encoded string) error {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return fmt.Errorf("мастер-ключ %s: %w", id, err)
+		}
+		if len(key) != keySize {
+			return fmt.Errorf("мастер-ключ %s должен быть длиной %d байт", id, keySize)
+		}
+		keys[id] = key
+		return nil
+	}
+
+	if cfg.ENCRYPTION_KEY_FILE != "" {
+		file, err := os.ReadFile(cfg.ENCRYPTION_KEY_FILE)
+		if err != nil {
+			return nil, "", err
+		}
+		lastID := ""
+		scanner := bufio.NewScanner(strings.NewReader(string(file)))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			id, encoded, ok := strings.Cut(line, ":")
+			if !ok {
+				return nil, "", fmt.Errorf("некорректная строка в файле ключей")
+			}
+			if err := add(id, encoded); err != nil {
+				return nil, "", err
+			}
+			lastID = id
+		}
+		if current == "" {
+			current = lastID
+		}
+	}
+
+	if cfg.ENCRYPTION_MASTER_KEY != "" {
+		if current == "" {
+			current = "default"
+		}
+		if err := add(current, cfg.ENCRYPTION_MASTER_KEY); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if current != "" {
+		if _, ok := keys[current]; !ok {
+			return nil, "", fmt.Errorf("%w: %s", ErrUnknownMasterKey, current)
+		}
+	}
+	return keys, current, nil
+}
+
+func NewEnvelope(cfg *config.Config, store KeyStore, log *zap.SugaredLogger) *Envelope {
+	masterKeys, current, err := loadMasterKeys(cfg)
+	if err != nil {
+		log.Fatalf("Не удалось загрузить мастер-ключ шифрования: %v", err)
+	}
+	if current == "" {
+		log.Warn("Шифрование полей выключено: мастер-ключ не задан")
+	}
+
+	return &Envelope{
+		store:           store,
+		masterKeys:      masterKeys,
+		currentMasterID: current,
+		keys:            map[int]map[int][]byte{},
+		active:          map[int]activeVersion{},
+	}
+}
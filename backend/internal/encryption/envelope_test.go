@@ -0,0 +1,355 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"sentimenta/internal/config"
+	"sentimenta/internal/models"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// memoryStore — KeyStore в памяти.
+type memoryStore struct {
+	keys []models.UserKey
+}
+
+func (s *memoryStore) GetActiveUserKey(userID int) (models.UserKey, error) {
+	for i := len(s.keys) - 1; i >= 0; i-- {
+		if s.keys[i].UserID == userID && s.keys[i].Active {
+			return s.keys[i], nil
+		}
+	}
+	return models.UserKey{}, errors.New("not found")
+}
+
+func (s *memoryStore) GetUserKey(userID, version int) (models.UserKey, error) {
+	for _, k := range s.keys {
+		if k.UserID == userID && k.Version == version {
+			return k, nil
+		}
+	}
+	return models.UserKey{}, errors.New("not found")
+}
+
+func (s *memoryStore) GetUserKeys(userID int) ([]models.UserKey, error) {
+	var keys []models.UserKey
+	for _, k := range s.keys {
+		if k.UserID == userID {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func (s *memoryStore) CreateUserKey(key *models.UserKey) error {
+	for i := range s.keys {
+		if s.keys[i].UserID == key.UserID {
+			s.keys[i].Active = false
+		}
+	}
+	s.keys = append(s.keys, *key)
+	return nil
+}
+
+func (s *memoryStore) UpdateUserKey(key *models.UserKey) error {
+	for i := range s.keys {
+		if s.keys[i].UserID == key.UserID && s.keys[i].Version == key.Version {
+			s.keys[i] = *key
+		}
+	}
+	return nil
+}
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, keySize))
+}
+
+func newTestEnvelope(t *testing.T, cfg config.Config, store KeyStore) *Envelope {
+	t.Helper()
+	return NewEnvelope(&cfg, store, zap.NewNop().Sugar())
+}
+
+func writeKeyFile(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+var moodField = Field{Table: "moods", Column: "description", RowID: 7}
+
+func TestEncryptDecrypt(t *testing.T) {
+	e := newTestEnvelope(t, config.Config{ENCRYPTION_MASTER_KEY: testKey(1)}, &memoryStore{})
+
+	tests := []struct {
+		name      string
+		plaintext string
+	}{
+		{"text", "Сегодня было спокойно"},
+		{"empty", ""},
+		{"looks like ciphertext", "enc:v2:1:AAAA"},
+		{"looks like raw", "enc:raw:note"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := e.Encrypt(1, moodField, tt.plaintext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.plaintext != "" && (value == tt.plaintext || !IsEncrypted(value)) {
+				t.Fatalf("Encrypt(%q) = %q, want ciphertext", tt.plaintext, value)
+			}
+			got, err := e.Decrypt(1, moodField, value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.plaintext {
+				t.Errorf("Decrypt(Encrypt(%q)) = %q", tt.plaintext, got)
+			}
+		})
+	}
+}
+
+func TestDecryptRejectsMovedValue(t *testing.T) {
+	e := newTestEnvelope(t, config.Config{ENCRYPTION_MASTER_KEY: testKey(1)}, &memoryStore{})
+
+	value, err := e.Encrypt(1, moodField, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		userID int
+		field  Field
+	}{
+		{"other row", 1, Field{Table: "moods", Column: "description", RowID: 8}},
+		{"other column", 1, Field{Table: "moods", Column: "emotions", RowID: 7}},
+		{"other table", 1, Field{Table: "advices", Column: "description", RowID: 7}},
+		{"other user", 2, moodField},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := e.Decrypt(tt.userID, tt.field, value); err == nil {
+				t.Error("Decrypt succeeded for a value from another field")
+			}
+		})
+	}
+}
+
+func TestDisabledEnvelope(t *testing.T) {
+	e := newTestEnvelope(t, config.Config{}, &memoryStore{})
+
+	tests := []struct {
+		plaintext string
+		stored    string
+	}{
+		{"plain text", "plain text"},
+		{"enc:v1:1:AAAA", "enc:raw:enc:v1:1:AAAA"},
+		{"enc:raw:x", "enc:raw:enc:raw:x"},
+	}
+
+	for _, tt := range tests {
+		stored, err := e.Encrypt(1, moodField, tt.plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored != tt.stored {
+			t.Errorf("Encrypt(%q) = %q, want %q", tt.plaintext, stored, tt.stored)
+		}
+		got, err := e.Decrypt(1, moodField, stored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.plaintext {
+			t.Errorf("Decrypt(%q) = %q, want %q", stored, got, tt.plaintext)
+		}
+	}
+
+	if _, err := e.Decrypt(1, moodField, "enc:v2:1:AAAA"); !errors.Is(err, ErrDisabled) {
+		t.Errorf("Decrypt of ciphertext without a key: err = %v, want ErrDisabled", err)
+	}
+}
+
+func TestDecryptV1(t *testing.T) {
+	store := &memoryStore{}
+	e := newTestEnvelope(t, config.Config{ENCRYPTION_MASTER_KEY: testKey(1)}, store)
+
+	_, key, err := e.activeKey(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := seal(key, []byte("старая запись"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := prefixV1 + "1:" + base64.StdEncoding.EncodeToString(sealed)
+
+	got, err := e.Decrypt(1, moodField, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "старая запись" {
+		t.Errorf("Decrypt = %q", got)
+	}
+}
+
+func TestUnwrapRejectsSwappedKey(t *testing.T) {
+	store := &memoryStore{}
+	e := newTestEnvelope(t, config.Config{ENCRYPTION_MASTER_KEY: testKey(1)}, store)
+
+	for _, userID := range []int{1, 2} {
+		if _, err := e.ActiveVersion(userID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Строка ключа второго пользователя получает ключ первого
+	store.keys[1].WrappedKey = store.keys[0].WrappedKey
+
+	fresh := newTestEnvelope(t, config.Config{ENCRYPTION_MASTER_KEY: testKey(1)}, store)
+	if _, err := fresh.unwrap(store.keys[1]); err == nil {
+		t.Error("unwrap accepted another user's data key")
+	}
+}
+
+func TestRotateUserKey(t *testing.T) {
+	store := &memoryStore{}
+	e := newTestEnvelope(t, config.Config{ENCRYPTION_MASTER_KEY: testKey(1)}, store)
+
+	old, err := e.Encrypt(1, moodField, "до ротации")
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err := e.RotateUserKey(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 {
+		t.Fatalf("RotateUserKey = %d, want 2", version)
+	}
+
+	value, err := e.Encrypt(1, moodField, "после ротации")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if KeyVersion(old) != 1 || KeyVersion(value) != 2 {
+		t.Errorf("key versions = %d, %d, want 1, 2", KeyVersion(old), KeyVersion(value))
+	}
+	if got, err := e.Decrypt(1, moodField, old); err != nil || got != "до ротации" {
+		t.Errorf("Decrypt(old) = %q, %v", got, err)
+	}
+}
+
+func TestRotationByAnotherProcess(t *testing.T) {
+	store := &memoryStore{}
+	cfg := config.Config{ENCRYPTION_MASTER_KEY: testKey(1)}
+	server := newTestEnvelope(t, cfg, store)
+
+	if _, err := server.Encrypt(1, moodField, "до ротации"); err != nil {
+		t.Fatal(err)
+	}
+	// reencrypt -rotate-data-keys работает в отдельном процессе
+	if _, err := newTestEnvelope(t, cfg, store).RotateUserKey(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Пока запомненная версия не устарела, сервер её не перечитывает
+	value, err := server.Encrypt(1, moodField, "сразу после ротации")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if KeyVersion(value) != 1 {
+		t.Fatalf("key version before TTL = %d, want 1", KeyVersion(value))
+	}
+
+	server.mu.Lock()
+	server.active[1] = activeVersion{version: 1, checkedAt: time.Now().Add(-activeKeyTTL)}
+	server.mu.Unlock()
+
+	value, err = server.Encrypt(1, moodField, "после ротации")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if KeyVersion(value) != 2 {
+		t.Errorf("key version after TTL = %d, want 2", KeyVersion(value))
+	}
+	if got, err := server.Decrypt(1, moodField, value); err != nil || got != "после ротации" {
+		t.Errorf("Decrypt = %q, %v", got, err)
+	}
+}
+
+func TestRewrapUserKeys(t *testing.T) {
+	store := &memoryStore{}
+	before := newTestEnvelope(t, config.Config{
+		ENCRYPTION_KEY_FILE: writeKeyFile(t, "a:"+testKey(1)),
+	}, store)
+
+	value, err := before.Encrypt(1, moodField, "запись")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Ключ, сохранённый до связывания с пользователем
+	legacy, err := seal(before.masterKeys["a"], []byte(strings.Repeat("k", keySize)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.keys = append(store.keys, models.UserKey{UserID: 2, Version: 1, MasterKeyID: "a", WrappedKey: legacy, Active: true})
+
+	keyFile := writeKeyFile(t, "a:"+testKey(1), "b:"+testKey(2))
+	after := newTestEnvelope(t, config.Config{ENCRYPTION_KEY_FILE: keyFile}, store)
+	for _, userID := range []int{1, 2} {
+		count, err := after.RewrapUserKeys(userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Errorf("RewrapUserKeys(%d) = %d, want 1", userID, count)
+		}
+	}
+	for _, k := range store.keys {
+		if k.MasterKeyID != "b" || !k.Bound {
+			t.Errorf("key %d/%d: master %q, bound %v", k.UserID, k.Version, k.MasterKeyID, k.Bound)
+		}
+	}
+
+	// Старый мастер-ключ больше не нужен
+	current := newTestEnvelope(t, config.Config{ENCRYPTION_KEY_FILE: writeKeyFile(t, "b:"+testKey(2))}, store)
+	if got, err := current.Decrypt(1, moodField, value); err != nil || got != "запись" {
+		t.Errorf("Decrypt after rewrap = %q, %v", got, err)
+	}
+	if _, err := current.userKey(2, 1); err != nil {
+		t.Errorf("legacy key after rewrap: %v", err)
+	}
+}
+
+func TestKeyVersion(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"enc:v2:3:AAAA", 3},
+		{"enc:v1:2:AAAA", 2},
+		{"enc:raw:enc:v2:3:AAAA", 0},
+		{"plain", 0},
+	}
+
+	for _, tt := range tests {
+		if got := KeyVersion(tt.value); got != tt.want {
+			t.Errorf("KeyVersion(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+	if IsEncrypted("enc:raw:x") {
+		t.Error("IsEncrypted reports escaped plaintext as ciphertext")
+	}
+}
@@ -0,0 +1,107 @@
+package errs
+
+import (
+	"errors"
+	"net/http"
+	"sentimenta/internal/i18n"
+)
+
+// entry связывает ошибку со стабильным кодом для клиентов. Русское
+// сообщение — текст самой ошибки, английское хранится здесь.
+type entry struct {
+	err  error
+	code string
+	en   string
+}
+
+// catalog — коды ошибок API. Коды не меняются: клиенты могут на них
+// опираться, а сообщения можно переписывать.
+var catalog = []entry{
+	{ErrUserAlreadyExists, "user_already_exists", "a user with this email already exists"},
+	{ErrEmailValidation, "email_invalid", "email address is not valid"},
+	{ErrWrongPassword, "wrong_password", "wrong password"},
+	{ErrNotFoundInJWT, "token_missing_user", "token does not contain a user id"},
+	{ErrUnsupportedSignatureMethod, "token_signature_unsupported", "unsupported token signature method"},
+	{ErrTokenExpired, "token_expired", "token has expired"},
+	{ErrUnknownKeyID, "token_unknown_key", "token is signed with an unknown key"},
+	{ErrNoSigningKey, "signing_key_missing", "no key available to sign tokens"},
+	{ErrSigningKeyFormat, "signing_key_format", "unsupported signing key format"},
+	{ErrMoodDescLength, "mood_description_too_long", "description is too long"},
+	{ErrMoodEmotesLength, "mood_emotions_too_long", "emotions are too long"},
+	{ErrRegistrationDisabled, "registration_disabled", "registration is disabled"},
+	{ErrAIDisabled, "ai_disabled", "advice generation is disabled"},
+	{ErrAdviceRating, "advice_rating_invalid", "rating must be 1 or -1"},
+	{ErrAdviceFeedbackLength, "advice_feedback_too_long", "comment is too long"},
+	{ErrAdviceUnsafe, "advice_unsafe", "the model response did not pass the safety check"},
+	{ErrAIUnavailable, "ai_unavailable", "advice service is temporarily unavailable, try again later"},
+	{ErrAIQuotaDaily, "ai_quota_daily", "that's enough advice for today — new advice will be available tomorrow"},
+	{ErrAIQuotaMonthly, "ai_quota_monthly", "you've used this month's advice allowance — new advice will be available next month"},
+	{ErrMoodE2ERequired, "mood_e2e_required", "end-to-end encryption is on: the description must be encrypted on the client"},
+	{ErrMoodE2EInvalid, "mood_e2e_invalid", "invalid description encryption parameters"},
+	{ErrDeletionReauth, "deletion_reauth_required", "confirm your password or the code sent to your email to delete the account"},
+	{ErrDeletionCodeInvalid, "deletion_code_invalid", "the confirmation code is invalid or has expired"},
+	{ErrDeletionScheduled, "deletion_already_scheduled", "account deletion is already scheduled"},
+	{ErrDeletionNotScheduled, "deletion_not_scheduled", "account deletion is not scheduled"},
+	{ErrUserDisabled, "user_disabled", "user is disabled"},
+	{ErrTokenRevoked, "token_revoked", "token has been revoked"},
+	{ErrUnknownRole, "role_unknown", "unknown role"},
+	{ErrAdminSelf, "admin_self_action", "you cannot disable yourself or remove your own admin role"},
+	{ErrPasskeysDisabled, "passkeys_disabled", "passkey sign-in is disabled"},
+	{ErrPasskeySession, "passkey_session_invalid", "WebAuthn session not found or expired"},
+	{ErrPasskeyInvalid, "passkey_invalid", "could not verify the passkey"},
+	{ErrPasskeyCloned, "passkey_cloned", "passkey rejected: it may have been cloned"},
+	{ErrMagicLinkDisabled, "magic_link_disabled", "email sign-in links are disabled"},
+	{ErrMagicLinkInvalid, "magic_link_invalid", "the sign-in link or code is invalid or has expired"},
+	{ErrMagicLinkBinding, "magic_link_wrong_browser", "open the link in the same browser you requested it from"},
+	{ErrPasswordHashFormat, "password_hash_invalid", "invalid password hash format"},
+	{ErrPasswordHashAlg, "password_hash_unknown", "unknown password hashing algorithm"},
+	{ErrLocaleUnsupported, "locale_unsupported", "language is not supported"},
+	{ErrAuthRequired, "auth_required", "authentication required"},
+	{ErrInvalidToken, "token_invalid", "invalid token"},
+	{ErrForbidden, "forbidden", "insufficient permissions"},
+	{ErrTooManyRequests, "rate_limited", "too many requests, try again later"},
+}
+
+// statusCodes — коды для ошибок, которых нет в каталоге.
+var statusCodes = map[int]struct{ code, ru, en string }{
+	http.StatusBadRequest:            {"bad_request", "Некорректный запрос", "Bad request"},
+	http.StatusUnauthorized:          {"unauthorized", "Требуется аутентификация", "Unauthorized"},
+	http.StatusForbidden:             {"forbidden", "Доступ запрещён", "Forbidden"},
+	http.StatusNotFound:              {"not_found", "Не найдено", "Not found"},
+	http.StatusMethodNotAllowed:      {"method_not_allowed", "Метод не поддерживается", "Method not allowed"},
+	http.StatusConflict:              {"conflict", "Конфликт", "Conflict"},
+	http.StatusRequestEntityTooLarge: {"payload_too_large", "Слишком большой запрос", "Payload too large"},
+	http.StatusTooManyRequests:       {"rate_limited", "Слишком много запросов", "Too many requests"},
+	http.StatusInternalServerError:   {"internal_error", "Внутренняя ошибка сервера", "Internal server error"},
+	http.StatusServiceUnavailable:    {"unavailable", "Сервис временно недоступен", "Service unavailable"},
+}
+
+// Lookup находит ошибку в каталоге и возвращает её код и сообщение на
+// языке lang.
+func Lookup(err error, lang string) (code, message string, ok bool) {
+	for _, e := range catalog {
+		if errors.Is(err, e.err) {
+			if lang == i18n.EN {
+				return e.code, e.en, true
+			}
+			return e.code, e.err.Error(), true
+		}
+	}
+	return "", "", false
+}
+
+// StatusCode возвращает общий код и заголовок (title) для HTTP-статуса.
+func StatusCode(status int, lang string) (code, title string) {
+	s, ok := statusCodes[status]
+	if !ok {
+		if status >= http.StatusInternalServerError {
+			s = statusCodes[http.StatusInternalServerError]
+		} else {
+			s = statusCodes[http.StatusBadRequest]
+		}
+	}
+	if lang == i18n.EN {
+		return s.code, s.en
+	}
+	return s.code, s.ru
+}
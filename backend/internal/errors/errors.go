@@ -5,12 +5,46 @@ import "errors"
 var ErrUserAlreadyExists = errors.New("пользователь с такой почтой уже существует")
 var ErrEmailValidation = errors.New("email не прошел валидацию")
 var ErrWrongPassword = errors.New("неверный пароль")
-var ErrPasswordLength = errors.New("длина пароля меньше нужного")
 
 var ErrNotFoundInJWT = errors.New("user_id не найден в токене")
 var ErrUnsupportedSignatureMethod = errors.New("неподдерживаемый метод подписи")
 var ErrTokenExpired = errors.New("токен истек")
-var ErrNoExpClaim = errors.New("не найдено поле exp в токене")
+var ErrUnknownKeyID = errors.New("неизвестный ключ подписи токена")
+var ErrNoSigningKey = errors.New("нет ключа для подписи токенов")
+var ErrSigningKeyFormat = errors.New("неподдерживаемый формат ключа подписи")
 var ErrMoodDescLength = errors.New("длина описания больше допустимого")
 var ErrMoodEmotesLength = errors.New("длина эмоций больше допустимого")
 var ErrRegistrationDisabled = errors.New("регистрация отключена")
+var ErrAIDisabled = errors.New("генерация советов отключена")
+var ErrAdviceRating = errors.New("оценка должна быть 1 или -1")
+var ErrAdviceFeedbackLength = errors.New("длина комментария больше допустимого")
+var ErrAdviceUnsafe = errors.New("ответ модели не прошёл проверку безопасности")
+var ErrAIUnavailable = errors.New("сервис советов временно недоступен, попробуйте позже")
+var ErrAIQuotaDaily = errors.New("на сегодня советов достаточно — новые будут доступны завтра")
+var ErrAIQuotaMonthly = errors.New("лимит советов на этот месяц исчерпан — новые будут доступны в следующем месяце")
+var ErrMoodE2ERequired = errors.New("включено сквозное шифрование: описание должно быть зашифровано на клиенте")
+var ErrMoodE2EInvalid = errors.New("некорректные параметры шифрования описания")
+var ErrDeletionReauth = errors.New("для удаления аккаунта подтвердите пароль или код из письма")
+var ErrDeletionCodeInvalid = errors.New("код подтверждения удаления недействителен или истёк")
+var ErrDeletionScheduled = errors.New("удаление аккаунта уже запланировано")
+var ErrDeletionNotScheduled = errors.New("удаление аккаунта не запланировано")
+var ErrUserDisabled = errors.New("пользователь заблокирован")
+var ErrTokenRevoked = errors.New("токен отозван")
+var ErrUnknownRole = errors.New("неизвестная роль")
+var ErrAdminSelf = errors.New("нельзя заблокировать себя или снять с себя роль администратора")
+var ErrPasskeysDisabled = errors.New("вход по ключам доступа отключен")
+var ErrPasskeySession = errors.New("сессия WebAuthn не найдена или истекла")
+var ErrPasskeyInvalid = errors.New("не удалось проверить ключ доступа")
+var ErrPasskeyCloned = errors.New("ключ доступа отклонён: возможно, он был скопирован")
+var ErrMagicLinkDisabled = errors.New("вход по ссылке из письма отключен")
+var ErrMagicLinkInvalid = errors.New("ссылка или код для входа недействительны или истекли")
+var ErrMagicLinkBinding = errors.New("ссылку нужно открыть в том же браузере, где запрашивали вход")
+var ErrPasswordHashFormat = errors.New("неверный формат хеша пароля")
+var ErrPasswordHashAlg = errors.New("неизвестный алгоритм хеширования пароля")
+var ErrAuthRequired = errors.New("требуется аутентификация")
+var ErrInvalidToken = errors.New("невалидный токен")
+var ErrForbidden = errors.New("недостаточно прав")
+var ErrTooManyRequests = errors.New("слишком много запросов, попробуйте позже")
+var ErrLocaleUnsupported = errors.New("язык не поддерживается")
+var ErrPromptVersionExists = errors.New("такая версия промпта уже есть")
+var ErrPromptUnknown = errors.New("неизвестная версия промпта")
@@ -0,0 +1,370 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	errs "sentimenta/internal/errors"
+	"sentimenta/internal/models"
+	"sentimenta/internal/service"
+	"sentimenta/internal/utils"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type AdminHandler struct {
+	adminService    service.AdminService
+	adviceService   service.AdviceService
+	deletionService service.DeletionService
+	securityService service.SecurityEventService
+	logger          *zap.SugaredLogger
+	resp            *Responser
+}
+
+// @Summary		Advice feedback stats
+// @Description	Aggregated advice ratings per model and prompt version
+// @Tags			Admin
+// @Produce		json
+// @Success		200	{array}		models.AdviceFeedbackStats
+// @Failure		401	{object}	errorResponse
+// @Failure		403	{object}	errorResponse
+// @Failure		500	{object}	errorResponse
+// @Router			/api/admin/stats/advice-feedback [get]
+func (h *AdminHandler) GetAdviceFeedbackStats(c echo.Context) error {
+	stats, err := h.adviceService.GetFeedbackStats()
+	if err != nil {
+		h.logger.Errorf("Ошибка при получении статистики оценок: %v", err)
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, stats)
+}
+
+// @Summary		Account deletions
+// @Description	Audit trail of account deletion requests. Contains only ids, dates and counts of erased records.
+// @Tags			Admin
+// @Produce		json
+// @Param			page	query		int	false	"page number, starting from 1"
+// @Param			limit	query		int	false	"page size, max 100"
+// @Success		200		{object}	models.Page[models.AccountDeletion]
+// @Failure		401		{object}	errorResponse
+// @Failure		403		{object}	errorResponse
+// @Failure		500		{object}	errorResponse
+// @Router			/api/admin/deletions [get]
+func (h *AdminHandler) GetDeletions(c echo.Context) error {
+	page, limit := utils.GetPagination(c)
+	deletions, err := h.deletionService.GetDeletions(page, limit)
+	if err != nil {
+		h.logger.Errorf("Ошибка при получении журнала удалений: %v", err)
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, deletions)
+}
+
+// @Summary		Security events
+// @Description	Security log of all users with filters, newest first
+// @Tags			Admin
+// @Produce		json
+// @Param			user_id	query		int		false	"user id"
+// @Param			type	query		string	false	"event type, e.g. login.failure"
+// @Param			ip		query		string	false	"client IP"
+// @Param			from	query		string	false	"from date, RFC3339 or YYYY-MM-DD"
+// @Param			to		query		string	false	"to date (exclusive), RFC3339 or YYYY-MM-DD"
+// @Param			page	query		int		false	"page number, starting from 1"
+// @Param			limit	query		int		false	"page size, max 100"
+// @Success		200		{object}	models.Page[models.SecurityEvent]
+// @Failure		400		{object}	errorResponse
+// @Failure		401		{object}	errorResponse
+// @Failure		403		{object}	errorResponse
+// @Failure		500		{object}	errorResponse
+// @Router			/api/admin/security-events [get]
+func (h *AdminHandler) GetSecurityEvents(c echo.Context) error {
+	filter := models.SecurityEventFilter{
+		Type: c.QueryParam("type"),
+		IP:   c.QueryParam("ip"),
+	}
+
+	if userID := c.QueryParam("user_id"); userID != "" {
+		uidInt, err := strconv.Atoi(userID)
+		if err != nil {
+			return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
+		}
+		filter.UserID = &uidInt
+	}
+
+	var err error
+	if filter.From, err = parseTimeParam(c.QueryParam("from")); err != nil {
+		return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
+	}
+	if filter.To, err = parseTimeParam(c.QueryParam("to")); err != nil {
+		return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
+	}
+
+	page, limit := utils.GetPagination(c)
+	events, err := h.securityService.GetEvents(filter, page, limit)
+	if err != nil {
+		h.logger.Errorf("Ошибка при получении журнала безопасности: %v", err)
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, events)
+}
+
+// parseTimeParam принимает время в RFC3339 или дату YYYY-MM-DD.
+func parseTimeParam(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		if t, err = time.Parse("2006-01-02", value); err != nil {
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+// @Summary		Users
+// @Description	Search users by username or email substring
+// @Tags			Admin
+// @Produce		json
+// @Param			q		query		string	false	"username or email substring"
+// @Param			page	query		int		false	"page number, starting from 1"
+// @Param			limit	query		int		false	"page size, max 100"
+// @Success		200		{object}	models.Page[models.UserAdmin]
+// @Failure		401		{object}	errorResponse
+// @Failure		403		{object}	errorResponse
+// @Failure		500		{object}	errorResponse
+// @Router			/api/admin/users [get]
+func (h *AdminHandler) GetUsers(c echo.Context) error {
+	page, limit := utils.GetPagination(c)
+	users, err := h.adminService.SearchUsers(c.QueryParam("q"), page, limit)
+	if err != nil {
+		h.logger.Errorf("Ошибка при поиске пользователей: %v", err)
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, users)
+}
+
+// @Summary		User
+// @Description	Get user by id
+// @Tags			Admin
+// @Produce		json
+// @Param			id	path		int	true	"user id"
+// @Success		200	{object}	models.UserAdmin
+// @Failure		401	{object}	errorResponse
+// @Failure		403	{object}	errorResponse
+// @Failure		404	{object}	errorResponse
+// @Failure		500	{object}	errorResponse
+// @Router			/api/admin/users/{id} [get]
+func (h *AdminHandler) GetUser(c echo.Context) error {
+	user, err := h.adminService.GetUser(c.Param("id"))
+	if err != nil {
+		return h.adminErrorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, user)
+}
+
+// @Summary		Disable user
+// @Description	Disable user. Their tokens stop working immediately.
+// @Tags			Admin
+// @Produce		json
+// @Param			id	path		int	true	"user id"
+// @Success		200	{object}	models.UserAdmin
+// @Failure		400	{object}	errorResponse
+// @Failure		401	{object}	errorResponse
+// @Failure		403	{object}	errorResponse
+// @Failure		404	{object}	errorResponse
+// @Failure		500	{object}	errorResponse
+// @Router			/api/admin/users/{id}/disable [post]
+func (h *AdminHandler) PostDisableUser(c echo.Context) error {
+	return h.setDisabled(c, true)
+}
+
+// @Summary		Enable user
+// @Description	Enable previously disabled user
+// @Tags			Admin
+// @Produce		json
+// @Param			id	path		int	true	"user id"
+// @Success		200	{object}	models.UserAdmin
+// @Failure		401	{object}	errorResponse
+// @Failure		403	{object}	errorResponse
+// @Failure		404	{object}	errorResponse
+// @Failure		500	{object}	errorResponse
+// @Router			/api/admin/users/{id}/enable [post]
+func (h *AdminHandler) PostEnableUser(c echo.Context) error {
+	return h.setDisabled(c, false)
+}
+
+func (h *AdminHandler) setDisabled(c echo.Context, disabled bool) error {
+	adminID, err := utils.GetUserID(c)
+	if err != nil {
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
+	}
+
+	user, err := h.adminService.SetDisabled(adminID, c.Param("id"), disabled)
+	if err != nil {
+		return h.adminErrorResponse(c, err)
+	}
+
+	eventType := models.SecurityAccountEnable
+	if disabled {
+		eventType = models.SecurityAccountDisable
+	}
+	h.recordAdminEvent(c, eventType, adminID, nil)
+	return c.JSON(http.StatusOK, user)
+}
+
+// @Summary		Force logout
+// @Description	Revoke all tokens of the user and close their WebSocket connections
+// @Tags			Admin
+// @Produce		json
+// @Param			id	path		int	true	"user id"
+// @Success		200	{object}	okResponse
+// @Failure		401	{object}	errorResponse
+// @Failure		403	{object}	errorResponse
+// @Failure		404	{object}	errorResponse
+// @Failure		500	{object}	errorResponse
+// @Router			/api/admin/users/{id}/logout [post]
+func (h *AdminHandler) PostLogoutUser(c echo.Context) error {
+	adminID, err := utils.GetUserID(c)
+	if err != nil {
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
+	}
+
+	if err := h.adminService.ForceLogout(c.Param("id")); err != nil {
+		return h.adminErrorResponse(c, err)
+	}
+	h.recordAdminEvent(c, models.SecurityTokenRevoke, adminID, nil)
+	return c.JSON(http.StatusOK, okResponse{"user logged out"})
+}
+
+// @Summary		Change role
+// @Description	Change user role
+// @Tags			Admin
+// @Accept			json
+// @Produce		json
+// @Param			id		path		int					true	"user id"
+// @Param			input	body		models.AdminRoleReq	true	"role: user or admin"
+// @Success		200		{object}	models.UserAdmin
+// @Failure		400		{object}	errorResponse
+// @Failure		401		{object}	errorResponse
+// @Failure		403		{object}	errorResponse
+// @Failure		404		{object}	errorResponse
+// @Failure		500		{object}	errorResponse
+// @Router			/api/admin/users/{id}/role [patch]
+func (h *AdminHandler) PatchUserRole(c echo.Context) error {
+	adminID, err := utils.GetUserID(c)
+	if err != nil {
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
+	}
+
+	var req models.AdminRoleReq
+	if err := c.Bind(&req); err != nil {
+		return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
+	}
+
+	user, err := h.adminService.SetRole(adminID, c.Param("id"), req.Role)
+	if err != nil {
+		return h.adminErrorResponse(c, err)
+	}
+	h.recordAdminEvent(c, models.SecurityRoleChange, adminID, map[string]string{"role": req.Role})
+	return c.JSON(http.StatusOK, user)
+}
+
+// @Summary		Toggle AI
+// @Description	Enable or disable AI advice for the user. Sets ai_disabled_by_admin, which the user cannot change; the user's own use_ai setting is kept.
+// @Tags			Admin
+// @Accept			json
+// @Produce		json
+// @Param			id		path		int						true	"user id"
+// @Param			input	body		models.AdminUseAIReq	true	"use_ai flag"
+// @Success		200		{object}	models.UserAdmin
+// @Failure		400		{object}	errorResponse
+// @Failure		401		{object}	errorResponse
+// @Failure		403		{object}	errorResponse
+// @Failure		404		{object}	errorResponse
+// @Failure		500		{object}	errorResponse
+// @Router			/api/admin/users/{id}/ai [patch]
+func (h *AdminHandler) PatchUserAI(c echo.Context) error {
+	adminID, err := utils.GetUserID(c)
+	if err != nil {
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
+	}
+
+	var req models.AdminUseAIReq
+	if err := c.Bind(&req); err != nil {
+		return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
+	}
+
+	user, err := h.adminService.SetUseAI(adminID, c.Param("id"), req.UseAI)
+	if err != nil {
+		return h.adminErrorResponse(c, err)
+	}
+	eventType := models.SecurityAIDisable
+	if req.UseAI {
+		eventType = models.SecurityAIEnable
+	}
+	h.recordAdminEvent(c, eventType, adminID, nil)
+	return c.JSON(http.StatusOK, user)
+}
+
+// @Summary		System stats
+// @Description	Counts of users, moods and advices
+// @Tags			Admin
+// @Produce		json
+// @Success		200	{object}	models.SystemStats
+// @Failure		401	{object}	errorResponse
+// @Failure		403	{object}	errorResponse
+// @Failure		500	{object}	errorResponse
+// @Router			/api/admin/stats [get]
+func (h *AdminHandler) GetSystemStats(c echo.Context) error {
+	stats, err := h.adminService.GetSystemStats()
+	if err != nil {
+		h.logger.Errorf("Ошибка при получении статистики: %v", err)
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, stats)
+}
+
+// recordAdminEvent записывает действие администратора в журнал
+// безопасности пользователя, над которым оно совершено.
+func (h *AdminHandler) recordAdminEvent(c echo.Context, eventType, adminID string, details map[string]string) {
+	event := newSecurityEvent(c, eventType, c.Param("id"))
+	event.Details = map[string]string{"admin_id": adminID}
+	for key, value := range details {
+		event.Details[key] = value
+	}
+	h.securityService.Record(event)
+}
+
+func (h *AdminHandler) adminErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, errs.ErrUnknownRole), errors.Is(err, errs.ErrAdminSelf):
+		return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return h.resp.newErrorResponse(c, http.StatusNotFound, err)
+	default:
+		h.logger.Errorf("Ошибка администрирования: %v", err)
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
+	}
+}
+
+func NewAdminHandler(
+	adminService service.AdminService,
+	adviceService service.AdviceService,
+	deletionService service.DeletionService,
+	securityService service.SecurityEventService,
+	logger *zap.SugaredLogger,
+	resp *Responser,
+) *AdminHandler {
+	return &AdminHandler{
+		adminService:    adminService,
+		adviceService:   adviceService,
+		deletionService: deletionService,
+		securityService: securityService,
+		logger:          logger,
+		resp:            resp,
+	}
+}
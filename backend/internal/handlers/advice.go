@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	errs "sentimenta/internal/errors"
 	"sentimenta/internal/models"
 	"sentimenta/internal/service"
 	"sentimenta/internal/utils"
@@ -9,6 +11,7 @@ import (
 
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 type AdviceHandler struct {
@@ -33,7 +36,7 @@ func (h *AdviceHandler) GetAdvice(c echo.Context) error {
 	userID, err := utils.GetUserID(c)
 	if err != nil {
 		h.logger.Errorf("Ошибка. Требуется аутентификация: %v", err)
-		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err.Error())
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
 	}
 
 	dateStr := c.QueryParam("date")
@@ -42,23 +45,163 @@ func (h *AdviceHandler) GetAdvice(c echo.Context) error {
 		date, err := time.Parse(layout, dateStr)
 		if err != nil {
 			h.logger.Errorf("Ошибка при попытке получить Advice по date: %v", err)
-			return h.resp.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
 		}
 		advice, err := h.service.GetAdvice(userID, date)
 		if err != nil {
-			return h.resp.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
 		}
 		return c.JSON(http.StatusOK, advice)
 	}
 	advices, err := h.service.GetAdvices(userID)
 	if err != nil {
 		h.logger.Errorf("Ошибка при попытке получить Advices: %v", err)
-		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
 	}
 	return c.JSON(http.StatusOK, advices)
 
 }
 
+// @Summary		Generate advice
+// @Description	Generate advice for the given date on demand. Previous advice for the date is kept in history.
+// @Tags			Advice
+// @Accept			json
+// @Produce		json
+// @Param			input	body		models.AdviceGenerateReq	true	"advice date in format YYYY-MM-DD"
+// @Success		200		{object}	models.Advice
+// @Failure		400		{object}	errorResponse
+// @Failure		401		{object}	errorResponse
+// @Failure		403		{object}	errorResponse
+// @Failure		429		{object}	errorResponse
+// @Failure		500		{object}	errorResponse
+// @Router			/api/advice/generate [post]
+func (h *AdviceHandler) PostGenerateAdvice(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
+	}
+
+	var req models.AdviceGenerateReq
+	if err := c.Bind(&req); err != nil {
+		return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
+	}
+	date, err := time.Parse("2006-01-02", req.Date)
+	if err != nil {
+		return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
+	}
+
+	advice, err := h.service.RequestAdvice(c.Request().Context(), userID, date)
+	if err != nil {
+		h.logger.Errorf("Ошибка при генерации Advice: %v", err)
+		return h.adviceErrorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, advice)
+}
+
+// @Summary		Regenerate advice
+// @Description	Generate a new version of existing advice
+// @Tags			Advice
+// @Produce		json
+// @Param			id	path		int	true	"advice id"
+// @Success		200	{object}	models.Advice
+// @Failure		401	{object}	errorResponse
+// @Failure		403	{object}	errorResponse
+// @Failure		404	{object}	errorResponse
+// @Failure		429	{object}	errorResponse
+// @Failure		500	{object}	errorResponse
+// @Router			/api/advice/{id}/regenerate [post]
+func (h *AdviceHandler) PostRegenerateAdvice(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
+	}
+
+	advice, err := h.service.RegenerateAdvice(c.Request().Context(), userID, c.Param("id"))
+	if err != nil {
+		h.logger.Errorf("Ошибка при повторной генерации Advice: %v", err)
+		return h.adviceErrorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, advice)
+}
+
+// @Summary		Advice history
+// @Description	Get all generated versions of advice, newest first
+// @Tags			Advice
+// @Produce		json
+// @Param			id		path		int	true	"advice id"
+// @Param			page	query		int	false	"page number, starting from 1"
+// @Param			limit	query		int	false	"page size"
+// @Success		200		{object}	models.Page[models.AdviceVersion]
+// @Failure		401		{object}	errorResponse
+// @Failure		404		{object}	errorResponse
+// @Failure		500		{object}	errorResponse
+// @Router			/api/advice/{id}/history [get]
+func (h *AdviceHandler) GetAdviceHistory(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
+	}
+
+	page, limit := utils.GetPagination(c)
+	history, err := h.service.GetAdviceHistory(userID, c.Param("id"), page, limit)
+	if err != nil {
+		h.logger.Errorf("Ошибка при получении истории Advice: %v", err)
+		return h.adviceErrorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, history)
+}
+
+// @Summary		Rate advice
+// @Description	Rate advice with thumbs up (1) or thumbs down (-1) and an optional comment
+// @Tags			Advice
+// @Accept			json
+// @Produce		json
+// @Param			id		path		int							true	"advice id"
+// @Param			input	body		models.AdviceFeedbackReq	true	"rating"
+// @Success		200		{object}	models.Advice
+// @Failure		400		{object}	errorResponse
+// @Failure		401		{object}	errorResponse
+// @Failure		404		{object}	errorResponse
+// @Failure		500		{object}	errorResponse
+// @Router			/api/advice/{id}/feedback [post]
+func (h *AdviceHandler) PostAdviceFeedback(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
+	}
+
+	var req models.AdviceFeedbackReq
+	if err := c.Bind(&req); err != nil {
+		return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
+	}
+
+	advice, err := h.service.RateAdvice(userID, c.Param("id"), req.Rating, req.Comment)
+	if err != nil {
+		h.logger.Errorf("Ошибка при оценке Advice: %v", err)
+		return h.adviceErrorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, advice)
+}
+
+func (h *AdviceHandler) adviceErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, errs.ErrAIDisabled):
+		return h.resp.newErrorResponse(c, http.StatusForbidden, err)
+	case errors.Is(err, errs.ErrAdviceRating), errors.Is(err, errs.ErrAdviceFeedbackLength):
+		return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
+	case errors.Is(err, errs.ErrAdviceUnsafe):
+		return h.resp.newErrorResponse(c, http.StatusBadGateway, err)
+	case errors.Is(err, errs.ErrAIQuotaDaily), errors.Is(err, errs.ErrAIQuotaMonthly):
+		return h.resp.newErrorResponse(c, http.StatusTooManyRequests, err)
+	case errors.Is(err, errs.ErrAIUnavailable):
+		return h.resp.newErrorResponse(c, http.StatusServiceUnavailable, err)
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return h.resp.newErrorResponse(c, http.StatusNotFound, err)
+	default:
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
+	}
+}
+
 func NewAdviceHandler(service service.AdviceService, logger *zap.SugaredLogger, resp *Responser) *AdviceHandler {
 	return &AdviceHandler{service: service, logger: logger, resp: resp}
 }
@@ -10,8 +10,10 @@ import (
 	c "sentimenta/internal/config"
 	errs "sentimenta/internal/errors"
 	m "sentimenta/internal/models"
+	"sentimenta/internal/problem"
 	"sentimenta/internal/security"
 	"sentimenta/internal/service"
+	"sentimenta/internal/strength"
 
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
@@ -19,14 +21,21 @@ import (
 )
 
 type AuthHandler struct {
-	service service.UserService
-	config  *c.Config
-	logger  *zap.SugaredLogger
-	oauth   *auth.OAuth
-	JWT     *security.JWT
-	resp    *Responser
+	service    service.UserService
+	audit      service.SecurityEventService
+	passkeys   service.PasskeyService
+	magicLinks service.MagicLinkService
+	config     *c.Config
+	logger     *zap.SugaredLogger
+	oauth      *auth.OAuth
+	JWT        *security.JWT
+	resp       *Responser
 }
 
+// magicLinkCookie хранит привязку ссылки из письма к браузеру, в котором
+// запросили вход.
+const magicLinkCookie = "magic_link_binding"
+
 type OAuthCallbackRequest struct {
 	Code         string `json:"code"`
 	CodeVerifier string `json:"codeVerifier"`
@@ -46,43 +55,29 @@ type OAuthCallbackRequest struct {
 // @Router			/api/auth/register [post]
 func (h *AuthHandler) Register(c echo.Context) error {
 	if !h.config.REGISTRATION_ENABLED {
-		return h.resp.newErrorResponse(c, http.StatusForbidden, errs.ErrRegistrationDisabled.Error())
+		return h.resp.newErrorResponse(c, http.StatusForbidden, errs.ErrRegistrationDisabled)
 	}
 	var newUser m.UserRegister
 	if err := c.Bind(&newUser); err != nil {
-		return h.resp.newErrorResponse(c, http.StatusBadRequest, err.Error())
-	}
-
-	if len([]rune(newUser.Password)) < h.config.PASSWORD_LENGTH_MIN {
-		return h.resp.newErrorResponse(c, http.StatusBadRequest, errs.ErrPasswordLength.Error())
+		return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
 	}
 
 	result, err := h.service.CreateUser(newUser.Username, newUser.Email, &newUser.Password, newUser.Timezone)
 	if err != nil {
+		var policyErr *strength.PolicyError
+		if errors.As(err, &policyErr) {
+			return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
+		}
 		if errors.Is(err, errs.ErrUserAlreadyExists) {
-			return h.resp.newErrorResponse(c, http.StatusConflict, err.Error())
+			return h.resp.newErrorResponse(c, http.StatusConflict, err)
 		}
-		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
 	}
 
 	uidStr := fmt.Sprintf("%v", result.Uid)
-	jwtToken, err := h.JWT.GenerateJWT(uidStr)
-	if err != nil {
-		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err.Error())
-	}
-
-	jwt_cookie := http.Cookie{
-		Name:     h.config.JWT_COOKIE_NAME,
-		Value:    jwtToken,
-		HttpOnly: h.config.JWT_HTTP_ONLY,
-		Secure:   h.config.JWT_SECURE,
-		Path:     "/",
-	}
-
-	jwtResp := m.TokenResponse{Token: jwtToken}
+	h.audit.Record(newSecurityEvent(c, m.SecurityRegister, uidStr))
 
-	c.SetCookie(&jwt_cookie)
-	return c.JSON(http.StatusOK, jwtResp)
+	return h.issueToken(c, uidStr, m.TokenResponse{})
 }
 
 // @Summary		Login
@@ -99,32 +94,151 @@ func (h *AuthHandler) Register(c echo.Context) error {
 func (h *AuthHandler) Login(c echo.Context) error {
 	var reqUser m.UserLogin
 	if err := c.Bind(&reqUser); err != nil {
-		return h.resp.newErrorResponse(c, http.StatusBadRequest, err.Error())
+		return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
 	}
 
 	user, err := h.service.Authenticate(reqUser.Email, reqUser.Password)
+	uidStr := fmt.Sprintf("%v", user.Uid)
+	if err != nil {
+		h.audit.Record(newSecurityEvent(c, m.SecurityLoginFailure, uidStr))
+		if errors.Is(err, errs.ErrUserDisabled) {
+			return h.resp.newErrorResponse(c, http.StatusForbidden, err)
+		}
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
+	}
+	event := newSecurityEvent(c, m.SecurityLoginSuccess, uidStr)
+	event.Details = map[string]string{"method": "password"}
+	h.audit.Record(event)
+
+	return h.issueToken(c, uidStr, m.TokenResponse{})
+}
+
+// @Summary		Passkey login options
+// @Description	Start a passwordless login with a passkey. Pass options to navigator.credentials.get and session_id to /api/auth/passkey/finish.
+// @Tags			Auth
+// @Produce		json
+// @Success		200	{object}	m.PasskeyOptions
+// @Failure		404	{object}	errorResponse
+// @Failure		500	{object}	errorResponse
+// @Router			/api/auth/passkey/begin [post]
+func (h *AuthHandler) PostPasskeyLoginBegin(c echo.Context) error {
+	options, err := h.passkeys.BeginLogin()
 	if err != nil {
-		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err.Error())
+		return passkeyErrorResponse(c, h.resp, err)
 	}
+	return c.JSON(http.StatusOK, options)
+}
 
+// @Summary		Passkey login
+// @Description	Finish a passkey login. The body is the PublicKeyCredential returned by navigator.credentials.get.
+// @Tags			Auth
+// @Accept			json
+// @Produce		json
+// @Param			session_id	query		string	true	"session id from /api/auth/passkey/begin"
+// @Success		200			{object}	m.TokenResponse
+// @Failure		400			{object}	errorResponse
+// @Failure		401			{object}	errorResponse
+// @Failure		403			{object}	errorResponse
+// @Failure		500			{object}	errorResponse
+// @Router			/api/auth/passkey/finish [post]
+func (h *AuthHandler) PostPasskeyLoginFinish(c echo.Context) error {
+	user, err := h.passkeys.FinishLogin(c.QueryParam("session_id"), c.Request().Body)
 	uidStr := fmt.Sprintf("%v", user.Uid)
-	jwtToken, err := h.JWT.GenerateJWT(uidStr)
 	if err != nil {
-		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		event := newSecurityEvent(c, m.SecurityLoginFailure, uidStr)
+		event.Details = map[string]string{"method": "passkey"}
+		h.audit.Record(event)
+		return passkeyErrorResponse(c, h.resp, err)
+	}
+	event := newSecurityEvent(c, m.SecurityLoginSuccess, uidStr)
+	event.Details = map[string]string{"method": "passkey"}
+	h.audit.Record(event)
+
+	return h.issueToken(c, uidStr, m.TokenResponse{})
+}
+
+// @Summary		Magic link
+// @Description	Email a one-time sign-in link and code. The response is the same whether or not the account exists. The link only works in the browser that requested it.
+// @Tags			Auth
+// @Accept			json
+// @Param			input	body	m.MagicLinkReq	true	"email"
+// @Success		202
+// @Failure		400	{object}	errorResponse
+// @Failure		404	{object}	errorResponse
+// @Failure		500	{object}	errorResponse
+// @Router			/api/auth/magic-link [post]
+func (h *AuthHandler) PostMagicLink(c echo.Context) error {
+	var req m.MagicLinkReq
+	if err := c.Bind(&req); err != nil {
+		return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
 	}
 
-	jwt_cookie := http.Cookie{
-		Name:     h.config.JWT_COOKIE_NAME,
-		Value:    jwtToken,
-		HttpOnly: h.config.JWT_HTTP_ONLY,
+	binding, err := h.magicLinks.RequestLink(req.Email, problem.Lang(c))
+	if err != nil {
+		return magicLinkErrorResponse(c, h.resp, err)
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     magicLinkCookie,
+		Value:    binding,
+		HttpOnly: true,
 		Secure:   h.config.JWT_SECURE,
-		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/api/auth/magic-link",
+		MaxAge:   h.config.MAGIC_LINK_TTL_MINUTES * 60,
+	})
+	return c.NoContent(http.StatusAccepted)
+}
+
+// @Summary		Magic link login
+// @Description	Redeem a magic link token, or the email and code from the same email. Creates the account for a new email when registration is enabled.
+// @Tags			Auth
+// @Accept			json
+// @Produce		json
+// @Param			input	body		m.MagicLinkVerifyReq	true	"token, or email and code"
+// @Success		200		{object}	m.TokenResponse
+// @Failure		400		{object}	errorResponse
+// @Failure		401		{object}	errorResponse
+// @Failure		403		{object}	errorResponse
+// @Failure		404		{object}	errorResponse
+// @Failure		500		{object}	errorResponse
+// @Router			/api/auth/magic-link/verify [post]
+func (h *AuthHandler) PostMagicLinkVerify(c echo.Context) error {
+	var req m.MagicLinkVerifyReq
+	if err := c.Bind(&req); err != nil {
+		return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
 	}
 
-	jwtResp := m.TokenResponse{Token: jwtToken}
+	var binding string
+	if cookie, err := c.Cookie(magicLinkCookie); err == nil {
+		binding = cookie.Value
+	}
 
-	c.SetCookie(&jwt_cookie)
-	return c.JSON(http.StatusOK, jwtResp)
+	user, created, err := h.magicLinks.Redeem(req, binding)
+	uidStr := fmt.Sprintf("%v", user.Uid)
+	if err != nil {
+		if user.Uid != 0 {
+			event := newSecurityEvent(c, m.SecurityLoginFailure, uidStr)
+			event.Details = map[string]string{"method": "magic_link"}
+			h.audit.Record(event)
+		}
+		return magicLinkErrorResponse(c, h.resp, err)
+	}
+
+	// Ссылка одноразовая, привязка больше не нужна
+	c.SetCookie(&http.Cookie{
+		Name:   magicLinkCookie,
+		Path:   "/api/auth/magic-link",
+		MaxAge: -1,
+	})
+
+	if created {
+		h.audit.Record(newSecurityEvent(c, m.SecurityRegister, uidStr))
+	}
+	event := newSecurityEvent(c, m.SecurityLoginSuccess, uidStr)
+	event.Details = map[string]string{"method": "magic_link"}
+	h.audit.Record(event)
+
+	return h.issueToken(c, uidStr, m.TokenResponse{JustRegistered: &created})
 }
 
 // @Summary		Google
@@ -149,13 +263,13 @@ func (h *AuthHandler) GoogleAuthCallback(c echo.Context) error {
 		oauth2.SetAuthURLParam("code_verifier", req.CodeVerifier),
 	)
 	if err != nil {
-		return h.resp.newErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("Token exchange failed: %v", err))
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, fmt.Errorf("Token exchange failed: %v", err))
 	}
 
 	client := h.oauth.GoogleConfig.Client(ctx, token)
 	resp, err := client.Get("https://openidconnect.googleapis.com/v1/userinfo")
 	if err != nil {
-		return h.resp.newErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("Failed to get user info: %v", err))
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, fmt.Errorf("Failed to get user info: %v", err))
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
@@ -165,17 +279,17 @@ func (h *AuthHandler) GoogleAuthCallback(c echo.Context) error {
 
 	var userInfo map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil {
-		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
 	}
 
 	email, ok := userInfo["email"].(string)
 	if !ok {
-		return h.resp.newErrorResponse(c, http.StatusInternalServerError, "Invalid email format")
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, errors.New("Invalid email format"))
 	}
 
 	name, ok := userInfo["name"].(string)
 	if !ok {
-		return h.resp.newErrorResponse(c, http.StatusInternalServerError, "Invalid name format")
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, errors.New("Invalid name format"))
 	}
 
 	user, err := h.service.CreateUser(name, email, nil, req.Timezone)
@@ -192,31 +306,22 @@ func (h *AuthHandler) GoogleAuthCallback(c echo.Context) error {
 			jwtResp.JustRegistered = &a
 		} else {
 			h.logger.Errorf("Не удалось создать пользователя: %v", err)
-			return h.resp.newErrorResponse(c, http.StatusInternalServerError, "Failed to create user")
+			return h.resp.newErrorResponse(c, http.StatusInternalServerError, errors.New("Failed to create user"))
 		}
 	} else {
 		if !h.config.REGISTRATION_ENABLED {
-			return h.resp.newErrorResponse(c, http.StatusForbidden, errs.ErrRegistrationDisabled.Error())
+			return h.resp.newErrorResponse(c, http.StatusForbidden, errs.ErrRegistrationDisabled)
 		}
 	}
 
-	uidStr := fmt.Sprintf("%v", user.Uid)
-	jwtToken, err := h.JWT.GenerateJWT(uidStr)
-	if err != nil {
-		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+	if user.Disabled {
+		return h.resp.newErrorResponse(c, http.StatusForbidden, errs.ErrUserDisabled)
 	}
 
-	jwt_cookie := http.Cookie{
-		Name:     h.config.JWT_COOKIE_NAME,
-		Value:    jwtToken,
-		HttpOnly: h.config.JWT_HTTP_ONLY,
-		Secure:   h.config.JWT_SECURE,
-		Path:     "/",
-	}
+	uidStr := fmt.Sprintf("%v", user.Uid)
+	h.audit.RecordOAuthLogin(newSecurityEvent(c, m.SecurityLoginSuccess, uidStr), "google")
 
-	jwtResp.Token = jwtToken
-	c.SetCookie(&jwt_cookie)
-	return c.JSON(http.StatusOK, jwtResp)
+	return h.issueToken(c, uidStr, jwtResp)
 }
 
 // @Summary		Github
@@ -232,7 +337,7 @@ func (h *AuthHandler) GoogleAuthCallback(c echo.Context) error {
 func (h *AuthHandler) GithubAuthCallback(c echo.Context) error {
 	var req OAuthCallbackRequest
 	if err := c.Bind(&req); err != nil {
-		return h.resp.newErrorResponse(c, http.StatusBadRequest, err.Error())
+		return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
 	}
 
 	ctx := context.Background()
@@ -241,13 +346,13 @@ func (h *AuthHandler) GithubAuthCallback(c echo.Context) error {
 		oauth2.SetAuthURLParam("code_verifier", req.CodeVerifier),
 	)
 	if err != nil {
-		return h.resp.newErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("Token exchange failed: %v", err))
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, fmt.Errorf("Token exchange failed: %v", err))
 	}
 
 	client := h.oauth.GithubConfig.Client(ctx, token)
 	emailResp, err := client.Get("https://api.github.com/user/emails")
 	if err != nil {
-		return h.resp.newErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("Failed to get user emails: %v", err))
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, fmt.Errorf("Failed to get user emails: %v", err))
 	}
 	defer func() {
 		if err := emailResp.Body.Close(); err != nil {
@@ -256,7 +361,7 @@ func (h *AuthHandler) GithubAuthCallback(c echo.Context) error {
 	}()
 	userResp, err := client.Get("https://api.github.com/user")
 	if err != nil {
-		return h.resp.newErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("Failed to get user info: %v", err))
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, fmt.Errorf("Failed to get user info: %v", err))
 	}
 	defer func() {
 		if err := userResp.Body.Close(); err != nil {
@@ -266,11 +371,11 @@ func (h *AuthHandler) GithubAuthCallback(c echo.Context) error {
 
 	var emailInfo m.EmailList
 	if err := json.NewDecoder(emailResp.Body).Decode(&emailInfo.Emails); err != nil {
-		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
 	}
 	var userInfo m.GithubUserInfo
 	if err := json.NewDecoder(userResp.Body).Decode(&userInfo); err != nil {
-		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
 	}
 
 	name := userInfo.Name
@@ -295,33 +400,75 @@ func (h *AuthHandler) GithubAuthCallback(c echo.Context) error {
 			a := false
 			emailInfo.JustRegistered = &a
 		} else {
-			return h.resp.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
 		}
 	} else {
 		if !h.config.REGISTRATION_ENABLED {
-			return h.resp.newErrorResponse(c, http.StatusForbidden, errs.ErrRegistrationDisabled.Error())
+			return h.resp.newErrorResponse(c, http.StatusForbidden, errs.ErrRegistrationDisabled)
 		}
 	}
 
-	uidStr := fmt.Sprintf("%v", user.Uid)
-	jwtToken, err := h.JWT.GenerateJWT(uidStr)
-	if err != nil {
-		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+	if user.Disabled {
+		return h.resp.newErrorResponse(c, http.StatusForbidden, errs.ErrUserDisabled)
 	}
 
-	jwt_cookie := http.Cookie{
-		Name:     h.config.JWT_COOKIE_NAME,
-		Value:    jwtToken,
-		HttpOnly: h.config.JWT_HTTP_ONLY,
-		Secure:   h.config.JWT_SECURE,
-		Path:     "/",
+	uidStr := fmt.Sprintf("%v", user.Uid)
+	h.audit.RecordOAuthLogin(newSecurityEvent(c, m.SecurityLoginSuccess, uidStr), "github")
+
+	return h.issueToken(c, uidStr, jwtResp)
+}
+
+func NewAuthHandler(s service.UserService, audit service.SecurityEventService, passkeys service.PasskeyService, magicLinks service.MagicLinkService, cfg *c.Config, logger *zap.SugaredLogger, oauthConfig *auth.OAuth, JWT *security.JWT, resp *Responser) *AuthHandler {
+	return &AuthHandler{service: s, audit: audit, passkeys: passkeys, magicLinks: magicLinks, config: cfg, logger: logger, oauth: oauthConfig, JWT: JWT, resp: resp}
+}
+
+// @Summary		Logout
+// @Description	Clear the access token cookie. The client cannot do it itself because the cookie is HttpOnly.
+// @Tags			Auth
+// @Success		204
+// @Router			/api/auth/logout [post]
+func (h *AuthHandler) PostLogout(c echo.Context) error {
+	cookie := newTokenCookie(h.config, "")
+	cookie.MaxAge = -1
+	c.SetCookie(cookie)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// issueToken выдаёт пользователю токен доступа: в cookie и в теле ответа.
+func (h *AuthHandler) issueToken(c echo.Context, userID string, jwtResp m.TokenResponse) error {
+	jwtToken, err := h.JWT.GenerateJWT(userID)
+	if err != nil {
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
 	}
 
 	jwtResp.Token = jwtToken
-	c.SetCookie(&jwt_cookie)
+	c.SetCookie(newTokenCookie(h.config, jwtToken))
 	return c.JSON(http.StatusOK, jwtResp)
 }
 
-func NewAuthHandler(s service.UserService, cfg *c.Config, logger *zap.SugaredLogger, oauthConfig *auth.OAuth, JWT *security.JWT, resp *Responser) *AuthHandler {
-	return &AuthHandler{service: s, config: cfg, logger: logger, oauth: oauthConfig, JWT: JWT, resp: resp}
+// newTokenCookie — cookie с токеном доступа по политике из конфигурации.
+func newTokenCookie(cfg *c.Config, token string) *http.Cookie {
+	return &http.Cookie{
+		Name:     cfg.JWT_COOKIE_NAME,
+		Value:    token,
+		HttpOnly: cfg.JWT_HTTP_ONLY,
+		Secure:   cfg.JWT_SECURE,
+		SameSite: cfg.JWT_SAME_SITE,
+		Path:     "/",
+	}
+}
+
+func magicLinkErrorResponse(c echo.Context, resp *Responser, err error) error {
+	switch {
+	case errors.Is(err, errs.ErrMagicLinkDisabled):
+		return resp.newErrorResponse(c, http.StatusNotFound, err)
+	case errors.Is(err, errs.ErrEmailValidation):
+		return resp.newErrorResponse(c, http.StatusBadRequest, err)
+	case errors.Is(err, errs.ErrMagicLinkInvalid), errors.Is(err, errs.ErrMagicLinkBinding):
+		return resp.newErrorResponse(c, http.StatusUnauthorized, err)
+	case errors.Is(err, errs.ErrUserDisabled), errors.Is(err, errs.ErrRegistrationDisabled):
+		return resp.newErrorResponse(c, http.StatusForbidden, err)
+	default:
+		return resp.newErrorResponse(c, http.StatusInternalServerError, err)
+	}
 }
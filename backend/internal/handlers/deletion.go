@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	errs "sentimenta/internal/errors"
+	"sentimenta/internal/models"
+	"sentimenta/internal/problem"
+	"sentimenta/internal/service"
+	"sentimenta/internal/utils"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type DeletionHandler struct {
+	service service.DeletionService
+	audit   service.SecurityEventService
+	logger  *zap.SugaredLogger
+	resp    *Responser
+}
+
+// @Summary		Deletion code
+// @Description	Email a one-time code that confirms account deletion. Accounts without a password must use it; the code replaces any earlier one.
+// @Tags			User
+// @Success		202
+// @Failure		401	{object}	errorResponse
+// @Failure		500	{object}	errorResponse
+// @Router			/api/user/deletion/code [post]
+func (h *DeletionHandler) PostDeletionCode(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
+	}
+
+	if err := h.service.SendDeletionCode(userID, problem.Lang(c)); err != nil {
+		h.logger.Errorf("Ошибка при отправке кода удаления аккаунта: %v", err)
+		return h.deletionErrorResponse(c, err)
+	}
+	return c.NoContent(http.StatusAccepted)
+}
+
+// @Summary		Delete account
+// @Description	Schedule account deletion after a grace period. Requires the password or the code from POST /api/user/deletion/code; accounts without a password need the code. Returns an erasure receipt.
+// @Tags			User
+// @Accept			json
+// @Produce		json
+//
+// @Param			input	body		models.AccountDeleteReq	true	"re-authentication"
+//
+// @Success		202		{object}	models.AccountDeletion
+// @Failure		400		{object}	errorResponse
+// @Failure		401		{object}	errorResponse
+// @Failure		403		{object}	errorResponse
+// @Failure		409		{object}	errorResponse
+// @Failure		500		{object}	errorResponse
+// @Router			/api/user [delete]
+func (h *DeletionHandler) DeleteUser(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
+	}
+
+	var req models.AccountDeleteReq
+	if err := c.Bind(&req); err != nil {
+		return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
+	}
+
+	deletion, err := h.service.RequestDeletion(userID, req)
+	if err != nil {
+		h.logger.Errorf("Ошибка при запросе удаления аккаунта: %v", err)
+		return h.deletionErrorResponse(c, err)
+	}
+	if deletion.Status == models.DeletionScheduled {
+		event := newSecurityEvent(c, models.SecurityDeletionRequest, userID)
+		event.Details = map[string]string{"receipt_id": deletion.ReceiptID}
+		h.audit.Record(event)
+	}
+	return c.JSON(http.StatusAccepted, deletion)
+}
+
+// @Summary		Cancel account deletion
+// @Description	Cancel scheduled account deletion during the grace period
+// @Tags			User
+// @Produce		json
+// @Success		200	{object}	models.AccountDeletion
+// @Failure		401	{object}	errorResponse
+// @Failure		404	{object}	errorResponse
+// @Failure		500	{object}	errorResponse
+// @Router			/api/user/deletion/cancel [post]
+func (h *DeletionHandler) PostCancelDeletion(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
+	}
+
+	deletion, err := h.service.CancelDeletion(userID)
+	if err != nil {
+		h.logger.Errorf("Ошибка при отмене удаления аккаунта: %v", err)
+		return h.deletionErrorResponse(c, err)
+	}
+	event := newSecurityEvent(c, models.SecurityDeletionCancelled, userID)
+	event.Details = map[string]string{"receipt_id": deletion.ReceiptID}
+	h.audit.Record(event)
+	return c.JSON(http.StatusOK, deletion)
+}
+
+// @Summary		Erasure receipt
+// @Description	Get the status of an account deletion by receipt id. Does not require authentication, the account may already be deleted.
+// @Tags			User
+// @Produce		json
+// @Param			id	path		string	true	"receipt id"
+// @Success		200	{object}	models.AccountDeletion
+// @Failure		404	{object}	errorResponse
+// @Failure		500	{object}	errorResponse
+// @Router			/api/user/deletion/receipt/{id} [get]
+func (h *DeletionHandler) GetReceipt(c echo.Context) error {
+	deletion, err := h.service.GetReceipt(c.Param("id"))
+	if err != nil {
+		return h.deletionErrorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, deletion)
+}
+
+func (h *DeletionHandler) deletionErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, errs.ErrDeletionReauth):
+		return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
+	case errors.Is(err, errs.ErrWrongPassword), errors.Is(err, errs.ErrDeletionCodeInvalid):
+		return h.resp.newErrorResponse(c, http.StatusForbidden, err)
+	case errors.Is(err, errs.ErrDeletionScheduled):
+		return h.resp.newErrorResponse(c, http.StatusConflict, err)
+	case errors.Is(err, errs.ErrDeletionNotScheduled), errors.Is(err, gorm.ErrRecordNotFound):
+		return h.resp.newErrorResponse(c, http.StatusNotFound, err)
+	default:
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
+	}
+}
+
+func NewDeletionHandler(service service.DeletionService, audit service.SecurityEventService, logger *zap.SugaredLogger, resp *Responser) *DeletionHandler {
+	return &DeletionHandler{service: service, audit: audit, logger: logger, resp: resp}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sentimenta/internal/models"
+	"sentimenta/internal/service"
+	"sentimenta/internal/utils"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+type ExportHandler struct {
+	service service.ExportService
+	audit   service.SecurityEventService
+	logger  *zap.SugaredLogger
+	resp    *Responser
+}
+
+// @Summary		Export data
+// @Description	Download all data of the current user: profile, moods, advices with history and security log
+// @Tags			User
+// @Produce		json
+// @Success		200	{object}	models.UserExport
+// @Failure		401	{object}	errorResponse
+// @Failure		429	{object}	errorResponse
+// @Failure		500	{object}	errorResponse
+// @Router			/api/user/export [get]
+func (h *ExportHandler) GetExport(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
+	}
+
+	export, err := h.service.ExportUser(userID)
+	if err != nil {
+		h.logger.Errorf("Ошибка при выгрузке данных пользователя: %v", err)
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
+	}
+	h.audit.Record(newSecurityEvent(c, models.SecurityDataExport, userID))
+
+	filename := fmt.Sprintf("sentimenta-export-%s.json", time.Now().Format("2006-01-02"))
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	return c.JSON(http.StatusOK, export)
+}
+
+func NewExportHandler(service service.ExportService, audit service.SecurityEventService, logger *zap.SugaredLogger, resp *Responser) *ExportHandler {
+	return &ExportHandler{service: service, audit: audit, logger: logger, resp: resp}
+}
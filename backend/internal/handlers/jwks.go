@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"net/http"
+	"sentimenta/internal/models"
+	"sentimenta/internal/security"
+
+	"github.com/labstack/echo/v4"
+)
+
+type JWKSHandler struct {
+	JWT *security.JWT
+}
+
+// @Summary		JSON Web Key Set
+// @Description	Public keys for verifying access tokens, identified by kid. Empty when tokens are signed with a shared secret. Verifiers should refetch the set when they see an unknown kid.
+// @Tags			Auth
+// @Produce		json
+// @Success		200	{object}	models.JWKSet
+// @Router			/.well-known/jwks.json [get]
+func (h *JWKSHandler) GetJWKS(c echo.Context) error {
+	set := models.JWKSet{Keys: []models.JWK{}}
+	if keys := h.JWT.Keys(); keys != nil {
+		set = keys.JWKS()
+	}
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(http.StatusOK, set)
+}
+
+func NewJWKSHandler(JWT *security.JWT) *JWKSHandler {
+	return &JWKSHandler{JWT: JWT}
+}
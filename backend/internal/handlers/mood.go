@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"encoding/base64"
+	"errors"
 	"net/http"
 	c "sentimenta/internal/config"
 	errs "sentimenta/internal/errors"
@@ -10,6 +12,7 @@ import (
 
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
+	"gorm.io/gorm"
 )
 
 type MoodHandler struct {
@@ -35,25 +38,28 @@ type MoodHandler struct {
 func (h *MoodHandler) PostAddMood(c echo.Context) error {
 	userID, err := utils.GetUserID(c)
 	if err != nil {
-		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err.Error())
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
 	}
 
 	var reqMood models.MoodAdd
 	if err := c.Bind(&reqMood); err != nil {
-		return h.resp.newErrorResponse(c, http.StatusBadRequest, err.Error())
+		return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
 	}
 
-	if len([]rune(reqMood.Description)) > h.config.MOOD_DESC_LENGTH_MAX {
-		return h.resp.newErrorResponse(c, http.StatusBadRequest, errs.ErrMoodDescLength.Error())
+	if len([]rune(reqMood.Description)) > h.descLengthMax(reqMood.E2E) {
+		return h.resp.newErrorResponse(c, http.StatusBadRequest, errs.ErrMoodDescLength)
 	}
 
 	if len([]rune(reqMood.Emotions)) > h.config.MOOD_EMOTES_LENGTH_MAX {
-		return h.resp.newErrorResponse(c, http.StatusBadRequest, errs.ErrMoodEmotesLength.Error())
+		return h.resp.newErrorResponse(c, http.StatusBadRequest, errs.ErrMoodEmotesLength)
 	}
 
-	mood, err := h.service.CreateMood(userID, reqMood.Score, reqMood.Emotions, reqMood.Description, reqMood.Date)
+	mood, err := h.service.CreateMood(c.Request().Context(), userID, reqMood.Score, reqMood.Emotions, reqMood.Description, reqMood.Date, reqMood.E2E)
 	if err != nil {
-		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		if errors.Is(err, errs.ErrMoodE2ERequired) || errors.Is(err, errs.ErrMoodE2EInvalid) {
+			return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
+		}
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
 	}
 
 	return c.JSON(http.StatusOK, mood)
@@ -73,12 +79,12 @@ func (h *MoodHandler) PostAddMood(c echo.Context) error {
 func (h *MoodHandler) GetMoods(c echo.Context) error {
 	userID, err := utils.GetUserID(c)
 	if err != nil {
-		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err.Error())
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
 	}
 
 	moods, err := h.service.GetMoods(userID)
 	if err != nil {
-		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
 	}
 
 	return c.JSON(http.StatusOK, moods)
@@ -100,12 +106,12 @@ func (h *MoodHandler) GetMoods(c echo.Context) error {
 func (h *MoodHandler) PutUpdateMood(c echo.Context) error {
 	userID, err := utils.GetUserID(c)
 	if err != nil {
-		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err.Error())
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
 	}
 
 	var reqMood models.MoodUpdate
 	if err := c.Bind(&reqMood); err != nil {
-		return h.resp.newErrorResponse(c, http.StatusBadRequest, err.Error())
+		return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
 	}
 
 	mood := models.Mood{
@@ -113,15 +119,64 @@ func (h *MoodHandler) PutUpdateMood(c echo.Context) error {
 		Score:       *reqMood.Score,
 		Emotions:    *reqMood.Emotions,
 		Description: *reqMood.Description,
+		E2E:         reqMood.E2E,
 	}
 
-	if err := h.service.UpdateMood(userID, &mood); err != nil {
-		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+	if len([]rune(mood.Description)) > h.descLengthMax(mood.E2E) {
+		return h.resp.newErrorResponse(c, http.StatusBadRequest, errs.ErrMoodDescLength)
+	}
+
+	if err := h.service.UpdateMood(c.Request().Context(), userID, &mood); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return h.resp.newErrorResponse(c, http.StatusNotFound, err)
+		}
+		if errors.Is(err, errs.ErrMoodE2ERequired) || errors.Is(err, errs.ErrMoodE2EInvalid) {
+			return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
+		}
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
 	}
 
 	return c.JSON(http.StatusOK, mood)
 }
 
+// @Summary		Delete mood
+// @Description	Delete mood by id
+// @Tags			Moods
+// @Produce		json
+//
+// @Param			id	path		int	true	"mood id"
+//
+// @Success		200	{object}	okResponse
+// @Failure		401	{object}	errorResponse
+// @Failure		404	{object}	errorResponse
+// @Failure		500	{object}	errorResponse
+// @Router			/api/moods/delete/{id} [delete]
+func (h *MoodHandler) DeleteMood(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
+	}
+
+	if err := h.service.DeleteMood(userID, c.Param("id")); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return h.resp.newErrorResponse(c, http.StatusNotFound, err)
+		}
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
+	}
+
+	return c.JSON(http.StatusOK, okResponse{"mood deleted successfully"})
+}
+
+// descLengthMax возвращает допустимую длину описания. Для шифротекста
+// это длина в base64 описания максимальной длины: до 4 байт UTF-8 на
+// символ и 16 байт тега.
+func (h *MoodHandler) descLengthMax(e2e *models.E2EMetadata) int {
+	if e2e == nil {
+		return h.config.MOOD_DESC_LENGTH_MAX
+	}
+	return base64.StdEncoding.EncodedLen(h.config.MOOD_DESC_LENGTH_MAX*4 + 16)
+}
+
 func NewMoodHandler(s service.MoodService, cfg *c.Config, logger *zap.SugaredLogger, resp *Responser) *MoodHandler {
 	return &MoodHandler{service: s, config: cfg, logger: logger, resp: resp}
 }
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	errs "sentimenta/internal/errors"
+	"sentimenta/internal/models"
+	"sentimenta/internal/service"
+	"sentimenta/internal/utils"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type PasskeyHandler struct {
+	service service.PasskeyService
+	audit   service.SecurityEventService
+	logger  *zap.SugaredLogger
+	resp    *Responser
+}
+
+// @Summary		Passkey registration options
+// @Description	Start registering a passkey for the current user. Pass options to navigator.credentials.create and session_id to /api/user/passkeys/register/finish.
+// @Tags			User
+// @Produce		json
+// @Success		200	{object}	models.PasskeyOptions
+// @Failure		401	{object}	errorResponse
+// @Failure		404	{object}	errorResponse
+// @Failure		500	{object}	errorResponse
+// @Router			/api/user/passkeys/register/begin [post]
+func (h *PasskeyHandler) PostRegisterBegin(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
+	}
+
+	options, err := h.service.BeginRegistration(userID)
+	if err != nil {
+		return passkeyErrorResponse(c, h.resp, err)
+	}
+	return c.JSON(http.StatusOK, options)
+}
+
+// @Summary		Register passkey
+// @Description	Finish registering a passkey. The body is the PublicKeyCredential returned by navigator.credentials.create.
+// @Tags			User
+// @Accept			json
+// @Produce		json
+// @Param			session_id	query		string	true	"session id from /api/user/passkeys/register/begin"
+// @Param			name		query		string	false	"passkey name shown in the list"
+// @Success		201			{object}	models.Passkey
+// @Failure		400			{object}	errorResponse
+// @Failure		401			{object}	errorResponse
+// @Failure		500			{object}	errorResponse
+// @Router			/api/user/passkeys/register/finish [post]
+func (h *PasskeyHandler) PostRegisterFinish(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
+	}
+
+	passkey, err := h.service.FinishRegistration(userID, c.QueryParam("session_id"), c.QueryParam("name"), c.Request().Body)
+	if err != nil {
+		return passkeyErrorResponse(c, h.resp, err)
+	}
+	event := newSecurityEvent(c, models.SecurityPasskeyAdd, userID)
+	event.Details = map[string]string{"passkey_id": strconv.Itoa(passkey.Uid)}
+	h.audit.Record(event)
+	return c.JSON(http.StatusCreated, passkey)
+}
+
+// @Summary		Passkeys
+// @Description	Passkeys registered by the current user
+// @Tags			User
+// @Produce		json
+// @Success		200	{array}		models.Passkey
+// @Failure		401	{object}	errorResponse
+// @Failure		500	{object}	errorResponse
+// @Router			/api/user/passkeys [get]
+func (h *PasskeyHandler) GetPasskeys(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
+	}
+
+	passkeys, err := h.service.GetPasskeys(userID)
+	if err != nil {
+		h.logger.Errorf("Ошибка при получении ключей доступа: %v", err)
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, passkeys)
+}
+
+// @Summary		Delete passkey
+// @Description	Remove a passkey of the current user
+// @Tags			User
+// @Produce		json
+// @Param			id	path		int	true	"passkey id"
+// @Success		200	{object}	okResponse
+// @Failure		400	{object}	errorResponse
+// @Failure		401	{object}	errorResponse
+// @Failure		404	{object}	errorResponse
+// @Failure		500	{object}	errorResponse
+// @Router			/api/user/passkeys/{id} [delete]
+func (h *PasskeyHandler) DeletePasskey(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
+	}
+
+	if err := h.service.DeletePasskey(userID, id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return h.resp.newErrorResponse(c, http.StatusNotFound, err)
+		}
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
+	}
+	event := newSecurityEvent(c, models.SecurityPasskeyRemove, userID)
+	event.Details = map[string]string{"passkey_id": strconv.Itoa(id)}
+	h.audit.Record(event)
+	return c.JSON(http.StatusOK, okResponse{"passkey deleted successfully"})
+}
+
+func passkeyErrorResponse(c echo.Context, resp *Responser, err error) error {
+	switch {
+	case errors.Is(err, errs.ErrPasskeysDisabled):
+		return resp.newErrorResponse(c, http.StatusNotFound, err)
+	case errors.Is(err, errs.ErrPasskeySession):
+		return resp.newErrorResponse(c, http.StatusBadRequest, err)
+	case errors.Is(err, errs.ErrPasskeyInvalid), errors.Is(err, errs.ErrPasskeyCloned):
+		return resp.newErrorResponse(c, http.StatusUnauthorized, err)
+	case errors.Is(err, errs.ErrUserDisabled):
+		return resp.newErrorResponse(c, http.StatusForbidden, err)
+	default:
+		return resp.newErrorResponse(c, http.StatusInternalServerError, err)
+	}
+}
+
+func NewPasskeyHandler(s service.PasskeyService, audit service.SecurityEventService, logger *zap.SugaredLogger, resp *Responser) *PasskeyHandler {
+	return &PasskeyHandler{service: s, audit: audit, logger: logger, resp: resp}
+}
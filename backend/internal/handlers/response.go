@@ -3,14 +3,14 @@ package handlers
 import (
 	"net/http"
 	"sentimenta/internal/metrics"
+	"sentimenta/internal/problem"
 
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
 )
 
-type errorResponse struct {
-	ErrorMessage string `json:"error"`
-}
+// errorResponse — ошибка в формате RFC 7807, см. пакет problem.
+type errorResponse = problem.Problem
 
 type okResponse struct {
 	Message string `json:"message"`
@@ -21,9 +21,15 @@ type Responser struct {
 	logger     *zap.SugaredLogger
 }
 
-func (r *Responser) newErrorResponse(c echo.Context, statusCode int, message string) error {
+// newErrorResponse отвечает ошибкой с кодом из каталога и сообщением на
+// языке запроса. Текст неизвестных ошибок 5xx клиенту не отдаётся, а
+// пишется в лог.
+func (r *Responser) newErrorResponse(c echo.Context, statusCode int, err error) error {
 	r.prometheus.HttpErrorsTotal.WithLabelValues(c.Request().Method, c.Path(), http.StatusText(statusCode)).Inc()
-	return c.JSON(statusCode, errorResponse{ErrorMessage: message})
+	if statusCode >= http.StatusInternalServerError {
+		r.logger.Errorf("%s %s: %v", c.Request().Method, c.Path(), err)
+	}
+	return problem.Write(c, statusCode, err)
 }
 
 func NewResponser(prometheus *metrics.Prometheus, logger *zap.SugaredLogger) *Responser {
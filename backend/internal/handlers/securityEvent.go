@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+	"sentimenta/internal/models"
+	"sentimenta/internal/service"
+	"sentimenta/internal/utils"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+type SecurityEventHandler struct {
+	service service.SecurityEventService
+	logger  *zap.SugaredLogger
+	resp    *Responser
+}
+
+// @Summary		Security events
+// @Description	Security log of the current user: logins, password and email changes, account deletion requests. Newest first.
+// @Tags			User
+// @Produce		json
+// @Param			page	query		int	false	"page number, starting from 1"
+// @Param			limit	query		int	false	"page size, max 100"
+// @Success		200		{object}	models.Page[models.SecurityEvent]
+// @Failure		401		{object}	errorResponse
+// @Failure		500		{object}	errorResponse
+// @Router			/api/user/security-events [get]
+func (h *SecurityEventHandler) GetUserEvents(c echo.Context) error {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
+	}
+	uidInt, err := strconv.Atoi(userID)
+	if err != nil {
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
+	}
+
+	page, limit := utils.GetPagination(c)
+	events, err := h.service.GetUserEvents(uidInt, page, limit)
+	if err != nil {
+		h.logger.Errorf("Ошибка при получении журнала безопасности: %v", err)
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, events)
+}
+
+// newSecurityEvent заполняет событие данными запроса. userID может быть
+// пустым, если пользователь неизвестен, например при входе с несуществующей
+// почтой.
+func newSecurityEvent(c echo.Context, eventType, userID string) models.SecurityEvent {
+	event := models.SecurityEvent{
+		Type:      eventType,
+		IP:        c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+	}
+	if uidInt, err := strconv.Atoi(userID); err == nil && uidInt != 0 {
+		event.UserID = &uidInt
+	}
+	return event
+}
+
+func NewSecurityEventHandler(service service.SecurityEventService, logger *zap.SugaredLogger, resp *Responser) *SecurityEventHandler {
+	return &SecurityEventHandler{service: service, logger: logger, resp: resp}
+}
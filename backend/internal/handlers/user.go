@@ -1,11 +1,13 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	c "sentimenta/internal/config"
 	errs "sentimenta/internal/errors"
 	m "sentimenta/internal/models"
 	"sentimenta/internal/service"
+	"sentimenta/internal/strength"
 	"sentimenta/internal/utils"
 
 	"github.com/jinzhu/copier"
@@ -34,19 +36,19 @@ func (h *UserHandler) GetUser(c echo.Context) error {
 	userID, err := utils.GetUserID(c)
 	if err != nil {
 		h.logger.Errorf("Ошибка. Требуется аутентификация: %v", err)
-		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err.Error())
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
 	}
 
 	user, err := h.service.GetUser(userID)
 	if err != nil {
 		h.logger.Errorf("Ошибка при получении пользователя: %v", err)
-		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
 	}
 
 	var userGet m.UserGet
 	if err := copier.Copy(&userGet, user); err != nil {
 		h.logger.Errorf("Ошибка при копировании данных пользователя: %v", err)
-		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
 	}
 
 	return c.JSON(http.StatusOK, userGet)
@@ -69,22 +71,25 @@ func (h *UserHandler) PatchUpdateUser(c echo.Context) error {
 	userID, err := utils.GetUserID(c)
 	if err != nil {
 		h.logger.Errorf("Ошибка. Требуется аутентификация: %v", err)
-		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err.Error())
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
 	}
 
 	var reqUser m.UserUpdateReq
 	if err := c.Bind(&reqUser); err != nil {
-		return h.resp.newErrorResponse(c, http.StatusBadRequest, err.Error())
+		return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
 	}
 
 	if !h.config.AI_ENABLED {
 		reqUser.UseAI = nil
 	}
 
-	user, err := h.service.UpdateUser(userID, reqUser)
+	user, err := h.service.UpdateUser(userID, reqUser, newSecurityEvent(c, m.SecurityEmailChange, userID))
 	if err != nil {
+		if errors.Is(err, errs.ErrLocaleUnsupported) {
+			return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
+		}
 		h.logger.Errorf("Ошибка при обновлении пользователя: %v", err)
-		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
 	}
 
 	return c.JSON(http.StatusOK, user)
@@ -98,7 +103,7 @@ func (h *UserHandler) PatchUpdateUser(c echo.Context) error {
 //
 // @Param			input	body		models.UserChangePass	true	"credentials"
 //
-// @Success		200		{object}	models.User
+// @Success		200		{object}	okResponse
 // @Failure		401		{object}	errorResponse
 // @Failure		400		{object}	errorResponse
 // @Failure		500		{object}	errorResponse
@@ -107,22 +112,25 @@ func (h *UserHandler) PutUpdatePasswordUser(c echo.Context) error {
 	userID, err := utils.GetUserID(c)
 	if err != nil {
 		h.logger.Errorf("Ошибка. Требуется аутентификация: %v", err)
-		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err.Error())
+		return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
 	}
 
 	var reqUser m.UserChangePass
 	if err := c.Bind(&reqUser); err != nil {
-		return h.resp.newErrorResponse(c, http.StatusBadRequest, err.Error())
-	}
-
-	if len([]rune(reqUser.Password)) < h.config.PASSWORD_LENGTH_MIN {
-		h.logger.Infof("Регистрация отклонена: длина пароля меньше нужного")
-		return h.resp.newErrorResponse(c, http.StatusBadRequest, errs.ErrPasswordLength.Error())
+		return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
 	}
 
-	if err := h.service.ChangePassword(userID, reqUser.Password, reqUser.NewPassword); err != nil {
+	event := newSecurityEvent(c, m.SecurityPasswordChange, userID)
+	if err := h.service.ChangePassword(userID, reqUser.Password, reqUser.NewPassword, event); err != nil {
+		var policyErr *strength.PolicyError
+		if errors.As(err, &policyErr) {
+			return h.resp.newErrorResponse(c, http.StatusBadRequest, err)
+		}
+		if errors.Is(err, errs.ErrWrongPassword) {
+			return h.resp.newErrorResponse(c, http.StatusUnauthorized, err)
+		}
 		h.logger.Errorf("Ошибка при смене пароля: %v", err)
-		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return h.resp.newErrorResponse(c, http.StatusInternalServerError, err)
 	}
 	return c.JSON(http.StatusOK, okResponse{"password changed successfully"})
 }
@@ -4,8 +4,13 @@ import (
 	"errors"
 	"io"
 	"net/http"
+	"net/url"
+	"sentimenta/internal/config"
+	errs "sentimenta/internal/errors"
+	"sentimenta/internal/problem"
 	"sentimenta/internal/utils"
 	"sentimenta/internal/ws"
+	"strings"
 
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
@@ -13,24 +18,40 @@ import (
 )
 
 type WSHandler struct {
-	logger  *zap.SugaredLogger
-	connMgr *ws.ConnectionManager
-	// config *config.Config
+	logger   *zap.SugaredLogger
+	connMgr  *ws.ConnectionManager
+	config   *config.Config
+	upgrader websocket.Upgrader
 }
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
+// checkOrigin пропускает запросы без Origin (клиенты вне браузера), с того
+// же хоста и с источников из ALLOWED_ORIGINS. Иначе чужая страница могла
+// бы открыть соединение с cookie пользователя.
+func (h *WSHandler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
 		return true
-	},
+	}
+	for _, allowed := range h.config.ALLOWED_ORIGINS {
+		if allowed == "*" || strings.EqualFold(strings.TrimSpace(allowed), origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	h.logger.Warnf("WS: отклонено соединение с источника %s", origin)
+	return false
 }
 
 func (h *WSHandler) HandleWS(c echo.Context) error {
 	userID, err := utils.GetUserID(c)
 	if err != nil {
-		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return problem.Write(c, http.StatusUnauthorized, errs.ErrAuthRequired)
 	}
 
-	conn, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+	conn, err := h.upgrader.Upgrade(c.Response(), c.Request(), nil)
 	if err != nil {
 		h.logger.Error("failed to upgrade to websocket: ", err)
 		return err
@@ -40,8 +61,8 @@ func (h *WSHandler) HandleWS(c echo.Context) error {
 			h.logger.Errorf("Failed to close ws connection: %v\n", err)
 		}
 	}()
-	h.connMgr.Add(userID, conn)
-	defer h.connMgr.Remove(userID)
+	client := h.connMgr.Add(userID, conn)
+	defer h.connMgr.Remove(userID, client)
 
 	h.logger.Infof("User %s connected via WebSocket", userID)
 	for {
@@ -62,7 +83,7 @@ func (h *WSHandler) HandleWS(c echo.Context) error {
 			break
 		}
 		reply := "Echo from server: " + string(msg)
-		if err := conn.WriteMessage(msgType, []byte(reply)); err != nil {
+		if err := client.WriteMessage(msgType, []byte(reply)); err != nil {
 			h.logger.Error("write error:", err)
 			break
 		}
@@ -70,6 +91,8 @@ func (h *WSHandler) HandleWS(c echo.Context) error {
 	return nil
 }
 
-func NewWSHandler(logger *zap.SugaredLogger, connMgr *ws.ConnectionManager) *WSHandler {
-	return &WSHandler{logger: logger, connMgr: connMgr}
+func NewWSHandler(cfg *config.Config, logger *zap.SugaredLogger, connMgr *ws.ConnectionManager) *WSHandler {
+	h := &WSHandler{logger: logger, connMgr: connMgr, config: cfg}
+	h.upgrader = websocket.Upgrader{CheckOrigin: h.checkOrigin}
+	return h
 }
@@ -0,0 +1,112 @@
+package hash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	errs "sentimenta/internal/errors"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2id — хеши вида "$argon2id$v=19$m=65536,t=3,p=2$<соль>$<хеш>".
+// Memory задаётся в КиБ.
+type Argon2id struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// Верхние пределы параметров. Без них хеш с m или t, подменёнными в БД,
+// заставил бы сервер занять гигабайты памяти или считать минутами.
+const (
+	argon2MaxMemory     = 4 * 1024 * 1024
+	argon2MaxIterations = 100
+)
+
+type argon2Hash struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func (a *Argon2id) ID() []string {
+	return []string{"argon2id"}
+}
+
+func (a *Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.Memory, a.Iterations, a.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (a *Argon2id) Verify(password, encoded string) (bool, error) {
+	h, err := parseArgon2(encoded)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey([]byte(password), h.salt, h.iterations, h.memory, h.parallelism, uint32(len(h.key)))
+	return subtle.ConstantTimeCompare(key, h.key) == 1, nil
+}
+
+func (a *Argon2id) Outdated(encoded string) bool {
+	h, err := parseArgon2(encoded)
+	if err != nil {
+		return true
+	}
+	return h.memory != a.Memory ||
+		h.iterations != a.Iterations ||
+		h.parallelism != a.Parallelism ||
+		uint32(len(h.salt)) != a.SaltLength ||
+		uint32(len(h.key)) != a.KeyLength
+}
+
+func parseArgon2(encoded string) (argon2Hash, error) {
+	var h argon2Hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return h, errs.ErrPasswordHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return h, errs.ErrPasswordHashFormat
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.iterations, &h.parallelism); err != nil {
+		return h, errs.ErrPasswordHashFormat
+	}
+	if !validArgon2(uint64(h.memory), uint64(h.iterations), uint64(h.parallelism)) {
+		return h, errs.ErrPasswordHashFormat
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return h, errs.ErrPasswordHashFormat
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(h.key) == 0 {
+		return h, errs.ErrPasswordHashFormat
+	}
+	return h, nil
+}
+
+// validArgon2 проверяет параметры по RFC 9106: t >= 1, 1 <= p <= 255,
+// m >= 8*p КиБ. argon2.IDKey паникует при t или p, равных нулю.
+func validArgon2(memory, iterations, parallelism uint64) bool {
+	return iterations >= 1 && iterations <= argon2MaxIterations &&
+		parallelism >= 1 && parallelism <= 255 &&
+		memory >= 8*parallelism && memory <= argon2MaxMemory
+}
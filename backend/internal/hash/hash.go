@@ -1,22 +1,103 @@
+// Package hash хеширует пароли.
+//
+// Хеши хранятся в формате PHC: "$<алгоритм>$<параметры>$<соль>$<хеш>".
+// Новые хеши создаются алгоритмом из PASSWORD_HASH_ALG (по умолчанию
+// argon2id), а проверяются любым зарегистрированным алгоритмом, поэтому
+// старые хеши bcrypt продолжают работать. Verify сообщает, что хеш создан
+// другим алгоритмом или с другими параметрами, и его стоит пересчитать,
+// пока известен пароль.
 package hash
 
 import (
-	"log"
+	"sentimenta/internal/config"
+	errs "sentimenta/internal/errors"
+	"strings"
 
-	c "golang.org/x/crypto/bcrypt"
+	"go.uber.org/zap"
 )
 
-func HashPassword(password string) string {
-	hashBytes, err := c.GenerateFromPassword([]byte(password), 12)
+// Hasher — один алгоритм хеширования паролей.
+type Hasher interface {
+	// ID — идентификаторы алгоритма в начале хеша PHC
+	ID() []string
+	Hash(password string) (string, error)
+	Verify(password, encoded string) (bool, error)
+	// Outdated сообщает, что хеш создан с другими параметрами
+	Outdated(encoded string) bool
+}
+
+// Registry создаёт хеши текущим алгоритмом и проверяет хеши всех
+// известных алгоритмов.
+type Registry struct {
+	current Hasher
+	hashers map[string]Hasher
+}
+
+func (r *Registry) Hash(password string) (string, error) {
+	return r.current.Hash(password)
+}
+
+// Verify проверяет пароль. rehash равен true, если пароль верный, но хеш
+// нужно пересчитать текущим алгоритмом.
+func (r *Registry) Verify(password, encoded string) (ok bool, rehash bool, err error) {
+	id, err := phcID(encoded)
 	if err != nil {
-		log.Fatalf("не удалось хешировать пароль: %v", err)
+		return false, false, err
+	}
+	hasher, found := r.hashers[id]
+	if !found {
+		return false, false, errs.ErrPasswordHashAlg
+	}
+
+	ok, err = hasher.Verify(password, encoded)
+	if err != nil || !ok {
+		return false, false, err
+	}
+	return true, hasher != r.current || hasher.Outdated(encoded), nil
+}
+
+func (r *Registry) register(h Hasher) {
+	for _, id := range h.ID() {
+		r.hashers[id] = h
+	}
+}
+
+func phcID(encoded string) (string, error) {
+	parts := strings.SplitN(encoded, "$", 3)
+	if len(parts) < 3 || parts[0] != "" || parts[1] == "" {
+		return "", errs.ErrPasswordHashFormat
 	}
-	return string(hashBytes)
+	return parts[1], nil
 }
 
-func VerifyPassword(password string, hashed_password string) bool {
-	if err := c.CompareHashAndPassword([]byte(hashed_password), []byte(password)); err != nil {
-		return false
+// NewRegistry создаёт реестр с параметрами из конфигурации.
+func NewRegistry(cfg *config.Config, log *zap.SugaredLogger) *Registry {
+	if cfg.ARGON2_MEMORY_KB < 0 || cfg.ARGON2_ITERATIONS < 0 || cfg.ARGON2_PARALLELISM < 0 ||
+		!validArgon2(uint64(cfg.ARGON2_MEMORY_KB), uint64(cfg.ARGON2_ITERATIONS), uint64(cfg.ARGON2_PARALLELISM)) {
+		log.Fatalf("Неверные параметры Argon2id: ARGON2_MEMORY_KB=%d, ARGON2_ITERATIONS=%d, ARGON2_PARALLELISM=%d",
+			cfg.ARGON2_MEMORY_KB, cfg.ARGON2_ITERATIONS, cfg.ARGON2_PARALLELISM)
+	}
+
+	argon := &Argon2id{
+		Memory:      uint32(cfg.ARGON2_MEMORY_KB),
+		Iterations:  uint32(cfg.ARGON2_ITERATIONS),
+		Parallelism: uint8(cfg.ARGON2_PARALLELISM),
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+	bcrypt := &Bcrypt{Cost: cfg.BCRYPT_COST}
+
+	r := &Registry{hashers: map[string]Hasher{}}
+	r.register(argon)
+	r.register(bcrypt)
+
+	switch cfg.PASSWORD_HASH_ALG {
+	case "argon2id":
+		r.current = argon
+	case "bcrypt":
+		r.current = bcrypt
+	default:
+		log.Fatalf("%v: PASSWORD_HASH_ALG=%s", errs.ErrPasswordHashAlg, cfg.PASSWORD_HASH_ALG)
 	}
-	return true
+	return r
 }
@@ -0,0 +1,105 @@
+// Package i18n выбирает язык ответа. Поддерживаются русский (по
+// умолчанию) и английский.
+package i18n
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	RU = "ru"
+	EN = "en"
+
+	Default = RU
+)
+
+var Supported = []string{RU, EN}
+
+// Parse выбирает поддерживаемый язык из заголовка Accept-Language с
+// учётом весов q. Если подходящего нет, возвращает Default.
+func Parse(acceptLanguage string) string {
+	best, bestQ := Default, 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		base, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if !slices.Contains(Supported, base) {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > bestQ {
+			best, bestQ = base, q
+		}
+	}
+	return best
+}
+
+// FromRequest — язык ответа на запрос.
+func FromRequest(r *http.Request) string {
+	return Parse(r.Header.Get("Accept-Language"))
+}
+
+// ContextKey — ключ в echo.Context с языком из настроек пользователя.
+// Он важнее Accept-Language.
+const ContextKey = "locale"
+
+// IsSupported сообщает, что язык можно сохранить в настройках.
+func IsSupported(lang string) bool {
+	return slices.Contains(Supported, lang)
+}
+
+// Detect грубо определяет язык текста по преобладающему алфавиту. Для
+// текста без букв (пустого, из цифр или эмодзи) ok равен false.
+func Detect(text string) (lang string, ok bool) {
+	var cyrillic, latin int
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		}
+	}
+	switch {
+	case cyrillic == 0 && latin == 0:
+		return "", false
+	case cyrillic > latin:
+		return RU, true
+	default:
+		return EN, true
+	}
+}
+
+// Resolve выбирает язык: из настроек пользователя, если он задан, иначе
+// по первому из текстов, в котором язык удалось определить.
+func Resolve(preferred string, texts ...string) string {
+	if IsSupported(preferred) {
+		return preferred
+	}
+	for _, text := range texts {
+		if lang, ok := Detect(text); ok {
+			return lang
+		}
+	}
+	return Default
+}
+
+// DateLayout — формат даты, привычный для языка.
+func DateLayout(lang string) string {
+	if lang == RU {
+		return "02.01.2006"
+	}
+	return time.DateOnly
+}
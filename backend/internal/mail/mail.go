@@ -0,0 +1,43 @@
+// Package mail отправляет письма пользователям.
+//
+// Отправитель выбирается по конфигурации: при заданном SMTP_HOST письма
+// уходят через SMTP, иначе только пишутся в лог. Для локальной проверки
+// подходит любой SMTP-сервер-заглушка (Mailpit, MailHog):
+// SMTP_HOST=localhost SMTP_PORT=1025 без логина и пароля.
+package mail
+
+import (
+	"context"
+	"sentimenta/internal/config"
+
+	"go.uber.org/zap"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// LogMailer пишет письма в лог вместо отправки. Только для разработки:
+// в лог попадают ссылки и коды для входа.
+type LogMailer struct {
+	logger *zap.SugaredLogger
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	m.logger.Infof("Письмо для %s: %s\n%s", msg.To, msg.Subject, msg.Text)
+	return nil
+}
+
+func NewMailer(cfg *config.Config, log *zap.SugaredLogger) Mailer {
+	if cfg.SMTP_HOST == "" {
+		log.Warn("SMTP_HOST не задан, письма будут только записываться в лог")
+		return &LogMailer{logger: log}
+	}
+	return NewSMTPMailer(cfg)
+}
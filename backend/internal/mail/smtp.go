@@ -0,0 +1,79 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"sentimenta/internal/config"
+	"strings"
+	"time"
+)
+
+var errInvalidHeader = errors.New("перевод строки в заголовке письма")
+
+type SMTPMailer struct {
+	addr string
+	host string
+	from string
+	// Адрес отправителя без имени для команды MAIL FROM
+	envelope string
+	username string
+	password string
+}
+
+// Send отправляет письмо в text/plain. smtp.SendMail сам включает
+// STARTTLS, если сервер его поддерживает. Аутентификация PLAIN
+// допускается только по TLS или на localhost.
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return errInvalidHeader
+	}
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(m.addr, auth, m.envelope, []string{msg.To}, m.build(msg))
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-done:
+		return err
+	}
+}
+
+func (m *SMTPMailer) build(msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Text, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+func NewSMTPMailer(cfg *config.Config) *SMTPMailer {
+	envelope := cfg.SMTP_FROM
+	if address, err := netmail.ParseAddress(cfg.SMTP_FROM); err == nil {
+		envelope = address.Address
+	}
+	return &SMTPMailer{
+		addr:     net.JoinHostPort(cfg.SMTP_HOST, cfg.SMTP_PORT),
+		host:     cfg.SMTP_HOST,
+		from:     cfg.SMTP_FROM,
+		envelope: envelope,
+		username: cfg.SMTP_USERNAME,
+		password: cfg.SMTP_PASSWORD,
+	}
+}
@@ -11,6 +11,22 @@ type Prometheus struct {
 
 	DBQueryDuration *prometheus.HistogramVec
 	DBErrorsTotal   *prometheus.CounterVec
+
+	AdviceFeedbackTotal *prometheus.CounterVec
+
+	SafetyFlagsTotal         *prometheus.CounterVec
+	SafetyOutputBlockedTotal *prometheus.CounterVec
+
+	RateLimitedTotal *prometheus.CounterVec
+
+	AIRequestsTotal      *prometheus.CounterVec
+	AIRequestDuration    *prometheus.HistogramVec
+	AIErrorsTotal        *prometheus.CounterVec
+	AIRetriesTotal       *prometheus.CounterVec
+	AICircuitOpen        *prometheus.GaugeVec
+	AITokensTotal        *prometheus.CounterVec
+	AICostDollarsTotal   *prometheus.CounterVec
+	AIQuotaExceededTotal *prometheus.CounterVec
 }
 
 func NewPrometheus() *Prometheus {
@@ -22,6 +38,22 @@ func NewPrometheus() *Prometheus {
 	// db_query_duration_seconds
 	// db_errors_total
 
+	// advice_feedback_total
+
+	// safety_flags_total
+	// safety_output_blocked_total
+
+	// rate_limited_total
+
+	// ai_requests_total
+	// ai_request_duration_seconds
+	// ai_errors_total
+	// ai_retries_total
+	// ai_circuit_open
+	// ai_tokens_total
+	// ai_cost_dollars_total
+	// ai_quota_exceeded_total
+
 	p := &Prometheus{
 		HttpRequestsTotal: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
@@ -63,6 +95,95 @@ func NewPrometheus() *Prometheus {
 			},
 			[]string{"query_type"},
 		),
+
+		AdviceFeedbackTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "advice_feedback_total",
+				Help: "Total number of advice ratings",
+			},
+			[]string{"model", "prompt_version", "rating"},
+		),
+
+		SafetyFlagsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "safety_flags_total",
+				Help: "Total number of mood entries flagged as crisis",
+			},
+			[]string{"source", "locale"},
+		),
+		SafetyOutputBlockedTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "safety_output_blocked_total",
+				Help: "Total number of AI responses rejected by the safety denylist",
+			},
+			[]string{"model"},
+		),
+
+		RateLimitedTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "rate_limited_total",
+				Help: "Total number of requests rejected by rate limiting",
+			},
+			[]string{"policy"},
+		),
+
+		AIRequestsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "ai_requests_total",
+				Help: "Total number of AI provider requests",
+			},
+			[]string{"model", "result"},
+		),
+		AIRequestDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "ai_request_duration_seconds",
+				Help:    "Duration of AI provider requests in seconds",
+				Buckets: []float64{0.5, 1, 2, 5, 10, 20, 30, 60},
+			},
+			[]string{"model"},
+		),
+		AIErrorsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "ai_errors_total",
+				Help: "Total number of failed AI provider requests",
+			},
+			[]string{"model", "kind"},
+		),
+		AIRetriesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "ai_retries_total",
+				Help: "Total number of retried AI provider requests",
+			},
+			[]string{"model"},
+		),
+		AICircuitOpen: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "ai_circuit_open",
+				Help: "Whether requests to the model are paused by the circuit breaker",
+			},
+			[]string{"model"},
+		),
+		AITokensTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "ai_tokens_total",
+				Help: "Total number of tokens used by AI models",
+			},
+			[]string{"model", "type"},
+		),
+		AICostDollarsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "ai_cost_dollars_total",
+				Help: "Estimated cost of AI requests in US dollars",
+			},
+			[]string{"model"},
+		),
+		AIQuotaExceededTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "ai_quota_exceeded_total",
+				Help: "Total number of advice requests rejected by per-user AI quotas",
+			},
+			[]string{"period"},
+		),
 	}
 
 	// Регистрация метрик
@@ -72,6 +193,18 @@ func NewPrometheus() *Prometheus {
 		p.HttpErrorsTotal,
 		p.DBQueryDuration,
 		p.DBErrorsTotal,
+		p.AdviceFeedbackTotal,
+		p.SafetyFlagsTotal,
+		p.SafetyOutputBlockedTotal,
+		p.RateLimitedTotal,
+		p.AIRequestsTotal,
+		p.AIRequestDuration,
+		p.AIErrorsTotal,
+		p.AIRetriesTotal,
+		p.AICircuitOpen,
+		p.AITokensTotal,
+		p.AICostDollarsTotal,
+		p.AIQuotaExceededTotal,
 	)
 
 	return p
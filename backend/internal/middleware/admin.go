@@ -0,0 +1,38 @@
+package middlewares
+
+import (
+	"net/http"
+	errs "sentimenta/internal/errors"
+	m "sentimenta/internal/models"
+	"sentimenta/internal/problem"
+	"sentimenta/internal/utils"
+	"slices"
+
+	"github.com/labstack/echo/v4"
+)
+
+// UserGetter возвращает пользователя по id.
+type UserGetter interface {
+	GetUser(id string) (m.User, error)
+}
+
+// NewRoleMiddleware пропускает только пользователей с одной из ролей roles.
+// Должен стоять после NewJWTMiddleware.
+func NewRoleMiddleware(users UserGetter, roles ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userID, err := utils.GetUserID(c)
+			if err != nil {
+				return problem.Write(c, http.StatusUnauthorized, errs.ErrAuthRequired)
+			}
+			user, err := users.GetUser(userID)
+			if err != nil {
+				return problem.Write(c, http.StatusUnauthorized, errs.ErrAuthRequired)
+			}
+			if !slices.Contains(roles, user.Role) {
+				return problem.Write(c, http.StatusForbidden, errs.ErrForbidden)
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package middlewares
+
+import (
+	"net/http"
+	"sentimenta/internal/config"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+const (
+	CSRFCookieName = "csrf_token"
+	CSRFHeaderName = "X-CSRF-Token"
+)
+
+// NewCSRFMiddleware защищает от CSRF запросы, аутентифицированные cookie.
+// Токен выдаётся в cookie csrf_token при любом GET-запросе, а запросы
+// POST, PUT, PATCH и DELETE должны повторять его в заголовке X-CSRF-Token.
+//
+// Проверка пропускается для запросов с заголовком Authorization (браузер
+// не подставляет его сам) и для изменяющих запросов без cookie с токеном
+// доступа: вход, регистрация и OAuth-колбэки ещё не аутентифицированы.
+func NewCSRFMiddleware(cfg *config.Config) echo.MiddlewareFunc {
+	return middleware.CSRFWithConfig(middleware.CSRFConfig{
+		Skipper: func(c echo.Context) bool {
+			if c.Request().Header.Get(echo.HeaderAuthorization) != "" {
+				return true
+			}
+			switch c.Request().Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return false
+			}
+			_, err := c.Cookie(cfg.JWT_COOKIE_NAME)
+			return err != nil
+		},
+		TokenLookup:    "header:" + CSRFHeaderName,
+		CookieName:     CSRFCookieName,
+		CookiePath:     "/",
+		CookieSecure:   cfg.JWT_SECURE,
+		CookieSameSite: cfg.JWT_SAME_SITE,
+		// Клиент читает токен из cookie, чтобы отправить его в заголовке
+		CookieHTTPOnly: false,
+	})
+}
@@ -1,28 +1,71 @@
 package middlewares
 
 import (
+	"errors"
 	"net/http"
 	"sentimenta/internal/config"
+	errs "sentimenta/internal/errors"
+	"sentimenta/internal/i18n"
+	"sentimenta/internal/models"
+	"sentimenta/internal/problem"
 	"sentimenta/internal/security"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
 
-func NewJWTMiddleware(cfg *config.Config, JWT *security.JWT) echo.MiddlewareFunc {
+// SessionChecker проверяет, что пользователь не заблокирован и токен не
+// отозван, и возвращает пользователя.
+type SessionChecker interface {
+	CheckSession(userID string, issuedAt time.Time) (models.User, error)
+}
+
+func NewJWTMiddleware(cfg *config.Config, JWT *security.JWT, sessions SessionChecker) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			cookie, err := c.Cookie(cfg.JWT_COOKIE_NAME)
+			token := tokenFromRequest(c, cfg.JWT_COOKIE_NAME)
+			if token == "" {
+				return problem.Write(c, http.StatusUnauthorized, errs.ErrAuthRequired)
+			}
+
+			claims, err := JWT.ParseJWT(token)
 			if err != nil {
-				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "требуется аутентификация"})
+				if !errors.Is(err, errs.ErrTokenExpired) {
+					err = errs.ErrInvalidToken
+				}
+				return problem.Write(c, http.StatusUnauthorized, err)
 			}
 
-			userID, err := JWT.ParseJWT(cookie.Value, cfg.JWT_SECRET)
+			user, err := sessions.CheckSession(claims.Subject, claims.IssuedAt.Time)
 			if err != nil {
-				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "невалидный токен"})
+				return problem.Write(c, http.StatusUnauthorized, err)
+			}
+			if user.Locale != "" {
+				c.Set(i18n.ContextKey, user.Locale)
 			}
 
-			c.Set("userID", userID)
+			c.Set("userID", claims.Subject)
 			return next(c)
 		}
 	}
 }
+
+// tokenFromRequest берёт токен из заголовка Authorization: Bearer, а если
+// его нет — из cookie. Заголовок используют мобильные клиенты и
+// WebSocket-клиенты вне браузера.
+func tokenFromRequest(c echo.Context, cookieName string) string {
+	if header := c.Request().Header.Get(echo.HeaderAuthorization); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+
+	cookie, err := c.Cookie(cookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
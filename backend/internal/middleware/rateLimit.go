@@ -0,0 +1,141 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	errs "sentimenta/internal/errors"
+	"sentimenta/internal/metrics"
+	"sentimenta/internal/problem"
+	"sentimenta/internal/ratelimit"
+	"sentimenta/internal/utils"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// AccountKeyFunc возвращает идентификатор аккаунта, к которому относится
+// запрос, или пустую строку.
+type AccountKeyFunc func(c echo.Context) string
+
+// NewRateLimitMiddleware применяет policy к запросу по IP (если byIP) и по
+// аккаунту (если account не nil). Ответы 401 считаются неудачными
+// попытками, успешный ответ сбрасывает неудачи аккаунта. Неудачи по IP не
+// сбрасываются, чтобы вход в свой аккаунт не обнулял подбор чужих.
+//
+// Неудачи входа по аккаунту считаются для пары аккаунт + IP: почту в теле
+// запроса может прислать кто угодно, и блокировка по одной почте позволила
+// бы закрыть вход чужому аккаунту. Подбор одного аккаунта с многих IP
+// замедляет policy.PerAccount: она считает неудачи по аккаунту со всех
+// адресов и только увеличивает паузу между попытками.
+//
+// Если хранилище недоступно, запрос пропускается.
+func NewRateLimitMiddleware(
+	limiter *ratelimit.Limiter,
+	policy ratelimit.Policy,
+	byIP bool,
+	account AccountKeyFunc,
+	prometheus *metrics.Prometheus,
+	log *zap.SugaredLogger,
+) echo.MiddlewareFunc {
+	type limitKey struct {
+		policy  ratelimit.Policy
+		key     string
+		account bool
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx := c.Request().Context()
+
+			ip := c.RealIP()
+			var keys []limitKey
+			if byIP {
+				keys = append(keys, limitKey{policy: policy, key: "ip:" + ip})
+			}
+			if account != nil {
+				if id := account(c); id != "" {
+					accountKey := "account:" + id
+					if policy.TracksFailures() {
+						accountKey += "|ip:" + ip
+					}
+					keys = append(keys, limitKey{policy: policy, key: accountKey, account: true})
+					if policy.PerAccount != nil && policy.PerAccount.TracksFailures() {
+						keys = append(keys, limitKey{policy: *policy.PerAccount, key: "account:" + id, account: true})
+					}
+				}
+			}
+
+			for _, k := range keys {
+				retryAfter, err := limiter.Allow(ctx, k.policy, k.key)
+				if err != nil {
+					log.Errorf("не удалось проверить лимит %s: %v", k.policy.Name, err)
+					continue
+				}
+				if retryAfter > 0 {
+					prometheus.RateLimitedTotal.WithLabelValues(k.policy.Name).Inc()
+					c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+					return problem.Write(c, http.StatusTooManyRequests, errs.ErrTooManyRequests)
+				}
+			}
+
+			err := next(c)
+			if !policy.TracksFailures() {
+				return err
+			}
+
+			status := c.Response().Status
+			var httpErr *echo.HTTPError
+			if errors.As(err, &httpErr) {
+				status = httpErr.Code
+			}
+
+			for _, k := range keys {
+				switch {
+				case status == http.StatusUnauthorized:
+					if err := limiter.Fail(ctx, k.policy, k.key); err != nil {
+						log.Errorf("не удалось учесть неудачную попытку %s: %v", k.policy.Name, err)
+					}
+				case status < http.StatusMultipleChoices && k.account:
+					if err := limiter.Succeed(ctx, k.policy, k.key); err != nil {
+						log.Errorf("не удалось сбросить неудачные попытки %s: %v", k.policy.Name, err)
+					}
+				}
+			}
+			return err
+		}
+	}
+}
+
+// LoginEmailKey берёт почту из тела запроса входа и возвращает тело на
+// место, чтобы его мог прочитать обработчик.
+func LoginEmailKey(c echo.Context) string {
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return ""
+	}
+	c.Request().Body = io.NopCloser(bytes.NewReader(body))
+
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(req.Email))
+}
+
+// UserIDKey возвращает пользователя из JWT. Должен стоять после
+// NewJWTMiddleware.
+func UserIDKey(c echo.Context) string {
+	userID, err := utils.GetUserID(c)
+	if err != nil {
+		return ""
+	}
+	return userID
+}
@@ -0,0 +1,34 @@
+package middlewares
+
+import (
+	"net"
+	"sentimenta/internal/config"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// NewIPExtractor определяет IP клиента для c.RealIP(). По умолчанию echo
+// берёт первый адрес из X-Forwarded-For, который клиент может подставить
+// сам и обходить лимиты по IP. Здесь X-Forwarded-For читается справа, и
+// адрес принимается, только если его добавил доверенный прокси из
+// TRUSTED_PROXIES.
+func NewIPExtractor(cfg *config.Config, log *zap.SugaredLogger) echo.IPExtractor {
+	if len(cfg.TRUSTED_PROXIES) == 0 {
+		return echo.ExtractIPFromXFFHeader()
+	}
+
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, cidr := range cfg.TRUSTED_PROXIES {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Fatalf("Неверный адрес в TRUSTED_PROXIES %q: %v", cidr, err)
+		}
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// UserAdmin — пользователь в выдаче админского API.
+type UserAdmin struct {
+	Uid                 int        `json:"uid"`
+	Username            string     `json:"username"`
+	Email               string     `json:"email"`
+	Role                string     `json:"role"`
+	Disabled            bool       `json:"disabled"`
+	UseAI               bool       `json:"use_ai"`
+	AIDisabledByAdmin   bool       `json:"ai_disabled_by_admin"`
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+type AdminRoleReq struct {
+	Role string `json:"role" example:"admin"`
+}
+
+// AdminUseAIReq включает или отключает ИИ для пользователя. false
+// ставит ai_disabled_by_admin, собственная настройка use_ai не меняется.
+type AdminUseAIReq struct {
+	UseAI bool `json:"use_ai"`
+}
+
+type SystemStats struct {
+	Users              int64 `json:"users"`
+	DisabledUsers      int64 `json:"disabled_users"`
+	Admins             int64 `json:"admins"`
+	AIUsers            int64 `json:"ai_users"`
+	ScheduledDeletions int64 `json:"scheduled_deletions"`
+	Moods              int64 `json:"moods"`
+	MoodsLastWeek      int64 `json:"moods_last_week"`
+	Advices            int64 `json:"advices"`
+	AdvicesLastWeek    int64 `json:"advices_last_week"`
+	ActiveUsersWeek    int64 `json:"active_users_week"`
+}
@@ -5,14 +5,86 @@ import (
 )
 
 type Advice struct {
-	Uid    int       `json:"uid" gorm:"primaryKey;autoIncrement;unique"`
-	UserID int       `json:"user_id"`
-	Text   string    `json:"text"`
-	Date   time.Time `json:"date" gorm:"type:date"`
+	Uid             int       `json:"uid" gorm:"primaryKey;autoIncrement;unique"`
+	UserID          int       `json:"user_id" gorm:"uniqueIndex:idx_advices_user_date"`
+	Text            string    `json:"text"`
+	Model           string    `json:"model"`
+	PromptVersion   string    `json:"prompt_version"`
+	Version         int       `json:"version" gorm:"not null;default:1"`
+	Rating          *int16    `json:"rating" gorm:"type:SMALLINT"`
+	FeedbackComment string    `json:"feedback_comment"`
+	Date            time.Time `json:"date" gorm:"type:date;uniqueIndex:idx_advices_user_date"`
+
+	CrisisResources *CrisisResources `json:"crisis_resources,omitempty" gorm:"type:jsonb;serializer:json"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AdviceDelta — часть совета, отправляемая по WebSocket во время
+// генерации (advice.delta). В advice.failed Text пустой.
+type AdviceDelta struct {
+	Date string `json:"date"`
+	Text string `json:"text,omitempty"`
+}
+
+// AdviceVersion — запись о каждой генерации совета. Оценка пользователя
+// копируется в версию, чтобы она не терялась после повторной генерации.
+type AdviceVersion struct {
+	Uid             int       `json:"uid" gorm:"primaryKey;autoIncrement;unique"`
+	AdviceID        int       `json:"advice_id" gorm:"index"`
+	Version         int       `json:"version"`
+	Text            string    `json:"text"`
+	Model           string    `json:"model"`
+	PromptVersion   string    `json:"prompt_version"`
+	Rating          *int16    `json:"rating" gorm:"type:SMALLINT"`
+	FeedbackComment string    `json:"feedback_comment"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+const (
+	RatingThumbsUp   int16 = 1
+	RatingThumbsDown int16 = -1
+)
+
+type AdviceFeedbackReq struct {
+	Rating  int16  `json:"rating" example:"1"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// AdviceFeedback — оценка совета, передаваемая модели в AdviceRequest.
+type AdviceFeedback struct {
+	Advice  string `json:"advice"`
+	Helpful bool   `json:"helpful"`
+	Comment string `json:"comment,omitempty"`
+}
+
+type AdviceFeedbackStats struct {
+	Model         string `json:"model"`
+	PromptVersion string `json:"prompt_version"`
+	Total         int64  `json:"total"`
+	Rated         int64  `json:"rated"`
+	ThumbsUp      int64  `json:"thumbs_up"`
+	ThumbsDown    int64  `json:"thumbs_down"`
+	Comments      int64  `json:"comments"`
+}
+
+// PromptMood — запись настроения в запросе к модели. Дата в формате,
+// привычном для языка совета.
+type PromptMood struct {
+	Score       int16  `json:"score"`
+	Emotions    string `json:"emotions"`
+	Description string `json:"description,omitempty"`
+	Date        string `json:"date"`
 }
 
 type AdviceRequest struct {
-	PreviousAdvice string    `json:"previous_advice"`
-	LastMood       MoodAdd   `json:"last_mood"`
-	Moods          []MoodAdd `json:"moods"`
+	PreviousAdvice string           `json:"previous_advice"`
+	LastMood       PromptMood       `json:"last_mood"`
+	Moods          []PromptMood     `json:"moods"`
+	RecentFeedback []AdviceFeedback `json:"recent_feedback,omitempty"`
+}
+
+type AdviceGenerateReq struct {
+	Date string `json:"date" example:"2025-06-28"`
 }
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// AIUsage — расход модели одним пользователем за сутки (UTC). По этим
+// записям проверяются лимиты AI_QUOTA_DAILY_TOKENS и AI_QUOTA_MONTHLY_TOKENS.
+type AIUsage struct {
+	UserID           int       `json:"user_id" gorm:"primaryKey"`
+	Day              time.Time `json:"day" gorm:"primaryKey;type:date"`
+	Model            string    `json:"model" gorm:"primaryKey"`
+	Requests         int       `json:"requests"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	Cost             float64   `json:"cost"`
+	// ReservedTokens — токены, зарезервированные под запросы, которые ещё
+	// выполняются. Резерв снимается, когда известен настоящий расход
+	ReservedTokens int `json:"reserved_tokens" gorm:"not null;default:0"`
+}
+
+// AIQuota — лимит токенов пользователя за период, начинающийся с Since.
+type AIQuota struct {
+	Period string
+	Since  time.Time
+	Limit  int
+}
+
+// AIUsageTotal — расход пользователя за период.
+type AIUsageTotal struct {
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	Cost             float64 `json:"cost"`
+	ReservedTokens   int     `json:"reserved_tokens"`
+}
+
+// Tokens — израсходованные и зарезервированные токены.
+func (t AIUsageTotal) Tokens() int {
+	return t.PromptTokens + t.CompletionTokens + t.ReservedTokens
+}
@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+const (
+	DeletionScheduled = "scheduled"
+	DeletionCancelled = "cancelled"
+	DeletionCompleted = "completed"
+)
+
+// AccountDeletion — запрос на удаление аккаунта. Запись остаётся после
+// удаления пользователя как квитанция и журнал для администраторов,
+// поэтому в ней нет ни почты, ни имени, ни содержимого записей.
+type AccountDeletion struct {
+	Uid         int        `json:"-" gorm:"primaryKey;autoIncrement;unique"`
+	ReceiptID   string     `json:"receipt_id" gorm:"uniqueIndex"`
+	UserID      int        `json:"user_id" gorm:"index"`
+	Status      string     `json:"status"`
+	RequestedAt time.Time  `json:"requested_at"`
+	ScheduledAt time.Time  `json:"scheduled_at"`
+	CancelledAt *time.Time `json:"cancelled_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+
+	ErasureCounts
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ErasureCounts — сколько записей каждого вида было удалено.
+type ErasureCounts struct {
+	Moods          int64 `json:"moods"`
+	Advices        int64 `json:"advices"`
+	AdviceVersions int64 `json:"advice_versions"`
+	UserKeys       int64 `json:"user_keys"`
+	SecurityEvents int64 `json:"security_events"`
+	Passkeys       int64 `json:"passkeys"`
+}
+
+// AccountDeleteReq подтверждает удаление паролем или кодом, отправленным
+// на почту аккаунта (POST /api/user/deletion/code). Аккаунтам без пароля
+// нужен код.
+type AccountDeleteReq struct {
+	Password string `json:"password,omitempty"`
+	Code     string `json:"code,omitempty"`
+}
+
+// DeletionCode — одноразовый код подтверждения удаления, отправленный на
+// почту. У пользователя не больше одного кода: новый заменяет прежний.
+type DeletionCode struct {
+	Uid       int       `json:"-" gorm:"primaryKey;autoIncrement;unique"`
+	UserID    int       `json:"-" gorm:"uniqueIndex"`
+	CodeHash  string    `json:"-"`
+	Attempts  int       `json:"-" gorm:"not null;default:0"`
+	ExpiresAt time.Time `json:"-"`
+	CreatedAt time.Time `json:"-"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// UserKey — ключ данных пользователя, зашифрованный мастер-ключом.
+type UserKey struct {
+	Uid         int    `json:"uid" gorm:"primaryKey;autoIncrement;unique"`
+	UserID      int    `json:"user_id" gorm:"uniqueIndex:idx_user_key_version"`
+	Version     int    `json:"version" gorm:"uniqueIndex:idx_user_key_version"`
+	MasterKeyID string `json:"master_key_id"`
+	WrappedKey  []byte `json:"-"`
+	// Bound — ключ зашифрован с AAD (пользователь и версия). Ключи без
+	// него перешифровываются командой reencrypt.
+	Bound     bool      `json:"-" gorm:"not null;default:false"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// UserExport — все данные пользователя для выгрузки.
+type UserExport struct {
+	ExportedAt     time.Time       `json:"exported_at"`
+	User           UserGet         `json:"user"`
+	Moods          []Mood          `json:"moods"`
+	Advices        []AdviceExport  `json:"advices"`
+	SecurityEvents []SecurityEvent `json:"security_events"`
+}
+
+type AdviceExport struct {
+	Advice
+	Versions []AdviceVersion `json:"versions"`
+}
@@ -0,0 +1,21 @@
+package models
+
+// JWK — открытый ключ подписи токенов (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty" example:"OKP"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg" example:"EdDSA"`
+	Use string `json:"use" example:"sig"`
+
+	// Ed25519
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
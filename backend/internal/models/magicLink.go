@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// MagicLink — одноразовая ссылка для входа, отправленная на почту. В БД
+// хранятся только хеши токена, кода и привязки к браузеру.
+type MagicLink struct {
+	Uid         int    `gorm:"primaryKey;autoIncrement;unique"`
+	Email       string `gorm:"index"`
+	TokenHash   string `gorm:"uniqueIndex"`
+	CodeHash    string
+	BindingHash string
+	Attempts    int
+	ExpiresAt   time.Time `gorm:"index"`
+	UsedAt      *time.Time
+	CreatedAt   time.Time
+}
+
+type MagicLinkReq struct {
+	Email string `json:"email"`
+}
+
+// MagicLinkVerifyReq подтверждает вход токеном из ссылки или парой
+// почта и код из письма.
+type MagicLinkVerifyReq struct {
+	Token    string `json:"token,omitempty"`
+	Email    string `json:"email,omitempty"`
+	Code     string `json:"code,omitempty"`
+	Timezone string `json:"timezone"`
+}
@@ -11,8 +11,29 @@ type Mood struct {
 	Description string    `json:"description"`
 	UserId      int       `json:"user_id"`
 	Date        time.Time `json:"date" gorm:"type:date"`
+	Version     int       `json:"version" gorm:"not null;default:1"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+
+	// Заполняется, если Description зашифрован на клиенте
+	E2E *E2EMetadata `json:"e2e,omitempty" gorm:"type:jsonb;serializer:json"`
+
+	// Заполняется, если в записи найдены признаки кризиса
+	CrisisResources *CrisisResources `json:"crisis_resources,omitempty" gorm:"-"`
+}
+
+// Алгоритмы, которыми клиент может шифровать описание записи.
+const (
+	E2EAlgAESGCM            = "AES-256-GCM"
+	E2EAlgXChaCha20Poly1305 = "XChaCha20-Poly1305"
+)
+
+// E2EMetadata описывает шифротекст в Description. Ключ есть только у
+// клиента, сервер хранит шифротекст как есть и не может его прочитать.
+type E2EMetadata struct {
+	Alg   string `json:"alg" example:"AES-256-GCM"`
+	KeyID string `json:"key_id"`
+	Nonce string `json:"nonce"`
 }
 
 type MoodAdd struct {
@@ -20,6 +41,8 @@ type MoodAdd struct {
 	Emotions    string    `json:"emotions"`
 	Description string    `json:"description,omitempty"`
 	Date        time.Time `json:"date"`
+
+	E2E *E2EMetadata `json:"e2e,omitempty"`
 }
 
 type MoodUpdate struct {
@@ -27,6 +50,8 @@ type MoodUpdate struct {
 	Score       *int16  `json:"score,omitempty"`
 	Emotions    *string `json:"emotions,omitempty"`
 	Description *string `json:"description,omitempty"`
+
+	E2E *E2EMetadata `json:"e2e,omitempty"`
 }
 
 type MoodDTO struct {
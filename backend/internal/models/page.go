@@ -0,0 +1,8 @@
+package models
+
+type Page[T any] struct {
+	Items []T   `json:"items"`
+	Page  int   `json:"page"`
+	Limit int   `json:"limit"`
+	Total int64 `json:"total"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// Passkey — ключ доступа WebAuthn, зарегистрированный пользователем.
+type Passkey struct {
+	Uid          int    `json:"uid" gorm:"primaryKey;autoIncrement;unique"`
+	UserID       int    `json:"-" gorm:"index"`
+	Name         string `json:"name"`
+	CredentialID []byte `json:"-" gorm:"uniqueIndex;not null"`
+	PublicKey    []byte `json:"-" gorm:"not null"`
+
+	AttestationType string   `json:"-"`
+	AAGUID          []byte   `json:"-"`
+	Transports      []string `json:"transports" gorm:"type:jsonb;serializer:json"`
+
+	// Счётчик подписей аутентификатора. Если он не растёт, ключ мог быть
+	// скопирован, и вход по нему отклоняется
+	SignCount    uint32 `json:"sign_count"`
+	CloneWarning bool   `json:"clone_warning"`
+
+	BackupEligible bool `json:"backup_eligible"`
+	BackupState    bool `json:"backup_state"`
+
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// WebAuthnSession хранит состояние церемонии WebAuthn между её началом и
+// завершением. Используется один раз.
+type WebAuthnSession struct {
+	ID        string    `gorm:"primaryKey"`
+	UserID    *int      `gorm:"index"`
+	Data      []byte    `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"index"`
+	CreatedAt time.Time
+}
+
+// PasskeyOptions — параметры для navigator.credentials.create или get.
+// SessionID нужно передать при завершении церемонии.
+type PasskeyOptions struct {
+	SessionID string `json:"session_id"`
+	Options   any    `json:"options"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// PromptTemplate — версия системного промпта, сохранённая в БД. Версии не
+// изменяются: совет хранит версию, по которой он сгенерирован, и по ней
+// всегда можно найти текст промпта.
+type PromptTemplate struct {
+	Uid       int       `json:"uid" gorm:"primaryKey;autoIncrement;unique"`
+	Version   string    `json:"version" gorm:"uniqueIndex"`
+	Body      string    `json:"body" gorm:"type:text"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PromptInfo — версия промпта и откуда она загружена.
+type PromptInfo struct {
+	Version string `json:"version"`
+	Source  string `json:"source"`
+	Weight  int    `json:"weight,omitempty"`
+	Default bool   `json:"default,omitempty"`
+}
@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// RateLimit — счётчик ограничения частоты запросов или блокировка.
+type RateLimit struct {
+	Key     string    `gorm:"primaryKey"`
+	Count   int       `gorm:"not null"`
+	ResetAt time.Time `gorm:"not null;index"`
+}
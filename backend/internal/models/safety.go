@@ -0,0 +1,13 @@
+package models
+
+type CrisisContact struct {
+	Name  string `json:"name"`
+	Phone string `json:"phone,omitempty"`
+	URL   string `json:"url,omitempty"`
+}
+
+type CrisisResources struct {
+	Locale   string          `json:"locale"`
+	Message  string          `json:"message"`
+	Contacts []CrisisContact `json:"contacts"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// Типы событий журнала безопасности.
+const (
+	SecurityLoginSuccess      = "login.success"
+	SecurityLoginFailure      = "login.failure"
+	SecurityRegister          = "user.register"
+	SecurityOAuthLink         = "oauth.link"
+	SecurityPasswordChange    = "password.change"
+	SecurityEmailChange       = "email.change"
+	SecurityTokenRevoke       = "token.revoke"
+	SecurityDataExport        = "account.export"
+	SecurityDeletionRequest   = "account.deletion_request"
+	SecurityDeletionCancelled = "account.deletion_cancel"
+	SecurityAccountDisable    = "account.disable"
+	SecurityAccountEnable     = "account.enable"
+	SecurityRoleChange        = "account.role_change"
+	SecurityAIDisable         = "account.ai_disable"
+	SecurityAIEnable          = "account.ai_enable"
+	SecurityPasskeyAdd        = "passkey.add"
+	SecurityPasskeyRemove     = "passkey.remove"
+)
+
+// SecurityEvent — запись журнала безопасности. Записи только добавляются
+// и удаляются вместе с аккаунтом. Details не должен содержать паролей,
+// токенов и текста записей.
+type SecurityEvent struct {
+	Uid       int               `json:"uid" gorm:"primaryKey;autoIncrement;unique"`
+	UserID    *int              `json:"user_id,omitempty" gorm:"index"`
+	Type      string            `json:"type" gorm:"index"`
+	IP        string            `json:"ip"`
+	UserAgent string            `json:"user_agent"`
+	Details   map[string]string `json:"details,omitempty" gorm:"type:jsonb;serializer:json"`
+	CreatedAt time.Time         `json:"created_at" gorm:"index"`
+}
+
+// SecurityEventFilter — фильтры журнала для администраторов. Пустые поля
+// не учитываются.
+type SecurityEventFilter struct {
+	UserID *int
+	Type   string
+	IP     string
+	From   *time.Time
+	To     *time.Time
+}
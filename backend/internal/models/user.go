@@ -4,32 +4,65 @@ import (
 	"time"
 )
 
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type User struct {
-	Uid          int       `json:"uid" gorm:"primaryKey;autoIncrement;unique"`
-	Username     string    `json:"username"`
-	Email        string    `json:"email" gorm:"unique"`
-	PasswordHash *string   `json:"password_hash"`
-	Timezone     string    `json:"timezone"`
-	UseAI        bool      `json:"use_ai" gorm:"default:true"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	Moods        []Mood    `json:"moods"`
+	Uid          int     `json:"uid" gorm:"primaryKey;autoIncrement;unique"`
+	Username     string  `json:"username"`
+	Email        string  `json:"email" gorm:"unique"`
+	PasswordHash *string `json:"password_hash"`
+	Timezone     string  `json:"timezone"`
+	// Язык интерфейса, писем и советов. Пустой — определять автоматически
+	Locale string `json:"locale" gorm:"not null;default:''"`
+	UseAI  bool   `json:"use_ai" gorm:"default:true"`
+	// Администратор отключил ИИ. Пользователь не может снять этот флаг
+	// через use_ai
+	AIDisabledByAdmin bool   `json:"ai_disabled_by_admin" gorm:"not null;default:false"`
+	RedactPII         bool   `json:"redact_pii" gorm:"default:true"`
+	E2EEnabled        bool   `json:"e2e_enabled" gorm:"default:false"`
+	Role              string `json:"role" gorm:"not null;default:user"`
+	Disabled          bool   `json:"disabled" gorm:"default:false"`
+	// Токены, выданные раньше этого момента, считаются отозванными
+	TokensRevokedAt *time.Time `json:"-"`
+	// Дата окончательного удаления, если пользователь запросил удаление
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+	Moods               []Mood     `json:"moods"`
+}
+
+// AIAllowed сообщает, что советы для пользователя можно генерировать: он
+// сам включил ИИ и администратор его не отключил.
+func (u User) AIAllowed() bool {
+	return u.UseAI && !u.AIDisabledByAdmin
 }
 
 type UserGet struct {
-	Uid       int       `json:"uid"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	UseAI     bool      `json:"use_ai"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	Uid                 int        `json:"uid"`
+	Username            string     `json:"username"`
+	Email               string     `json:"email"`
+	Locale              string     `json:"locale"`
+	UseAI               bool       `json:"use_ai"`
+	AIDisabledByAdmin   bool       `json:"ai_disabled_by_admin"`
+	RedactPII           bool       `json:"redact_pii"`
+	E2EEnabled          bool       `json:"e2e_enabled"`
+	Role                string     `json:"role"`
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
 }
 
 type UserUpdateReq struct {
-	Username *string `json:"username,omitempty"`
-	Email    *string `json:"email,omitempty"`
-	Timezone *string `json:"timezone"`
-	UseAI    *bool   `json:"use_ai"`
+	Username   *string `json:"username,omitempty"`
+	Email      *string `json:"email,omitempty"`
+	Timezone   *string `json:"timezone"`
+	Locale     *string `json:"locale" example:"en"`
+	UseAI      *bool   `json:"use_ai"`
+	RedactPII  *bool   `json:"redact_pii"`
+	E2EEnabled *bool   `json:"e2e_enabled"`
 }
 
 type UserRegister struct {
@@ -0,0 +1,372 @@
+package privacy
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"sentimenta/internal/config"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+//go:embed names/*.txt
+var embeddedNames embed.FS
+
+type Kind string
+
+const (
+	KindEmail   Kind = "EMAIL"
+	KindCard    Kind = "CARD"
+	KindPhone   Kind = "PHONE"
+	KindAddress Kind = "ADDRESS"
+	KindName    Kind = "NAME"
+)
+
+var (
+	emailRegex = regexp.MustCompile(`[\p{L}0-9._%+\-]+@[\p{L}0-9.\-]+\.\p{L}{2,}`)
+	cardRegex  = regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`)
+	phoneRegex = regexp.MustCompile(`(?:\+?\d{1,3}[\s\-]?)?(?:\(\d{2,4}\)|\d{2,4})(?:[\s\-]?\d{2,4}){2,4}`)
+
+	addressRuRegex = regexp.MustCompile(`(?i:ул\.|улица|пр-т|проспект|пер\.|переулок|б-р|бульвар|ш\.|шоссе|наб\.|набережная|пл\.|площадь)\s*` +
+		`[\p{Lu}0-9][\p{L}0-9\-]*(?:\s+\p{Lu}[\p{L}\-]*)?` +
+		`(?:,?\s*(?i:д\.|дом)\s*\d+[\p{L}/0-9]*)?` +
+		`(?:,?\s*(?i:кв\.|квартира)\s*\d+)?`)
+	addressEnRegex = regexp.MustCompile(`\b\d{1,5}\s+(?:\p{Lu}[\p{L}\-]*\s+){1,3}` +
+		`(?:Street|St\.?|Avenue|Ave\.?|Road|Rd\.?|Boulevard|Blvd\.?|Lane|Ln\.?|Drive|Dr\.?|Court|Ct\.?|Way|Place|Pl\.?)` +
+		`(?:,?\s*(?:Apt\.?|Apartment|Suite|Unit)\s*#?\w+)?`)
+
+	wordRegex = regexp.MustCompile(`\p{Lu}\p{Ll}+`)
+)
+
+// ruNameEndings — падежные окончания, которые могут добавляться к основе
+// русского имени: Маша -> Маши, Машей, Машу; Иван -> Ивана, Иваном.
+var ruNameEndings = []string{"", "а", "я", "у", "ю", "е", "ы", "и", "ой", "ей", "ом", "ем", "ою", "ею"}
+
+// ambiguousNames — имена, совпадающие с обычными словами. В начале
+// предложения заглавная буква ничего не говорит ("Роман был скучным",
+// "Света не было"), поэтому там они не скрываются, если это не имя самого
+// пользователя.
+var ambiguousNames = []string{
+	"Роман", "Света", "Вера", "Надежда", "Любовь", "Лев", "Слава", "Роза", "Лилия",
+	"Grace", "Hope", "Faith", "Mark", "Will", "Joy", "May", "June", "Rose", "Bill", "Frank", "Dawn", "Lily", "Ruby",
+}
+
+// sentenceOpeners — символы, которые могут стоять между концом предыдущего
+// предложения и первым словом следующего.
+const sentenceOpeners = "\"'«“„([—-"
+
+// Redactor заменяет персональные данные заглушками вида [NAME_1] перед
+// отправкой текста во внешний AI и восстанавливает их в ответе.
+type Redactor struct {
+	// names сопоставляет имя или его основу с общей основой
+	names map[string]string
+	// ambiguous — основы имён из ambiguousNames
+	ambiguous map[string]struct{}
+}
+
+// Session хранит соответствие заглушек и исходных значений в рамках одного
+// запроса к модели. Одинаковые значения получают одинаковые заглушки.
+type Session struct {
+	redactor      *Redactor
+	extraNames    map[string]struct{}
+	byValue       map[string]string
+	byPlaceholder map[string]string
+	counters      map[Kind]int
+}
+
+// NewSession начинает новую сессию. extraNames — дополнительные имена,
+// которые нужно скрыть, например имя самого пользователя.
+func (r *Redactor) NewSession(extraNames ...string) *Session {
+	s := &Session{
+		redactor:      r,
+		extraNames:    map[string]struct{}{},
+		byValue:       map[string]string{},
+		byPlaceholder: map[string]string{},
+		counters:      map[Kind]int{},
+	}
+	for _, name := range extraNames {
+		for _, word := range strings.Fields(name) {
+			if len([]rune(word)) >= 3 {
+				s.extraNames[normalizeName(word)] = struct{}{}
+			}
+		}
+	}
+	return s
+}
+
+// Redact заменяет найденные персональные данные заглушками.
+func (s *Session) Redact(text string) string {
+	if text == "" {
+		return text
+	}
+
+	text = emailRegex.ReplaceAllStringFunc(text, s.replacer(KindEmail))
+	text = cardRegex.ReplaceAllStringFunc(text, func(match string) string {
+		if !luhnValid(match) {
+			return match
+		}
+		return s.placeholder(KindCard, match)
+	})
+	text = phoneRegex.ReplaceAllStringFunc(text, func(match string) string {
+		if !plausiblePhone(match) {
+			return match
+		}
+		return s.placeholder(KindPhone, match)
+	})
+	text = addressRuRegex.ReplaceAllStringFunc(text, s.replacer(KindAddress))
+	text = addressEnRegex.ReplaceAllStringFunc(text, s.replacer(KindAddress))
+	return s.redactNames(text)
+}
+
+func (s *Session) redactNames(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range wordRegex.FindAllStringIndex(text, -1) {
+		word := text[loc[0]:loc[1]]
+		stem, ok := s.nameStem(word)
+		if !ok {
+			continue
+		}
+		if _, own := s.extraNames[normalizeName(word)]; !own {
+			if _, ambiguous := s.redactor.ambiguous[stem]; ambiguous && sentenceStart(text[:loc[0]]) {
+				continue
+			}
+		}
+		b.WriteString(text[last:loc[0]])
+		// Разные падежные формы одного имени получают одну заглушку,
+		// чтобы модель видела одного человека
+		b.WriteString(s.placeholderFor(KindName, stem, word))
+		last = loc[1]
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// sentenceStart сообщает, начинается ли с конца prefix новое предложение.
+func sentenceStart(prefix string) bool {
+	prefix = strings.TrimRightFunc(prefix, func(r rune) bool {
+		return r != '\n' && (unicode.IsSpace(r) || strings.ContainsRune(sentenceOpeners, r))
+	})
+	if prefix == "" {
+		return true
+	}
+	r, _ := utf8.DecodeLastRuneInString(prefix)
+	return strings.ContainsRune(".!?…\n", r)
+}
+
+// plausiblePhone отсеивает совпадения, которые похожи на телефон только
+// количеством цифр, например "12 345 678 9012". Без "+" и скобок номер
+// должен быть российским из 11 цифр или 10-значным с кодом из трёх цифр.
+func plausiblePhone(match string) bool {
+	digits := countDigits(match)
+	if digits < 10 || digits > 15 {
+		return false
+	}
+	if strings.ContainsAny(match, "+(") {
+		return true
+	}
+	switch digits {
+	case 11:
+		return match[0] == '7' || match[0] == '8'
+	case 10:
+		groups := strings.FieldsFunc(match, func(r rune) bool { return r == ' ' || r == '-' })
+		return len(groups) == 1 || len(groups[0]) == 3
+	}
+	return false
+}
+
+// Restore возвращает исходные значения на место заглушек. Ответ модели
+// видит только сам автор записи, поэтому восстанавливаются все виды данных.
+func (s *Session) Restore(text string) string {
+	for placeholder, value := range s.byPlaceholder {
+		text = strings.ReplaceAll(text, placeholder, value)
+	}
+	return text
+}
+
+// StreamRestorer восстанавливает заглушки в ответе модели, приходящем по
+// частям. Заглушка может разорваться между частями, поэтому текст от
+// последней незакрытой "[" придерживается до следующей части. Длинный
+// текст после "[" заглушкой быть не может и отдаётся сразу.
+type StreamRestorer struct {
+	session *Session
+	pending string
+}
+
+// maxPlaceholder — предельная длина заглушки вида [ADDRESS_12].
+const maxPlaceholder = 32
+
+func (s *Session) NewStreamRestorer() *StreamRestorer {
+	return &StreamRestorer{session: s}
+}
+
+// Push принимает очередную часть и возвращает текст, который можно
+// показать.
+func (r *StreamRestorer) Push(delta string) string {
+	r.pending += delta
+	cut := len(r.pending)
+	if i := strings.LastIndex(r.pending, "["); i >= 0 && !strings.Contains(r.pending[i:], "]") && len(r.pending)-i < maxPlaceholder {
+		cut = i
+	}
+	out := r.session.Restore(r.pending[:cut])
+	r.pending = r.pending[cut:]
+	return out
+}
+
+// Flush возвращает придержанный остаток в конце ответа.
+func (r *StreamRestorer) Flush() string {
+	out := r.session.Restore(r.pending)
+	r.pending = ""
+	return out
+}
+
+func (s *Session) replacer(kind Kind) func(string) string {
+	return func(match string) string {
+		return s.placeholder(kind, match)
+	}
+}
+
+func (s *Session) placeholder(kind Kind, value string) string {
+	return s.placeholderFor(kind, value, value)
+}
+
+// placeholderFor выдаёт заглушку по ключу key. При восстановлении
+// подставляется первое встреченное значение с этим ключом.
+func (s *Session) placeholderFor(kind Kind, key, value string) string {
+	key = string(kind) + ":" + key
+	if placeholder, ok := s.byValue[key]; ok {
+		return placeholder
+	}
+	s.counters[kind]++
+	placeholder := fmt.Sprintf("[%s_%d]", kind, s.counters[kind])
+	s.byValue[key] = placeholder
+	s.byPlaceholder[placeholder] = value
+	return placeholder
+}
+
+// nameStem возвращает основу имени из словаря, если word похоже на имя.
+func (s *Session) nameStem(word string) (string, bool) {
+	lower := normalizeName(word)
+	if _, ok := s.extraNames[lower]; ok {
+		return lower, true
+	}
+	for _, ending := range ruNameEndings {
+		stem, ok := strings.CutSuffix(lower, ending)
+		if !ok || len([]rune(stem)) < 3 {
+			continue
+		}
+		if canonical, ok := s.redactor.names[stem]; ok {
+			return canonical, true
+		}
+	}
+	return "", false
+}
+
+// addName добавляет имя и, для кириллических имён, его основу без
+// конечной гласной, й или ь.
+func (r *Redactor) addName(name string) {
+	lower := normalizeName(name)
+	stem := stemOf(lower)
+	r.names[lower] = stem
+	r.names[stem] = stem
+}
+
+// stemOf возвращает основу имени в нижнем регистре: для кириллических
+// имён отбрасывается конечная гласная, й или ь.
+func stemOf(lower string) string {
+	runes := []rune(lower)
+	if len(runes) < 4 || !unicode.Is(unicode.Cyrillic, runes[0]) {
+		return lower
+	}
+	if strings.ContainsRune("аяйь", runes[len(runes)-1]) {
+		return string(runes[:len(runes)-1])
+	}
+	return lower
+}
+
+func (r *Redactor) loadNames(data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r.addName(line)
+	}
+	return scanner.Err()
+}
+
+func normalizeName(name string) string {
+	return strings.ReplaceAll(strings.ToLower(name), "ё", "е")
+}
+
+func luhnValid(number string) bool {
+	var sum, digits int
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+		double = !double
+	}
+	return digits >= 13 && sum%10 == 0
+}
+
+func countDigits(s string) int {
+	count := 0
+	for _, r := range s {
+		if unicode.IsDigit(r) {
+			count++
+		}
+	}
+	return count
+}
+
+func NewRedactor(cfg *config.Config, log *zap.SugaredLogger) *Redactor {
+	r := &Redactor{names: map[string]string{}, ambiguous: map[string]struct{}{}}
+	for _, name := range ambiguousNames {
+		r.ambiguous[stemOf(normalizeName(name))] = struct{}{}
+	}
+
+	err := fs.WalkDir(embeddedNames, "names", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(embeddedNames, path)
+		if err != nil {
+			return err
+		}
+		return r.loadNames(data)
+	})
+	if err != nil {
+		log.Fatalf("Не удалось загрузить словарь имён: %v", err)
+	}
+
+	if cfg.PII_NAMES_PATH != "" {
+		data, err := os.ReadFile(cfg.PII_NAMES_PATH)
+		if err != nil {
+			log.Fatalf("Не удалось загрузить словарь имён: %v", err)
+		}
+		if err := r.loadNames(data); err != nil {
+			log.Fatalf("Не удалось загрузить словарь имён: %v", err)
+		}
+	}
+	return r
+}
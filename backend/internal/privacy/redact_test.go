@@ -0,0 +1,143 @@
+package privacy
+
+import (
+	"sentimenta/internal/config"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func newTestRedactor(t *testing.T) *Redactor {
+	t.Helper()
+	return NewRedactor(&config.Config{}, zap.NewNop().Sugar())
+}
+
+func TestRedact(t *testing.T) {
+	r := newTestRedactor(t)
+
+	tests := []struct {
+		name  string
+		extra []string
+		text  string
+		want  string
+	}{
+		{"ru name", nil, "Сегодня гуляли с Машей.", "Сегодня гуляли с [NAME_1]."},
+		{"ru name case forms share placeholder", nil, "Позвонила Маша, потом я написал Маше.", "Позвонила [NAME_1], потом я написал [NAME_1]."},
+		{"ru name genitive", nil, "Был у Кирилла в гостях.", "Был у [NAME_1] в гостях."},
+		{"ru diminutive with ё", nil, "Видел Артёма.", "Видел [NAME_1]."},
+		{"en name", nil, "Had lunch with John and Mary.", "Had lunch with [NAME_1] and [NAME_2]."},
+		{"user own name", []string{"Зарина Петрова"}, "Зарина, держись.", "[NAME_1], держись."},
+		{"ru phone", nil, "Мой номер +7 912 345-67-89.", "Мой номер [PHONE_1]."},
+		{"ru phone with 8", nil, "Звони 8 912 345 67 89", "Звони [PHONE_1]"},
+		{"phone with area code in parens", nil, "call (495) 123-45-67 today", "call [PHONE_1] today"},
+		{"en phone", nil, "Text me at +1 555 123 4567.", "Text me at [PHONE_1]."},
+		{"email", nil, "Пиши на ivan.petrov@mail.ru", "Пиши на [EMAIL_1]"},
+		{"cyrillic email", nil, "адрес почта@пример.рф", "адрес [EMAIL_1]"},
+		{"ru address", nil, "Живу на ул. Ленина, д. 5, кв. 12 уже год.", "Живу на [ADDRESS_1] уже год."},
+		{"en address", nil, "Moved to 221 Baker Street, Apt 2 last week.", "Moved to [ADDRESS_1] last week."},
+		{"luhn card", nil, "Карта 4111 1111 1111 1111 заблокирована.", "Карта [CARD_1] заблокирована."},
+		{"luhn card with dashes", nil, "card 5500-0000-0000-0004", "card [CARD_1]"},
+
+		{"novel at sentence start", nil, "Роман был скучным.", "Роман был скучным."},
+		{"light at sentence start", nil, "Весь вечер сидели дома. Света не было.", "Весь вечер сидели дома. Света не было."},
+		{"ambiguous name after quote", nil, "«Роман» не дочитал.", "«Роман» не дочитал."},
+		{"ambiguous name mid sentence", nil, "Вчера видел Романа.", "Вчера видел [NAME_1]."},
+		{"own ambiguous name at sentence start", []string{"Света"}, "Света, ты молодец.", "[NAME_1], ты молодец."},
+		{"digit run is not a phone", nil, "Номер заказа 12 345 678 9012.", "Номер заказа 12 345 678 9012."},
+		{"non-luhn card", nil, "Карта 4111 1111 1111 1112.", "Карта 4111 1111 1111 1112."},
+		{"short number", nil, "Спал 7 часов, прошёл 12000 шагов.", "Спал 7 часов, прошёл 12000 шагов."},
+		{"ordinary capitalized words", nil, "Сегодня Понедельник. Today was Monday.", "Сегодня Понедельник. Today was Monday."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := r.NewSession(tt.extra...)
+			if got := session.Redact(tt.text); got != tt.want {
+				t.Errorf("Redact(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestoreRoundTrip(t *testing.T) {
+	r := newTestRedactor(t)
+
+	tests := []string{
+		"Поругался с Машей, она не берёт трубку +7 912 345-67-89.",
+		"Mary sent it to john.doe@example.com from 221 Baker Street.",
+		"Оплатил картой 4111 1111 1111 1111, потом написал Кириллу на ivan@mail.ru.",
+		"Ничего личного здесь нет.",
+	}
+
+	for _, text := range tests {
+		session := r.NewSession()
+		redacted := session.Redact(text)
+		if got := session.Restore(redacted); got != text {
+			t.Errorf("Restore(Redact(%q)) = %q", text, got)
+		}
+	}
+}
+
+func TestRestoreUsesFirstSeenForm(t *testing.T) {
+	session := newTestRedactor(t).NewSession()
+	redacted := session.Redact("Маша пришла, и я обнял Машу.")
+	if redacted != "[NAME_1] пришла, и я обнял [NAME_1]." {
+		t.Fatalf("unexpected redaction %q", redacted)
+	}
+	if got := session.Restore("Поговорите с [NAME_1]."); got != "Поговорите с Маша." {
+		t.Errorf("Restore = %q", got)
+	}
+}
+
+func TestStreamRestorer(t *testing.T) {
+	session := newTestRedactor(t).NewSession()
+	session.Redact("Мне звонила Маша с ivan@mail.ru")
+
+	tests := []struct {
+		name   string
+		chunks []string
+		want   string
+	}{
+		{"whole placeholders", []string{"Спросите [NAME_1] ", "про [EMAIL_1]."}, "Спросите Маша про ivan@mail.ru."},
+		{"placeholder split across chunks", []string{"Спросите [NA", "ME_", "1] сегодня."}, "Спросите Маша сегодня."},
+		{"unfinished placeholder at the end", []string{"Текст с [NAME_"}, "Текст с [NAME_"},
+		{"bracket that is not a placeholder", []string{"Оцените [от 1 до 5", "] своё настроение."}, "Оцените [от 1 до 5] своё настроение."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restorer := session.NewStreamRestorer()
+			var out strings.Builder
+			for _, chunk := range tt.chunks {
+				got := restorer.Push(chunk)
+				if strings.Contains(got, "[NAME_1]") || strings.Contains(got, "[EMAIL_1]") {
+					t.Fatalf("Push leaked placeholder: %q", got)
+				}
+				out.WriteString(got)
+			}
+			out.WriteString(restorer.Flush())
+			if out.String() != tt.want {
+				t.Errorf("stream = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestLuhnValid(t *testing.T) {
+	tests := []struct {
+		number string
+		want   bool
+	}{
+		{"4111111111111111", true},
+		{"4111 1111 1111 1111", true},
+		{"4111111111111112", false},
+		{"0000000000", false},
+	}
+
+	for _, tt := range tests {
+		if got := luhnValid(tt.number); got != tt.want {
+			t.Errorf("luhnValid(%q) = %v, want %v", tt.number, got, tt.want)
+		}
+	}
+}
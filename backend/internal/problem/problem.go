@@ -0,0 +1,107 @@
+// Package problem формирует ответы об ошибках в формате RFC 7807
+// (application/problem+json).
+//
+// code — стабильный код из каталога errs, по нему клиенты различают
+// ошибки. title и detail переводятся на язык запроса. Поле error дублирует
+// detail для клиентов, которые читали старый формат {"error": "..."}.
+package problem
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	errs "sentimenta/internal/errors"
+	"sentimenta/internal/i18n"
+	"sentimenta/internal/strength"
+
+	"github.com/labstack/echo/v4"
+)
+
+const ContentType = "application/problem+json"
+
+const typePrefix = "urn:sentimenta:problem:"
+
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code"`
+	Error    string `json:"error"`
+
+	// Нарушенные правила пароля, только для code=password_policy
+	Violations []strength.Violation `json:"violations,omitempty"`
+}
+
+// Lang — язык ответа: из настроек пользователя, если запрос
+// аутентифицирован и язык выбран, иначе из Accept-Language.
+func Lang(c echo.Context) string {
+	if lang, ok := c.Get(i18n.ContextKey).(string); ok && i18n.IsSupported(lang) {
+		return lang
+	}
+	return i18n.FromRequest(c.Request())
+}
+
+// New описывает err. Текст ошибок, которых нет в каталоге, отдаётся как
+// есть для статусов 4xx и скрывается для 5xx.
+func New(c echo.Context, status int, err error) Problem {
+	lang := Lang(c)
+	code, title := errs.StatusCode(status, lang)
+	p := Problem{
+		Title:    title,
+		Status:   status,
+		Instance: c.Request().URL.Path,
+		Code:     code,
+		Detail:   title,
+	}
+
+	var policyErr *strength.PolicyError
+	switch {
+	case errors.As(err, &policyErr):
+		p.Code = "password_policy"
+		p.Detail = policyErr.Message(lang)
+		p.Violations = policyErr.Localize(lang)
+	default:
+		if specific, message, ok := errs.Lookup(err, lang); ok {
+			p.Code, p.Detail = specific, message
+		} else if err != nil && status < http.StatusInternalServerError {
+			p.Detail = err.Error()
+		}
+	}
+
+	p.Type = typePrefix + p.Code
+	p.Error = p.Detail
+	return p
+}
+
+// Write отправляет ответ об ошибке.
+func Write(c echo.Context, status int, err error) error {
+	c.Response().Header().Set(echo.HeaderContentType, ContentType)
+	return c.JSON(status, New(c, status, err))
+}
+
+// HTTPErrorHandler отдаёт в формате RFC 7807 ошибки самого echo:
+// неизвестный маршрут, неподдерживаемый метод, ошибки middleware.
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	status := http.StatusInternalServerError
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		status = httpErr.Code
+		err = fmt.Errorf("%v", httpErr.Message)
+		// Стандартный текст статуса заменяем переведённым заголовком
+		if httpErr.Message == http.StatusText(status) {
+			err = nil
+		}
+	}
+
+	if c.Request().Method == http.MethodHead {
+		c.NoContent(status)
+		return
+	}
+	Write(c, status, err)
+}
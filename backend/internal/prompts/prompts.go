@@ -0,0 +1,311 @@
+// Package prompts хранит версии системного промпта для советов.
+//
+// Промпт — шаблон text/template. Версии собираются из трёх источников:
+// встроенные шаблоны (templates/*.tmpl), файлы <версия>.tmpl из
+// PROMPTS_DIR и таблица prompt_templates (команда prompt import). Имя
+// версии должно быть уникальным во всех источниках: совет хранит только
+// имя, и по нему должен находиться ровно один текст.
+//
+// Версия для пользователя выбирается по AI_PROMPT_EXPERIMENT: каждый
+// пользователь детерминированно попадает в одну из групп по весам, поэтому
+// все его советы генерируются одной версией, пока веса не изменятся. Без
+// эксперимента используется AI_PROMPT_VERSION. Версия и модель
+// записываются в каждый совет.
+package prompts
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sentimenta/internal/config"
+	errs "sentimenta/internal/errors"
+	"sentimenta/internal/models"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+//go:embed templates/*.tmpl
+var embeddedTemplates embed.FS
+
+const (
+	SourceEmbedded = "embedded"
+	SourceFile     = "file"
+	SourceDB       = "db"
+)
+
+// Vars — переменные, доступные в шаблоне.
+type Vars struct {
+	Locale   string
+	Language string
+	UserName string
+	Style    string
+	ScaleMin int
+	ScaleMax int
+}
+
+// Source — промпты, сохранённые в БД.
+type Source interface {
+	GetPrompts() ([]models.PromptTemplate, error)
+}
+
+type prompt struct {
+	tmpl   *template.Template
+	source string
+}
+
+type arm struct {
+	version string
+	weight  int
+}
+
+type Store struct {
+	mu      sync.RWMutex
+	prompts map[string]prompt
+
+	defaultVersion string
+	experiment     []arm
+	dir            string
+	db             Source
+	logger         *zap.SugaredLogger
+}
+
+// Load перечитывает шаблоны из всех источников. Если какой-то шаблон не
+// разбирается, версия повторяется, нет версии из настроек или не удалось
+// прочитать БД, набор не меняется.
+func (s *Store) Load() error {
+	prompts := map[string]prompt{}
+	add := func(version, body, source string) error {
+		if existing, ok := prompts[version]; ok {
+			return fmt.Errorf("%w: %s (%s и %s)", errs.ErrPromptVersionExists, version, existing.source, source)
+		}
+		tmpl, err := template.New(version).Option("missingkey=error").Parse(body)
+		if err != nil {
+			return fmt.Errorf("промпт %s (%s): %w", version, source, err)
+		}
+		prompts[version] = prompt{tmpl: tmpl, source: source}
+		return nil
+	}
+
+	err := fs.WalkDir(embeddedTemplates, "templates", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(embeddedTemplates, path)
+		if err != nil {
+			return err
+		}
+		return add(versionOf(path), string(data), SourceEmbedded)
+	})
+	if err != nil {
+		return err
+	}
+
+	if s.dir != "" {
+		paths, err := filepath.Glob(filepath.Join(s.dir, "*.tmpl"))
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if err := add(versionOf(path), string(data), SourceFile); err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.db != nil {
+		stored, err := s.db.GetPrompts()
+		if err != nil {
+			// При первой загрузке (например, до первой миграции) работаем
+			// со встроенными шаблонами и файлами. При перезагрузке набор без
+			// версий из БД сломал бы советы, которые их используют
+			if s.loaded() {
+				return fmt.Errorf("не удалось загрузить шаблоны из БД: %w", err)
+			}
+			s.logger.Warnf("Промпты: не удалось загрузить шаблоны из БД: %v", err)
+		}
+		for _, p := range stored {
+			if err := add(p.Version, p.Body, SourceDB); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, version := range s.versionsInUse() {
+		if _, ok := prompts[version]; !ok {
+			return fmt.Errorf("%w: %s", errs.ErrPromptUnknown, version)
+		}
+	}
+
+	s.mu.Lock()
+	s.prompts = prompts
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *Store) loaded() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.prompts != nil
+}
+
+// Has сообщает, загружена ли версия из какого-либо источника.
+func (s *Store) Has(version string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.prompts[version]
+	return ok
+}
+
+// RunReload периодически перечитывает шаблоны, пока не отменён ctx, чтобы
+// новые версии из БД и PROMPTS_DIR подхватывались без перезапуска.
+func (s *Store) RunReload(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := s.Load(); err != nil {
+			s.logger.Errorf("Промпты: не удалось перечитать шаблоны: %v", err)
+		}
+	}
+}
+
+// Assign возвращает версию промпта для пользователя.
+func (s *Store) Assign(userID int) string {
+	total := 0
+	for _, a := range s.experiment {
+		total += a.weight
+	}
+	if total == 0 {
+		return s.defaultVersion
+	}
+
+	h := fnv.New32a()
+	fmt.Fprintf(h, "prompt:%d", userID)
+	bucket := int(h.Sum32() % uint32(total))
+	for _, a := range s.experiment {
+		if bucket < a.weight {
+			return a.version
+		}
+		bucket -= a.weight
+	}
+	return s.defaultVersion
+}
+
+// Render подставляет vars в шаблон версии version.
+func (s *Store) Render(version string, vars Vars) (string, error) {
+	s.mu.RLock()
+	p, ok := s.prompts[version]
+	s.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("%w: %s", errs.ErrPromptUnknown, version)
+	}
+
+	var buf bytes.Buffer
+	if err := p.tmpl.Execute(&buf, vars); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// List возвращает загруженные версии и их участие в эксперименте.
+func (s *Store) List() []models.PromptInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	infos := make([]models.PromptInfo, 0, len(s.prompts))
+	for version, p := range s.prompts {
+		info := models.PromptInfo{Version: version, Source: p.source, Default: version == s.defaultVersion}
+		for _, a := range s.experiment {
+			if a.version == version {
+				info.Weight += a.weight
+			}
+		}
+		infos = append(infos, info)
+	}
+	slices.SortFunc(infos, func(a, b models.PromptInfo) int {
+		return strings.Compare(a.Version, b.Version)
+	})
+	return infos
+}
+
+// Validate проверяет, что шаблон разбирается и выполняется с тестовыми
+// переменными.
+func Validate(body string) error {
+	tmpl, err := template.New("prompt").Option("missingkey=error").Parse(body)
+	if err != nil {
+		return err
+	}
+	vars := Vars{Locale: "en", Language: "English", UserName: "Alex", Style: "supportive", ScaleMin: 1, ScaleMax: 5}
+	return tmpl.Execute(&bytes.Buffer{}, vars)
+}
+
+func (s *Store) versionsInUse() []string {
+	versions := []string{s.defaultVersion}
+	for _, a := range s.experiment {
+		versions = append(versions, a.version)
+	}
+	return versions
+}
+
+func versionOf(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), ".tmpl")
+}
+
+// parseExperiment разбирает "v3:90,v4:10".
+func parseExperiment(value string) ([]arm, error) {
+	var arms []arm
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		version, weightStr, ok := strings.Cut(part, ":")
+		weight, err := strconv.Atoi(weightStr)
+		if !ok || err != nil || weight < 0 {
+			return nil, fmt.Errorf("неверная группа эксперимента %q", part)
+		}
+		arms = append(arms, arm{version: strings.TrimSpace(version), weight: weight})
+	}
+	return arms, nil
+}
+
+// NewStore загружает шаблоны промптов. db может быть nil.
+func NewStore(cfg *config.Config, db Source, log *zap.SugaredLogger) *Store {
+	experiment, err := parseExperiment(cfg.AI_PROMPT_EXPERIMENT)
+	if err != nil {
+		log.Fatalf("Не удалось разобрать AI_PROMPT_EXPERIMENT: %v", err)
+	}
+
+	s := &Store{
+		defaultVersion: cfg.AI_PROMPT_VERSION,
+		experiment:     experiment,
+		dir:            cfg.PROMPTS_DIR,
+		db:             db,
+		logger:         log,
+	}
+	if err := s.Load(); err != nil {
+		log.Fatalf("Не удалось загрузить промпты: %v", err)
+	}
+	return s
+}
@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const pruneInterval = time.Minute
+
+type entry struct {
+	count   int
+	resetAt time.Time
+}
+
+// MemoryStore хранит счётчики в памяти процесса. Подходит для одного
+// экземпляра API; при перезапуске счётчики сбрасываются.
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]entry
+	lastPrune time.Time
+}
+
+func (s *MemoryStore) Incr(_ context.Context, key string, window time.Duration) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.prune(now)
+
+	e, ok := s.entries[key]
+	if !ok || !e.resetAt.After(now) {
+		e = entry{resetAt: now.Add(window)}
+	}
+	e.count++
+	s.entries[key] = e
+	return e.count, e.resetAt, nil
+}
+
+func (s *MemoryStore) Lock(_ context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = entry{resetAt: until}
+	return nil
+}
+
+func (s *MemoryStore) LockedUntil(_ context.Context, key string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.entries[key].resetAt, nil
+}
+
+func (s *MemoryStore) Reset(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// prune удаляет истёкшие записи не чаще раза в pruneInterval.
+func (s *MemoryStore) prune(now time.Time) {
+	if now.Sub(s.lastPrune) < pruneInterval {
+		return
+	}
+	s.lastPrune = now
+	for key, e := range s.entries {
+		if !e.resetAt.After(now) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]entry)}
+}
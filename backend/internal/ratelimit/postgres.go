@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"sentimenta/internal/models"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PostgresStore хранит счётчики в таблице rate_limits, общей для всех
+// экземпляров API.
+type PostgresStore struct {
+	db *gorm.DB
+
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+func (s *PostgresStore) Incr(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	now := time.Now()
+	s.prune(ctx, now)
+
+	var result models.RateLimit
+	err := s.db.WithContext(ctx).Raw(`
+		INSERT INTO rate_limits (key, count, reset_at) VALUES (?, 1, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			count = CASE WHEN rate_limits.reset_at <= ? THEN 1 ELSE rate_limits.count + 1 END,
+			reset_at = CASE WHEN rate_limits.reset_at <= ? THEN EXCLUDED.reset_at ELSE rate_limits.reset_at END
+		RETURNING key, count, reset_at`,
+		key, now.Add(window), now, now,
+	).Scan(&result).Error
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return result.Count, result.ResetAt, nil
+}
+
+func (s *PostgresStore) Lock(ctx context.Context, key string, until time.Time) error {
+	return s.db.WithContext(ctx).Exec(`
+		INSERT INTO rate_limits (key, count, reset_at) VALUES (?, 0, ?)
+		ON CONFLICT (key) DO UPDATE SET count = 0, reset_at = EXCLUDED.reset_at`,
+		key, until,
+	).Error
+}
+
+func (s *PostgresStore) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	var result models.RateLimit
+	err := s.db.WithContext(ctx).First(&result, "key = ?", key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return time.Time{}, nil
+	}
+	return result.ResetAt, err
+}
+
+func (s *PostgresStore) Reset(ctx context.Context, key string) error {
+	return s.db.WithContext(ctx).Delete(&models.RateLimit{}, "key = ?", key).Error
+}
+
+// prune удаляет истёкшие записи не чаще раза в pruneInterval.
+func (s *PostgresStore) prune(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.lastPrune) < pruneInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastPrune = now
+	s.mu.Unlock()
+
+	// Ошибка очистки не мешает подсчёту, записи удалятся в следующий раз
+	_ = s.db.WithContext(ctx).Delete(&models.RateLimit{}, "reset_at < ?", now).Error
+}
+
+func NewPostgresStore(db *gorm.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
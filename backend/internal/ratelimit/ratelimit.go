@@ -0,0 +1,192 @@
+// Package ratelimit ограничивает частоту запросов и защищает от подбора
+// паролей. Счётчики хранятся в Store: в памяти процесса или в Postgres,
+// если запущено несколько экземпляров API.
+package ratelimit
+
+import (
+	"context"
+	"sentimenta/internal/config"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	StoreMemory   = "memory"
+	StorePostgres = "postgres"
+)
+
+// Store хранит счётчики с окном сброса. Блокировки хранятся там же как
+// записи, у которых окно заканчивается вместе с блокировкой.
+type Store interface {
+	// Incr увеличивает счётчик key и возвращает его значение и время
+	// сброса. Если окно истекло, счётчик начинается заново.
+	Incr(ctx context.Context, key string, window time.Duration) (int, time.Time, error)
+	// Lock блокирует key до until.
+	Lock(ctx context.Context, key string, until time.Time) error
+	// LockedUntil возвращает время окончания блокировки или нулевое время.
+	LockedUntil(ctx context.Context, key string) (time.Time, error)
+	// Reset удаляет счётчик key.
+	Reset(ctx context.Context, key string) error
+}
+
+// Policy описывает ограничения для группы маршрутов.
+type Policy struct {
+	Name string
+
+	// Не больше Limit запросов за Window с одного ключа. 0 — без ограничения.
+	Limit  int
+	Window time.Duration
+
+	// Неудачные попытки считаются за FailureWindow. Начиная с DelayAfter
+	// неудач перед следующей попыткой нужно подождать BaseDelay, и пауза
+	// удваивается с каждой неудачей, но не больше MaxDelay. После
+	// LockoutAfter неудач ключ блокируется на LockoutDuration. Нули
+	// отключают соответствующую защиту.
+	FailureWindow   time.Duration
+	DelayAfter      int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	LockoutAfter    int
+	LockoutDuration time.Duration
+
+	// PerAccount считает неудачи по аккаунту без учёта IP, чтобы подбор
+	// с разных адресов тоже замедлялся. Блокировки в ней быть не должно:
+	// иначе любой мог бы закрыть вход чужому аккаунту.
+	PerAccount *Policy
+}
+
+// TracksFailures сообщает, учитывает ли политика неудачные попытки.
+func (p Policy) TracksFailures() bool {
+	return p.DelayAfter > 0 || p.LockoutAfter > 0
+}
+
+// Policies — политики для маршрутов API.
+type Policies struct {
+	Auth   Policy
+	AI     Policy
+	Export Policy
+}
+
+func NewPolicies(cfg *config.Config) Policies {
+	return Policies{
+		Auth: Policy{
+			Name:            "auth",
+			Limit:           cfg.RATE_LIMIT_AUTH_PER_MINUTE,
+			Window:          time.Minute,
+			FailureWindow:   time.Duration(cfg.RATE_LIMIT_AUTH_LOCKOUT_MINUTES) * time.Minute,
+			DelayAfter:      cfg.RATE_LIMIT_AUTH_DELAY_AFTER,
+			BaseDelay:       time.Second,
+			LockoutAfter:    cfg.RATE_LIMIT_AUTH_LOCKOUT_AFTER,
+			LockoutDuration: time.Duration(cfg.RATE_LIMIT_AUTH_LOCKOUT_MINUTES) * time.Minute,
+			PerAccount: &Policy{
+				Name:          "auth_account",
+				FailureWindow: time.Duration(cfg.RATE_LIMIT_AUTH_LOCKOUT_MINUTES) * time.Minute,
+				DelayAfter:    cfg.RATE_LIMIT_ACCOUNT_DELAY_AFTER,
+				BaseDelay:     time.Second,
+				MaxDelay:      time.Duration(cfg.RATE_LIMIT_ACCOUNT_MAX_DELAY_SECONDS) * time.Second,
+			},
+		},
+		AI: Policy{
+			Name:   "ai",
+			Limit:  cfg.ADVICE_GENERATE_LIMIT_PER_HOUR,
+			Window: time.Hour,
+		},
+		Export: Policy{
+			Name:   "export",
+			Limit:  cfg.RATE_LIMIT_EXPORT_PER_DAY,
+			Window: 24 * time.Hour,
+		},
+	}
+}
+
+type Limiter struct {
+	store Store
+}
+
+// Allow проверяет блокировку и лимит запросов для key. Если запрос нужно
+// отклонить, возвращает время, через которое можно повторить попытку.
+func (l *Limiter) Allow(ctx context.Context, p Policy, key string) (time.Duration, error) {
+	now := time.Now()
+
+	until, err := l.store.LockedUntil(ctx, lockKey(p, key))
+	if err != nil {
+		return 0, err
+	}
+	if until.After(now) {
+		return until.Sub(now), nil
+	}
+
+	if p.Limit <= 0 {
+		return 0, nil
+	}
+	count, resetAt, err := l.store.Incr(ctx, "req:"+p.Name+":"+key, p.Window)
+	if err != nil {
+		return 0, err
+	}
+	if count > p.Limit {
+		return resetAt.Sub(now), nil
+	}
+	return 0, nil
+}
+
+// Fail учитывает неудачную попытку и при необходимости блокирует key.
+func (l *Limiter) Fail(ctx context.Context, p Policy, key string) error {
+	if !p.TracksFailures() {
+		return nil
+	}
+
+	failures, _, err := l.store.Incr(ctx, failKey(p, key), p.FailureWindow)
+	if err != nil {
+		return err
+	}
+
+	var delay time.Duration
+	switch {
+	case p.LockoutAfter > 0 && failures >= p.LockoutAfter:
+		delay = p.LockoutDuration
+	case p.DelayAfter > 0 && failures >= p.DelayAfter:
+		delay = p.BaseDelay << min(failures-p.DelayAfter, 30)
+		if p.MaxDelay > 0 && delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+		if p.LockoutDuration > 0 && delay > p.LockoutDuration {
+			delay = p.LockoutDuration
+		}
+	default:
+		return nil
+	}
+	return l.store.Lock(ctx, lockKey(p, key), time.Now().Add(delay))
+}
+
+// Succeed сбрасывает неудачные попытки key после успешного входа.
+func (l *Limiter) Succeed(ctx context.Context, p Policy, key string) error {
+	if !p.TracksFailures() {
+		return nil
+	}
+	if err := l.store.Reset(ctx, failKey(p, key)); err != nil {
+		return err
+	}
+	return l.store.Reset(ctx, lockKey(p, key))
+}
+
+func failKey(p Policy, key string) string {
+	return "fail:" + p.Name + ":" + key
+}
+
+func lockKey(p Policy, key string) string {
+	return "lock:" + p.Name + ":" + key
+}
+
+func NewLimiter(cfg *config.Config, db *gorm.DB, log *zap.SugaredLogger) *Limiter {
+	switch cfg.RATE_LIMIT_STORE {
+	case StorePostgres:
+		return &Limiter{store: NewPostgresStore(db)}
+	case StoreMemory, "":
+		return &Limiter{store: NewMemoryStore()}
+	default:
+		log.Fatalf("Неизвестное хранилище RATE_LIMIT_STORE: %s", cfg.RATE_LIMIT_STORE)
+		return nil
+	}
+}
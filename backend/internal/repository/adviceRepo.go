@@ -1,33 +1,152 @@
 package repository
 
 import (
+	"sentimenta/internal/encryption"
 	m "sentimenta/internal/models"
+	"strconv"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type adviceRepository struct {
-	db *gorm.DB
+	db     *gorm.DB
+	cipher encryption.Cipher
 }
 
+// CreateAdvice сохраняет совет. Шифртекст связан с uid записи, поэтому
+// текст записывается вторым запросом после вставки.
 func (r *adviceRepository) CreateAdvice(advice *m.Advice) error {
-	return r.db.Create(&advice).Error
+	stored := *advice
+	stored.Text = ""
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&stored).Error; err != nil {
+			return err
+		}
+		text, err := r.cipher.Encrypt(stored.UserID, adviceField(stored.Uid), advice.Text)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&m.Advice{}).Where("uid = ?", stored.Uid).UpdateColumn("text", text).Error
+	})
+	if err != nil {
+		return err
+	}
+	stored.Text = advice.Text
+	*advice = stored
+	return nil
 }
 
 func (r *adviceRepository) GetAdvices(userID string) ([]m.Advice, error) {
 	var advices []m.Advice
-	err := r.db.Find(&advices, "user_id = ?", userID).Error
-	return advices, err
+	if err := r.db.Find(&advices, "user_id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+	for i := range advices {
+		if err := r.decrypt(&advices[i]); err != nil {
+			return nil, err
+		}
+	}
+	return advices, nil
 }
 
 func (r *adviceRepository) GetAdvice(userID string, date time.Time) (m.Advice, error) {
 	var advice m.Advice
 	err := r.db.
-		Where("DATE(date) = ?", date.Format("2006-01-02")).
+		Where("user_id = ? AND DATE(date) = ?", userID, date.Format("2006-01-02")).
 		First(&advice).
 		Error
-	return advice, err
+	if err != nil {
+		return m.Advice{}, err
+	}
+	return advice, r.decrypt(&advice)
+}
+
+func (r *adviceRepository) GetAdviceByID(userID, id string) (m.Advice, error) {
+	var advice m.Advice
+	if err := r.db.First(&advice, "uid = ? AND user_id = ?", id, userID).Error; err != nil {
+		return m.Advice{}, err
+	}
+	return advice, r.decrypt(&advice)
+}
+
+// SaveAdvice сохраняет совет как текущий для своей даты. Если совет на эту
+// дату уже есть, он перезаписывается с увеличением версии. Каждая версия
+// также сохраняется в истории. Текст шифруется внутри транзакции, когда
+// известны uid строк, с которыми связан шифртекст.
+func (r *adviceRepository) SaveAdvice(advice *m.Advice) error {
+	plaintext := advice.Text
+	defer func() { advice.Text = plaintext }()
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		// Строка на дату создаётся или блокируется одним запросом, поэтому
+		// параллельная генерация на ту же дату ждёт конца этой транзакции
+		// и получает следующую версию, а не второй совет
+		now := time.Now()
+		var current m.Advice
+		err := tx.Raw(`
+			INSERT INTO advices (user_id, date, text, version, created_at, updated_at) VALUES (?, ?, '', 0, ?, ?)
+			ON CONFLICT (user_id, date) DO UPDATE SET updated_at = EXCLUDED.updated_at
+			RETURNING uid, version, created_at`,
+			advice.UserID, advice.Date.Format("2006-01-02"), now, now,
+		).Scan(&current).Error
+		if err != nil {
+			return err
+		}
+
+		advice.Uid = current.Uid
+		advice.Version = current.Version + 1
+		advice.CreatedAt = current.CreatedAt
+		if err := r.encrypt(advice); err != nil {
+			return err
+		}
+		// Оценка относится к прошлому тексту и остаётся только в его версии
+		advice.Rating = nil
+		advice.FeedbackComment = ""
+		if err := tx.Model(&m.Advice{Uid: advice.Uid}).
+			Select("text", "model", "prompt_version", "version", "crisis_resources", "rating", "feedback_comment").
+			Updates(advice).
+			Error; err != nil {
+			return err
+		}
+
+		version := m.AdviceVersion{
+			AdviceID:      advice.Uid,
+			Version:       advice.Version,
+			Model:         advice.Model,
+			PromptVersion: advice.PromptVersion,
+		}
+		if err := tx.Create(&version).Error; err != nil {
+			return err
+		}
+		text, err := r.cipher.Encrypt(advice.UserID, versionField(version.Uid), plaintext)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&m.AdviceVersion{}).Where("uid = ?", version.Uid).UpdateColumn("text", text).Error
+	})
+}
+
+func (r *adviceRepository) GetAdviceVersions(userID, adviceID, page, limit int) ([]m.AdviceVersion, int64, error) {
+	var versions []m.AdviceVersion
+	var total int64
+
+	query := r.db.Model(&m.AdviceVersion{}).Where("advice_id = ?", adviceID)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := query.
+		Order("version DESC").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Find(&versions).
+		Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return versions, total, r.decryptVersions(userID, versions)
 }
 
 func (r *adviceRepository) GetLastAdvice(userID string) (m.Advice, error) {
@@ -37,10 +156,157 @@ func (r *adviceRepository) GetLastAdvice(userID string) (m.Advice, error) {
 		Order("date DESC").
 		First(&advice).
 		Error
+	if err != nil {
+		return m.Advice{}, err
+	}
+	return advice, r.decrypt(&advice)
+}
+
+// RateAdvice сохраняет оценку текущей версии совета и возвращает прежнюю
+// оценку. Строка совета блокируется, чтобы параллельные оценки видели
+// результат друг друга.
+func (r *adviceRepository) RateAdvice(advice *m.Advice) (*int16, error) {
+	updates := map[string]any{
+		"rating":           advice.Rating,
+		"feedback_comment": advice.FeedbackComment,
+	}
+	var previous m.Advice
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("rating").
+			First(&previous, "uid = ?", advice.Uid).
+			Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&m.Advice{}).Where("uid = ?", advice.Uid).Updates(updates).Error; err != nil {
+			return err
+		}
+		return tx.Model(&m.AdviceVersion{}).
+			Where("advice_id = ? AND version = ?", advice.Uid, advice.Version).
+			Updates(updates).
+			Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return previous.Rating, nil
+}
+
+func (r *adviceRepository) GetRecentFeedback(userID string, limit int) ([]m.AdviceVersion, error) {
+	var versions []m.AdviceVersion
+	err := r.db.
+		Joins("JOIN advices ON advices.uid = advice_versions.advice_id").
+		Where("advices.user_id = ? AND advice_versions.rating IS NOT NULL", userID).
+		Order("advice_versions.created_at DESC").
+		Limit(limit).
+		Find(&versions).
+		Error
+	if err != nil {
+		return nil, err
+	}
+	uidInt, err := strconv.Atoi(userID)
+	if err != nil {
+		return nil, err
+	}
+	return versions, r.decryptVersions(uidInt, versions)
+}
+
+// GetFeedbackStats считает оценки по версиям советов. Советы, созданные до
+// появления истории версий, учитываются по самой записи совета.
+func (r *adviceRepository) GetFeedbackStats() ([]m.AdviceFeedbackStats, error) {
+	var stats []m.AdviceFeedbackStats
+	err := r.db.Raw(`
+		SELECT model, prompt_version,
+			COUNT(*) AS total,
+			COUNT(rating) AS rated,
+			COUNT(*) FILTER (WHERE rating > 0) AS thumbs_up,
+			COUNT(*) FILTER (WHERE rating < 0) AS thumbs_down,
+			COUNT(*) FILTER (WHERE feedback_comment <> '') AS comments
+		FROM (
+			SELECT model, prompt_version, rating, feedback_comment FROM advice_versions
+			UNION ALL
+			SELECT a.model, a.prompt_version, a.rating, a.feedback_comment FROM advices a
+			WHERE NOT EXISTS (SELECT 1 FROM advice_versions v WHERE v.advice_id = a.uid)
+		) AS feedback
+		GROUP BY model, prompt_version
+		ORDER BY model, prompt_version`).
+		Scan(&stats).
+		Error
+	return stats, err
+}
+
+// ReencryptAdvices перешифровывает советы пользователя и их историю
+// активным ключом пользователя.
+func (r *adviceRepository) ReencryptAdvices(userID int) (int, error) {
+	var advices []m.Advice
+	if err := r.db.Find(&advices, "user_id = ?", userID).Error; err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, advice := range advices {
+		if err := r.reencryptColumn(&m.Advice{}, adviceField(advice.Uid), userID, advice.Text); err != nil {
+			return count, err
+		}
+		count++
+
+		var versions []m.AdviceVersion
+		if err := r.db.Find(&versions, "advice_id = ?", advice.Uid).Error; err != nil {
+			return count, err
+		}
+		for _, version := range versions {
+			if err := r.reencryptColumn(&m.AdviceVersion{}, versionField(version.Uid), userID, version.Text); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *adviceRepository) reencryptColumn(model any, field encryption.Field, userID int, value string) error {
+	plaintext, err := r.cipher.Decrypt(userID, field, value)
+	if err != nil {
+		return err
+	}
+	encrypted, err := r.cipher.Encrypt(userID, field, plaintext)
+	if err != nil {
+		return err
+	}
+	return r.db.Model(model).Where("uid = ?", field.RowID).UpdateColumn(field.Column, encrypted).Error
+}
+
+func (r *adviceRepository) encrypt(advice *m.Advice) error {
+	var err error
+	advice.Text, err = r.cipher.Encrypt(advice.UserID, adviceField(advice.Uid), advice.Text)
+	return err
+}
+
+func (r *adviceRepository) decrypt(advice *m.Advice) error {
+	var err error
+	advice.Text, err = r.cipher.Decrypt(advice.UserID, adviceField(advice.Uid), advice.Text)
+	return err
+}
+
+func (r *adviceRepository) decryptVersions(userID int, versions []m.AdviceVersion) error {
+	for i := range versions {
+		text, err := r.cipher.Decrypt(userID, versionField(versions[i].Uid), versions[i].Text)
+		if err != nil {
+			return err
+		}
+		versions[i].Text = text
+	}
+	return nil
+}
+
+func adviceField(uid int) encryption.Field {
+	return encryption.Field{Table: "advices", Column: "text", RowID: uid}
+}
 
-	return advice, err
+func versionField(uid int) encryption.Field {
+	return encryption.Field{Table: "advice_versions", Column: "text", RowID: uid}
 }
 
-func NewAdviceRepository(db *gorm.DB) AdviceRepository {
-	return &adviceRepository{db: db}
+func NewAdviceRepository(db *gorm.DB, cipher encryption.Cipher) AdviceRepository {
+	return &adviceRepository{db: db, cipher: cipher}
 }
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	m "sentimenta/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type aiUsageRepository struct {
+	db *gorm.DB
+}
+
+// aiUsageLockClass — первый ключ pg_advisory_xact_lock для резервирования
+// токенов, второй — id пользователя.
+const aiUsageLockClass = 4902
+
+// AddUsage прибавляет расход к записи пользователя за день usage.Day.
+func (r *aiUsageRepository) AddUsage(usage *m.AIUsage) error {
+	return addUsage(r.db, usage)
+}
+
+func addUsage(db *gorm.DB, usage *m.AIUsage) error {
+	return db.Exec(`
+		INSERT INTO ai_usages (user_id, day, model, requests, prompt_tokens, completion_tokens, cost)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id, day, model) DO UPDATE SET
+			requests = ai_usages.requests + EXCLUDED.requests,
+			prompt_tokens = ai_usages.prompt_tokens + EXCLUDED.prompt_tokens,
+			completion_tokens = ai_usages.completion_tokens + EXCLUDED.completion_tokens,
+			cost = ai_usages.cost + EXCLUDED.cost`,
+		usage.UserID, usage.Day, usage.Model, usage.Requests, usage.PromptTokens, usage.CompletionTokens, usage.Cost,
+	).Error
+}
+
+// GetUsageSince суммирует расход пользователя по всем моделям с дня since.
+func (r *aiUsageRepository) GetUsageSince(userID int, since time.Time) (m.AIUsageTotal, error) {
+	return usageSince(r.db, userID, since)
+}
+
+func usageSince(db *gorm.DB, userID int, since time.Time) (m.AIUsageTotal, error) {
+	var total m.AIUsageTotal
+	err := db.Model(&m.AIUsage{}).
+		Select(`COALESCE(SUM(requests), 0) AS requests,
+			COALESCE(SUM(prompt_tokens), 0) AS prompt_tokens,
+			COALESCE(SUM(completion_tokens), 0) AS completion_tokens,
+			COALESCE(SUM(cost), 0) AS cost,
+			COALESCE(SUM(reserved_tokens), 0) AS reserved_tokens`).
+		Where("user_id = ? AND day >= ?", userID, since.Format("2006-01-02")).
+		Scan(&total).
+		Error
+	return total, err
+}
+
+// ReserveUsage резервирует reservation.ReservedTokens, если с ними
+// пользователь укладывается во все лимиты, иначе возвращает первый
+// превышенный. Проверка и резерв выполняются под блокировкой пользователя,
+// поэтому параллельные запросы не могут вместе превысить лимит.
+func (r *aiUsageRepository) ReserveUsage(reservation *m.AIUsage, quotas []m.AIQuota) (*m.AIQuota, error) {
+	var exceeded *m.AIQuota
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?, ?)", aiUsageLockClass, reservation.UserID).Error; err != nil {
+			return err
+		}
+		for i, quota := range quotas {
+			total, err := usageSince(tx, reservation.UserID, quota.Since)
+			if err != nil {
+				return err
+			}
+			if total.Tokens()+reservation.ReservedTokens > quota.Limit {
+				exceeded = &quotas[i]
+				return nil
+			}
+		}
+		return tx.Exec(`
+			INSERT INTO ai_usages (user_id, day, model, requests, prompt_tokens, completion_tokens, cost, reserved_tokens)
+			VALUES (?, ?, ?, 0, 0, 0, 0, ?)
+			ON CONFLICT (user_id, day, model) DO UPDATE SET
+				reserved_tokens = ai_usages.reserved_tokens + EXCLUDED.reserved_tokens`,
+			reservation.UserID, reservation.Day, reservation.Model, reservation.ReservedTokens,
+		).Error
+	})
+	return exceeded, err
+}
+
+// SettleUsage снимает резерв и записывает настоящий расход.
+func (r *aiUsageRepository) SettleUsage(reservation, usage *m.AIUsage) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`
+			UPDATE ai_usages SET reserved_tokens = GREATEST(reserved_tokens - ?, 0)
+			WHERE user_id = ? AND day = ? AND model = ?`,
+			reservation.ReservedTokens, reservation.UserID, reservation.Day, reservation.Model,
+		).Error
+		if err != nil {
+			return err
+		}
+		return addUsage(tx, usage)
+	})
+}
+
+func NewAIUsageRepository(db *gorm.DB) AIUsageRepository {
+	return &aiUsageRepository{db: db}
+}
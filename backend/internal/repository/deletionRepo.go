@@ -0,0 +1,190 @@
+package repository
+
+import (
+	m "sentimenta/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type deletionRepository struct {
+	db *gorm.DB
+}
+
+// CreateDeletion сохраняет запрос на удаление и отмечает дату удаления
+// у пользователя.
+func (r *deletionRepository) CreateDeletion(d *m.AccountDeletion) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(d).Error; err != nil {
+			return err
+		}
+		return tx.Model(&m.User{}).
+			Where("uid = ?", d.UserID).
+			Update("deletion_scheduled_at", d.ScheduledAt).
+			Error
+	})
+}
+
+func (r *deletionRepository) GetScheduledDeletion(userID int) (m.AccountDeletion, error) {
+	var d m.AccountDeletion
+	err := r.db.First(&d, "user_id = ? AND status = ?", userID, m.DeletionScheduled).Error
+	return d, err
+}
+
+func (r *deletionRepository) GetDeletionByReceipt(receiptID string) (m.AccountDeletion, error) {
+	var d m.AccountDeletion
+	err := r.db.First(&d, "receipt_id = ?", receiptID).Error
+	return d, err
+}
+
+// CancelDeletion отменяет запрос и снимает дату удаления у пользователя.
+func (r *deletionRepository) CancelDeletion(d *m.AccountDeletion) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(d).
+			Select("status", "cancelled_at").
+			Updates(d).
+			Error; err != nil {
+			return err
+		}
+		return tx.Model(&m.User{}).
+			Where("uid = ?", d.UserID).
+			Update("deletion_scheduled_at", nil).
+			Error
+	})
+}
+
+func (r *deletionRepository) GetDueDeletions(now time.Time) ([]m.AccountDeletion, error) {
+	var deletions []m.AccountDeletion
+	err := r.db.
+		Where("status = ? AND scheduled_at <= ?", m.DeletionScheduled, now).
+		Order("scheduled_at").
+		Find(&deletions).
+		Error
+	return deletions, err
+}
+
+// CompleteDeletion удаляет пользователя со всеми данными и закрывает
+// запрос в одной транзакции.
+func (r *deletionRepository) CompleteDeletion(d *m.AccountDeletion) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		counts, err := deleteUserData(tx, d.UserID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		d.Status = m.DeletionCompleted
+		d.CompletedAt = &now
+		d.ErasureCounts = counts
+		return tx.Save(d).Error
+	})
+}
+
+func (r *deletionRepository) GetDeletions(page, limit int) ([]m.AccountDeletion, int64, error) {
+	var deletions []m.AccountDeletion
+	var total int64
+
+	query := r.db.Model(&m.AccountDeletion{})
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := query.
+		Order("requested_at DESC").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Find(&deletions).
+		Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return deletions, total, nil
+}
+
+// SaveDeletionCode сохраняет код подтверждения, заменяя прежний код
+// пользователя.
+func (r *deletionRepository) SaveDeletionCode(c *m.DeletionCode) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", c.UserID).Delete(&m.DeletionCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(c).Error
+	})
+}
+
+func (r *deletionRepository) GetDeletionCode(userID int, now time.Time) (m.DeletionCode, error) {
+	var c m.DeletionCode
+	err := r.db.First(&c, "user_id = ? AND expires_at > ?", userID, now).Error
+	return c, err
+}
+
+func (r *deletionRepository) IncrementDeletionCodeAttempts(id int) error {
+	return r.db.Model(&m.DeletionCode{}).
+		Where("uid = ?", id).
+		UpdateColumn("attempts", gorm.Expr("attempts + 1")).
+		Error
+}
+
+// UseDeletionCode удаляет использованный код. Если его уже использовал
+// параллельный запрос, возвращает gorm.ErrRecordNotFound.
+func (r *deletionRepository) UseDeletionCode(id int) error {
+	result := r.db.Delete(&m.DeletionCode{}, "uid = ?", id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// deleteUserData безвозвратно удаляет пользователя и всё, что с ним
+// связано. Вызывается внутри транзакции.
+func deleteUserData(tx *gorm.DB, userID int) (m.ErasureCounts, error) {
+	var counts m.ErasureCounts
+
+	adviceIDs := tx.Model(&m.Advice{}).Select("uid").Where("user_id = ?", userID)
+	result := tx.Where("advice_id IN (?)", adviceIDs).Delete(&m.AdviceVersion{})
+	if result.Error != nil {
+		return counts, result.Error
+	}
+	counts.AdviceVersions = result.RowsAffected
+
+	steps := []struct {
+		model any
+		count *int64
+	}{
+		{&m.Advice{}, &counts.Advices},
+		{&m.Mood{}, &counts.Moods},
+		{&m.UserKey{}, &counts.UserKeys},
+		{&m.SecurityEvent{}, &counts.SecurityEvents},
+		{&m.Passkey{}, &counts.Passkeys},
+	}
+	for _, step := range steps {
+		result := tx.Where("user_id = ?", userID).Delete(step.model)
+		if result.Error != nil {
+			return counts, result.Error
+		}
+		*step.count = result.RowsAffected
+	}
+
+	if err := tx.Where("user_id = ?", userID).Delete(&m.WebAuthnSession{}).Error; err != nil {
+		return counts, err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&m.AIUsage{}).Error; err != nil {
+		return counts, err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&m.DeletionCode{}).Error; err != nil {
+		return counts, err
+	}
+	// Ссылки для входа привязаны к почте, а не к пользователю
+	email := tx.Model(&m.User{}).Select("email").Where("uid = ?", userID)
+	if err := tx.Where("email IN (?)", email).Delete(&m.MagicLink{}).Error; err != nil {
+		return counts, err
+	}
+	return counts, tx.Delete(&m.User{}, "uid = ?", userID).Error
+}
+
+func NewDeletionRepository(db *gorm.DB) DeletionRepository {
+	return &deletionRepository{db: db}
+}
@@ -10,16 +10,25 @@ import (
 type AdviceRepository interface {
 	GetAdvices(userID string) ([]m.Advice, error)
 	GetAdvice(userID string, date time.Time) (m.Advice, error)
+	GetAdviceByID(userID, id string) (m.Advice, error)
 	CreateAdvice(a *m.Advice) error
+	SaveAdvice(a *m.Advice) error
 	GetLastAdvice(userID string) (m.Advice, error)
+	GetAdviceVersions(userID, adviceID, page, limit int) ([]m.AdviceVersion, int64, error)
+	RateAdvice(a *m.Advice) (*int16, error)
+	GetRecentFeedback(userID string, limit int) ([]m.AdviceVersion, error)
+	GetFeedbackStats() ([]m.AdviceFeedbackStats, error)
+	ReencryptAdvices(userID int) (int, error)
 }
 
 type MoodRepository interface {
 	GetMoods(userID string) ([]m.Mood, error)
+	GetMood(userID, id string) (m.Mood, error)
 	GetLastMoods(userID string, limit int) ([]m.Mood, error)
 	CreateMood(m *m.Mood) error
 	UpdateMood(m *m.Mood) error
-	DeleteMood(id string) error
+	DeleteMood(userID, id string) error
+	ReencryptMoods(userID int) (int, error)
 }
 
 type UserRepository interface {
@@ -29,4 +38,68 @@ type UserRepository interface {
 	GetUserByEmail(email string) (*m.User, error)
 	UpdateUser(userID int, updates any) error
 	DeleteUser(id string) error
+	SearchUsers(query string, page, limit int) ([]m.User, int64, error)
+}
+
+type UserKeyRepository interface {
+	GetActiveUserKey(userID int) (m.UserKey, error)
+	GetUserKey(userID, version int) (m.UserKey, error)
+	GetUserKeys(userID int) ([]m.UserKey, error)
+	CreateUserKey(key *m.UserKey) error
+	UpdateUserKey(key *m.UserKey) error
+}
+
+type DeletionRepository interface {
+	CreateDeletion(d *m.AccountDeletion) error
+	GetScheduledDeletion(userID int) (m.AccountDeletion, error)
+	GetDeletionByReceipt(receiptID string) (m.AccountDeletion, error)
+	CancelDeletion(d *m.AccountDeletion) error
+	GetDueDeletions(now time.Time) ([]m.AccountDeletion, error)
+	CompleteDeletion(d *m.AccountDeletion) error
+	GetDeletions(page, limit int) ([]m.AccountDeletion, int64, error)
+	SaveDeletionCode(c *m.DeletionCode) error
+	GetDeletionCode(userID int, now time.Time) (m.DeletionCode, error)
+	IncrementDeletionCodeAttempts(id int) error
+	UseDeletionCode(id int) error
+}
+
+type SecurityEventRepository interface {
+	CreateEvent(e *m.SecurityEvent) error
+	HasEvent(userID int, eventType, key, value string) (bool, error)
+	GetEvents(filter m.SecurityEventFilter, page, limit int) ([]m.SecurityEvent, int64, error)
+}
+
+type StatsRepository interface {
+	GetSystemStats(since time.Time) (m.SystemStats, error)
+}
+
+type PasskeyRepository interface {
+	CreatePasskey(p *m.Passkey) error
+	GetUserPasskeys(userID int) ([]m.Passkey, error)
+	GetPasskeyByCredentialID(credentialID []byte) (m.Passkey, error)
+	UpdatePasskey(p *m.Passkey) error
+	DeletePasskey(userID, id int) error
+
+	CreateSession(s *m.WebAuthnSession) error
+	TakeSession(id string) (m.WebAuthnSession, error)
+}
+
+type MagicLinkRepository interface {
+	CreateLink(link *m.MagicLink) error
+	GetActiveLinkByToken(tokenHash string, now time.Time) (m.MagicLink, error)
+	GetActiveLinkByEmail(email, bindingHash string, now time.Time) (m.MagicLink, error)
+	IncrementAttempts(id int) error
+	UseLink(id int, now time.Time, newUser *m.User) error
+}
+
+type PromptRepository interface {
+	CreatePrompt(prompt *m.PromptTemplate) error
+	GetPrompts() ([]m.PromptTemplate, error)
+}
+
+type AIUsageRepository interface {
+	AddUsage(usage *m.AIUsage) error
+	GetUsageSince(userID int, since time.Time) (m.AIUsageTotal, error)
+	ReserveUsage(reservation *m.AIUsage, quotas []m.AIQuota) (*m.AIQuota, error)
+	SettleUsage(reservation, usage *m.AIUsage) error
 }
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	m "sentimenta/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type magicLinkRepository struct {
+	db *gorm.DB
+}
+
+// CreateLink сохраняет ссылку и удаляет истёкшие. Другие ссылки на ту же
+// почту продолжают действовать: каждая привязана к своему браузеру, и
+// запрос ссылки на чужую почту не должен мешать её владельцу войти.
+func (r *magicLinkRepository) CreateLink(link *m.MagicLink) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("expires_at < ?", link.CreatedAt).Delete(&m.MagicLink{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(link).Error
+	})
+}
+
+func (r *magicLinkRepository) GetActiveLinkByToken(tokenHash string, now time.Time) (m.MagicLink, error) {
+	var link m.MagicLink
+	err := r.db.
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, now).
+		First(&link).
+		Error
+	return link, err
+}
+
+func (r *magicLinkRepository) GetActiveLinkByEmail(email, bindingHash string, now time.Time) (m.MagicLink, error) {
+	var link m.MagicLink
+	err := r.db.
+		Where("email = ? AND binding_hash = ? AND used_at IS NULL AND expires_at > ?", email, bindingHash, now).
+		Order("created_at DESC").
+		First(&link).
+		Error
+	return link, err
+}
+
+func (r *magicLinkRepository) IncrementAttempts(id int) error {
+	return r.db.Model(&m.MagicLink{}).
+		Where("uid = ?", id).
+		UpdateColumn("attempts", gorm.Expr("attempts + 1")).
+		Error
+}
+
+// UseLink отмечает ссылку использованной и, если newUser не nil, в той же
+// транзакции создаёт пользователя. Если ссылку уже использовал
+// параллельный запрос, возвращает gorm.ErrRecordNotFound; если не удалось
+// создать пользователя, ссылка остаётся неиспользованной.
+func (r *magicLinkRepository) UseLink(id int, now time.Time, newUser *m.User) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&m.MagicLink{}).
+			Where("uid = ? AND used_at IS NULL", id).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if newUser == nil {
+			return nil
+		}
+		return tx.Create(newUser).Error
+	})
+}
+
+func NewMagicLinkRepository(db *gorm.DB) MagicLinkRepository {
+	return &magicLinkRepository{db: db}
+}
@@ -1,39 +1,182 @@
 package repository
 
 import (
+	"sentimenta/internal/encryption"
 	m "sentimenta/internal/models"
 
 	"gorm.io/gorm"
 )
 
 type moodRepository struct {
-	db *gorm.DB
+	db     *gorm.DB
+	cipher encryption.Cipher
 }
 
+// CreateMood сохраняет запись. Шифртекст связан с uid записи, поэтому
+// зашифрованные поля записываются вторым запросом после вставки.
 func (r *moodRepository) CreateMood(mood *m.Mood) error {
-	return r.db.Create(&mood).Error
+	stored := *mood
+	stored.Description, stored.Emotions = "", ""
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&stored).Error; err != nil {
+			return err
+		}
+		encrypted := stored
+		encrypted.Description, encrypted.Emotions = mood.Description, mood.Emotions
+		if err := r.encrypt(&encrypted); err != nil {
+			return err
+		}
+		return tx.Model(&m.Mood{}).
+			Where("uid = ?", stored.Uid).
+			UpdateColumns(map[string]any{
+				"description": encrypted.Description,
+				"emotions":    encrypted.Emotions,
+			}).Error
+	})
+	if err != nil {
+		return err
+	}
+	stored.Description, stored.Emotions = mood.Description, mood.Emotions
+	*mood = stored
+	return nil
 }
 
-func (r *moodRepository) DeleteMood(id string) error {
-	return r.db.Delete(&m.Mood{}, "uid = ?", id).Error
+func (r *moodRepository) DeleteMood(userID, id string) error {
+	return r.db.Delete(&m.Mood{}, "uid = ? AND user_id = ?", id, userID).Error
 }
 
 func (r *moodRepository) GetMoods(userID string) ([]m.Mood, error) {
 	var moods []m.Mood
-	err := r.db.Find(&moods, "user_id = ?", userID).Error
-	return moods, err
+	if err := r.db.Find(&moods, "user_id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+	return moods, r.decryptAll(moods)
+}
+
+func (r *moodRepository) GetMood(userID, id string) (m.Mood, error) {
+	var mood m.Mood
+	if err := r.db.First(&mood, "uid = ? AND user_id = ?", id, userID).Error; err != nil {
+		return m.Mood{}, err
+	}
+	return mood, r.decrypt(&mood)
 }
 
 func (r *moodRepository) GetLastMoods(userID string, limit int) ([]m.Mood, error) {
 	var moods []m.Mood
-	err := r.db.Find(&moods, "user_id = ?", userID).Limit(limit).Order("date DESC").Error
-	return moods, err
+	err := r.db.
+		Where("user_id = ?", userID).
+		Order("date DESC").
+		Limit(limit).
+		Find(&moods).
+		Error
+	if err != nil {
+		return nil, err
+	}
+	return moods, r.decryptAll(moods)
 }
 
+// UpdateMood обновляет запись, увеличивает её версию и перечитывает
+// её в mood целиком.
 func (r *moodRepository) UpdateMood(mood *m.Mood) error {
-	return r.db.Model(&m.Mood{}).Where("uid = ?", mood.Uid).Updates(mood).Error
+	stored := *mood
+	if err := r.encrypt(&stored); err != nil {
+		return err
+	}
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&m.Mood{}).
+			Where("uid = ? AND user_id = ?", mood.Uid, mood.UserId).
+			Updates(&stored)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		// Updates пропускает nil, поэтому метку клиентского шифрования
+		// снимаем явно, когда описание заменено открытым текстом
+		if mood.Description != "" && mood.E2E == nil {
+			if err := tx.Model(&m.Mood{}).
+				Where("uid = ?", mood.Uid).
+				UpdateColumn("e2e", gorm.Expr("NULL")).
+				Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Model(&m.Mood{}).
+			Where("uid = ?", mood.Uid).
+			UpdateColumn("version", gorm.Expr("version + 1")).
+			Error; err != nil {
+			return err
+		}
+
+		if err := tx.First(mood, "uid = ?", mood.Uid).Error; err != nil {
+			return err
+		}
+		return r.decrypt(mood)
+	})
+}
+
+// ReencryptMoods перешифровывает записи пользователя его активным ключом.
+func (r *moodRepository) ReencryptMoods(userID int) (int, error) {
+	var moods []m.Mood
+	if err := r.db.Find(&moods, "user_id = ?", userID).Error; err != nil {
+		return 0, err
+	}
+	if err := r.decryptAll(moods); err != nil {
+		return 0, err
+	}
+
+	for i := range moods {
+		if err := r.encrypt(&moods[i]); err != nil {
+			return i, err
+		}
+		// UpdateColumns не трогает updated_at и версию записи
+		if err := r.db.Model(&m.Mood{}).
+			Where("uid = ?", moods[i].Uid).
+			UpdateColumns(map[string]any{
+				"description": moods[i].Description,
+				"emotions":    moods[i].Emotions,
+			}).Error; err != nil {
+			return i, err
+		}
+	}
+	return len(moods), nil
+}
+
+func (r *moodRepository) encrypt(mood *m.Mood) error {
+	var err error
+	if mood.Description, err = r.cipher.Encrypt(mood.UserId, moodField("description", mood), mood.Description); err != nil {
+		return err
+	}
+	mood.Emotions, err = r.cipher.Encrypt(mood.UserId, moodField("emotions", mood), mood.Emotions)
+	return err
+}
+
+func (r *moodRepository) decrypt(mood *m.Mood) error {
+	var err error
+	if mood.Description, err = r.cipher.Decrypt(mood.UserId, moodField("description", mood), mood.Description); err != nil {
+		return err
+	}
+	mood.Emotions, err = r.cipher.Decrypt(mood.UserId, moodField("emotions", mood), mood.Emotions)
+	return err
+}
+
+func moodField(column string, mood *m.Mood) encryption.Field {
+	return encryption.Field{Table: "moods", Column: column, RowID: mood.Uid}
+}
+
+func (r *moodRepository) decryptAll(moods []m.Mood) error {
+	for i := range moods {
+		if err := r.decrypt(&moods[i]); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func NewMoodRepository(db *gorm.DB) MoodRepository {
-	return &moodRepository{db: db}
+func NewMoodRepository(db *gorm.DB, cipher encryption.Cipher) MoodRepository {
+	return &moodRepository{db: db, cipher: cipher}
 }
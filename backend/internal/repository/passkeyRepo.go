@@ -0,0 +1,80 @@
+package repository
+
+import (
+	m "sentimenta/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type passkeyRepository struct {
+	db *gorm.DB
+}
+
+func (r *passkeyRepository) CreatePasskey(p *m.Passkey) error {
+	return r.db.Create(p).Error
+}
+
+func (r *passkeyRepository) GetUserPasskeys(userID int) ([]m.Passkey, error) {
+	var passkeys []m.Passkey
+	err := r.db.Where("user_id = ?", userID).Order("created_at").Find(&passkeys).Error
+	return passkeys, err
+}
+
+func (r *passkeyRepository) GetPasskeyByCredentialID(credentialID []byte) (m.Passkey, error) {
+	var passkey m.Passkey
+	err := r.db.First(&passkey, "credential_id = ?", credentialID).Error
+	return passkey, err
+}
+
+// UpdatePasskey сохраняет счётчик подписей и флаги после входа.
+func (r *passkeyRepository) UpdatePasskey(p *m.Passkey) error {
+	return r.db.Model(&m.Passkey{}).
+		Where("uid = ?", p.Uid).
+		Updates(map[string]any{
+			"sign_count":    p.SignCount,
+			"clone_warning": p.CloneWarning,
+			"backup_state":  p.BackupState,
+			"last_used_at":  p.LastUsedAt,
+		}).Error
+}
+
+func (r *passkeyRepository) DeletePasskey(userID, id int) error {
+	result := r.db.Delete(&m.Passkey{}, "uid = ? AND user_id = ?", id, userID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// CreateSession сохраняет сессию и заодно удаляет истёкшие.
+func (r *passkeyRepository) CreateSession(s *m.WebAuthnSession) error {
+	if err := r.db.Where("expires_at < ?", time.Now()).Delete(&m.WebAuthnSession{}).Error; err != nil {
+		return err
+	}
+	return r.db.Create(s).Error
+}
+
+// TakeSession возвращает сессию и удаляет её, чтобы ответ на challenge
+// нельзя было использовать повторно.
+func (r *passkeyRepository) TakeSession(id string) (m.WebAuthnSession, error) {
+	var session m.WebAuthnSession
+	result := r.db.Clauses(clause.Returning{}).
+		Where("id = ? AND expires_at > ?", id, time.Now()).
+		Delete(&session)
+	if result.Error != nil {
+		return m.WebAuthnSession{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return m.WebAuthnSession{}, gorm.ErrRecordNotFound
+	}
+	return session, nil
+}
+
+func NewPasskeyRepository(db *gorm.DB) PasskeyRepository {
+	return &passkeyRepository{db: db}
+}
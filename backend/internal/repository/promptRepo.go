@@ -0,0 +1,38 @@
+package repository
+
+import (
+	errs "sentimenta/internal/errors"
+	m "sentimenta/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type promptRepository struct {
+	db *gorm.DB
+}
+
+// CreatePrompt сохраняет новую версию. Существующая версия не
+// перезаписывается.
+func (r *promptRepository) CreatePrompt(prompt *m.PromptTemplate) error {
+	result := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(prompt)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errs.ErrPromptVersionExists
+	}
+	return nil
+}
+
+func (r *promptRepository) GetPrompts() ([]m.PromptTemplate, error) {
+	var prompts []m.PromptTemplate
+	if err := r.db.Order("created_at").Find(&prompts).Error; err != nil {
+		return nil, err
+	}
+	return prompts, nil
+}
+
+func NewPromptRepository(db *gorm.DB) PromptRepository {
+	return &promptRepository{db: db}
+}
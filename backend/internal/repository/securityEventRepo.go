@@ -0,0 +1,68 @@
+package repository
+
+import (
+	m "sentimenta/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// securityEventRepository намеренно не умеет изменять и удалять события:
+// журнал только пополняется. События пользователя удаляются лишь вместе
+// с аккаунтом в deleteUserData.
+type securityEventRepository struct {
+	db *gorm.DB
+}
+
+func (r *securityEventRepository) CreateEvent(e *m.SecurityEvent) error {
+	return r.db.Create(e).Error
+}
+
+func (r *securityEventRepository) HasEvent(userID int, eventType, key, value string) (bool, error) {
+	var count int64
+	err := r.db.Model(&m.SecurityEvent{}).
+		Where("user_id = ? AND type = ? AND details ->> ? = ?", userID, eventType, key, value).
+		Count(&count).
+		Error
+	return count > 0, err
+}
+
+func (r *securityEventRepository) GetEvents(filter m.SecurityEventFilter, page, limit int) ([]m.SecurityEvent, int64, error) {
+	var events []m.SecurityEvent
+	var total int64
+
+	query := r.db.Model(&m.SecurityEvent{})
+	if filter.UserID != nil {
+		query = query.Where("user_id = ?", *filter.UserID)
+	}
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	if filter.IP != "" {
+		query = query.Where("ip = ?", filter.IP)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := query.
+		Order("created_at DESC").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Find(&events).
+		Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+func NewSecurityEventRepository(db *gorm.DB) SecurityEventRepository {
+	return &securityEventRepository{db: db}
+}
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	m "sentimenta/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type statsRepository struct {
+	db *gorm.DB
+}
+
+// GetSystemStats считает пользователей, записи и советы. since — начало
+// периода для счётчиков за неделю. Все счётчики считаются одним запросом:
+// Scan обнуляет структуру перед заполнением, поэтому несколько Scan в одну
+// структуру оставили бы только последний.
+func (r *statsRepository) GetSystemStats(since time.Time) (m.SystemStats, error) {
+	var stats m.SystemStats
+	err := r.db.Raw(`
+		SELECT u.*, md.*, a.*
+		FROM (
+			SELECT COUNT(*) AS users,
+				COUNT(*) FILTER (WHERE disabled) AS disabled_users,
+				COUNT(*) FILTER (WHERE role = ?) AS admins,
+				COUNT(*) FILTER (WHERE use_ai AND NOT ai_disabled_by_admin) AS ai_users,
+				COUNT(*) FILTER (WHERE deletion_scheduled_at IS NOT NULL) AS scheduled_deletions
+			FROM users
+		) AS u, (
+			SELECT COUNT(*) AS moods,
+				COUNT(*) FILTER (WHERE created_at >= ?) AS moods_last_week,
+				COUNT(DISTINCT user_id) FILTER (WHERE created_at >= ?) AS active_users_week
+			FROM moods
+		) AS md, (
+			SELECT COUNT(*) AS advices,
+				COUNT(*) FILTER (WHERE created_at >= ?) AS advices_last_week
+			FROM advices
+		) AS a`, m.RoleAdmin, since, since, since).
+		Scan(&stats).
+		Error
+	if err != nil {
+		return m.SystemStats{}, err
+	}
+	return stats, nil
+}
+
+func NewStatsRepository(db *gorm.DB) StatsRepository {
+	return &statsRepository{db: db}
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	m "sentimenta/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type userKeyRepository struct {
+	db *gorm.DB
+}
+
+func (r *userKeyRepository) GetActiveUserKey(userID int) (m.UserKey, error) {
+	var key m.UserKey
+	err := r.db.
+		Where("user_id = ? AND active", userID).
+		Order("version DESC").
+		First(&key).
+		Error
+	return key, err
+}
+
+func (r *userKeyRepository) GetUserKey(userID, version int) (m.UserKey, error) {
+	var key m.UserKey
+	err := r.db.First(&key, "user_id = ? AND version = ?", userID, version).Error
+	return key, err
+}
+
+func (r *userKeyRepository) GetUserKeys(userID int) ([]m.UserKey, error) {
+	var keys []m.UserKey
+	err := r.db.Order("version").Find(&keys, "user_id = ?", userID).Error
+	return keys, err
+}
+
+// CreateUserKey сохраняет новый ключ и делает его единственным активным.
+func (r *userKeyRepository) CreateUserKey(key *m.UserKey) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&m.UserKey{}).
+			Where("user_id = ? AND active", key.UserID).
+			Update("active", false).
+			Error; err != nil {
+			return err
+		}
+		return tx.Create(key).Error
+	})
+}
+
+func (r *userKeyRepository) UpdateUserKey(key *m.UserKey) error {
+	return r.db.Save(key).Error
+}
+
+func NewUserKeyRepository(db *gorm.DB) UserKeyRepository {
+	return &userKeyRepository{db: db}
+}
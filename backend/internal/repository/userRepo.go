@@ -2,6 +2,8 @@ package repository
 
 import (
 	m "sentimenta/internal/models"
+	"strconv"
+	"strings"
 
 	"gorm.io/gorm"
 )
@@ -26,8 +28,16 @@ func (r *userRepository) UpdateUser(userID int, updates any) error {
 	return r.db.Model(&m.User{}).Where("uid = ?", userID).Updates(updates).Error
 }
 
+// DeleteUser удаляет пользователя вместе с записями, советами и ключами.
 func (r *userRepository) DeleteUser(id string) error {
-	return r.db.Delete(&m.User{}, "uid = ?", id).Error
+	userID, err := strconv.Atoi(id)
+	if err != nil {
+		return err
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		_, err := deleteUserData(tx, userID)
+		return err
+	})
 }
 
 func (r *userRepository) GetUserByEmail(email string) (*m.User, error) {
@@ -46,6 +56,36 @@ func (r *userRepository) GetAllUsers() ([]m.User, error) {
 	return users, nil
 }
 
+// SearchUsers ищет пользователей по подстроке в имени или почте.
+func (r *userRepository) SearchUsers(query string, page, limit int) ([]m.User, int64, error) {
+	var users []m.User
+	var total int64
+
+	q := r.db.Model(&m.User{})
+	if query != "" {
+		pattern := "%" + escapeLike(query) + "%"
+		q = q.Where("username ILIKE ? OR email ILIKE ?", pattern, pattern)
+	}
+	if err := q.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := q.
+		Order("uid").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Find(&users).
+		Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
 func NewUserRepository(db *gorm.DB) UserRepository {
 	return &userRepository{db: db}
 }
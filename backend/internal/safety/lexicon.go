@@ -0,0 +1,156 @@
+package safety
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+//go:embed lexicons/*.txt
+var embeddedLexicons embed.FS
+
+const (
+	kindCrisis   = "crisis"
+	kindDenylist = "denylist"
+)
+
+// lexicon — набор фраз одного вида (crisis или denylist) для одного языка.
+type lexicon struct {
+	locale   string
+	patterns []*regexp.Regexp
+	phrases  []string
+	// maxWords — число слов в самой длинной фразе
+	maxWords int
+}
+
+func (l *lexicon) match(normalized string) []string {
+	var matches []string
+	for i, p := range l.patterns {
+		if p.MatchString(normalized) {
+			matches = append(matches, l.phrases[i])
+		}
+	}
+	return matches
+}
+
+// loadLexicons читает встроенные словари и, если задан dir, словари из
+// этого каталога. Файлы называются <kind>_<locale>.txt, фразы из
+// каталога добавляются к встроенным.
+func loadLexicons(dir string) (map[string][]*lexicon, error) {
+	byKey := map[string]*lexicon{}
+	var keys []string
+
+	add := func(name string, r io.Reader) error {
+		kind, locale, ok := strings.Cut(strings.TrimSuffix(filepath.Base(name), ".txt"), "_")
+		if !ok {
+			return nil
+		}
+		key := kind + "_" + locale
+		lex, exists := byKey[key]
+		if !exists {
+			lex = &lexicon{locale: locale}
+			byKey[key] = lex
+			keys = append(keys, key)
+		}
+		return lex.read(r)
+	}
+
+	err := fs.WalkDir(embeddedLexicons, "lexicons", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(embeddedLexicons, path)
+		if err != nil {
+			return err
+		}
+		return add(path, bytes.NewReader(data))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if dir != "" {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			if err := add(path, bytes.NewReader(data)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	result := map[string][]*lexicon{}
+	for _, key := range keys {
+		kind, _, _ := strings.Cut(key, "_")
+		result[kind] = append(result[kind], byKey[key])
+	}
+	return result, nil
+}
+
+func (l *lexicon) read(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pattern, err := compilePhrase(line)
+		if err != nil {
+			return err
+		}
+		l.phrases = append(l.phrases, line)
+		l.patterns = append(l.patterns, pattern)
+		l.maxWords = max(l.maxWords, len(strings.Fields(normalize(line))))
+	}
+	return scanner.Err()
+}
+
+// compilePhrase превращает фразу в регулярное выражение по целым словам.
+// Слово с * на конце совпадает с любым словом, начинающимся так же.
+func compilePhrase(phrase string) (*regexp.Regexp, error) {
+	var words []string
+	for _, word := range strings.Fields(normalize(strings.ReplaceAll(phrase, "*", "\x00"))) {
+		if prefix, ok := strings.CutSuffix(word, "\x00"); ok {
+			words = append(words, regexp.QuoteMeta(prefix)+`\S*`)
+		} else {
+			words = append(words, regexp.QuoteMeta(word))
+		}
+	}
+	return regexp.Compile(`(?:^| )` + strings.Join(words, " ") + `(?:$| )`)
+}
+
+// normalize приводит текст к нижнему регистру, заменяет ё на е и
+// оставляет только буквы и цифры, разделённые одиночными пробелами.
+func normalize(text string) string {
+	var b strings.Builder
+	space := true
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case r == 'ё':
+			r = 'е'
+		case r == '\x00':
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			if !space {
+				b.WriteRune(' ')
+				space = true
+			}
+			continue
+		}
+		b.WriteRune(r)
+		space = false
+	}
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,226 @@
+package safety
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"os"
+	"sentimenta/internal/ai"
+	"sentimenta/internal/config"
+	"sentimenta/internal/i18n"
+	"sentimenta/internal/metrics"
+	"sentimenta/internal/models"
+	"sentimenta/internal/privacy"
+	"sentimenta/internal/utils"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+//go:embed resources.json
+var embeddedResources []byte
+
+// Режимы обработки кризисных записей при генерации совета.
+const (
+	// ModeReplace — вместо совета пользователь получает контакты помощи,
+	// запись не отправляется в модель.
+	ModeReplace = "replace"
+	// ModeAppend — совет генерируется как обычно, контакты добавляются к нему.
+	ModeAppend = "append"
+)
+
+const (
+	SourceLexicon = "lexicon"
+	SourceModel   = "model"
+)
+
+const defaultLocale = "en"
+
+const classifierPrompt = `You are a safety classifier for a mental health journaling app.
+Reply with exactly one word: YES if the user's text contains suicidal ideation, self-harm, intent to harm others or another acute crisis, otherwise NO.`
+
+// Completer — то, что умеет отправлять сообщения модели (см. ai.Client).
+type Completer interface {
+	Complete(ctx context.Context, messages []utils.OpenRouterMessage) (ai.Completion, error)
+}
+
+// UsageRecorder учитывает расход модели на пользователя (см.
+// service.AIUsageService).
+type UsageRecorder interface {
+	RecordUsage(userID int, completion ai.Completion)
+}
+
+type Assessment struct {
+	Flagged bool
+	Locale  string
+	Source  string
+	Matches []string
+}
+
+// Checker ищет кризисные формулировки в записях пользователя и
+// проверяет ответы модели по списку запрещённых фраз.
+type Checker struct {
+	config    *config.Config
+	lexicons  map[string][]*lexicon
+	resources map[string]models.CrisisResources
+	// holdWords — сколько последних слов OutputStream придерживает до
+	// следующей части ответа
+	holdWords int
+	ai        Completer
+	usage     UsageRecorder
+	redactor  *privacy.Redactor
+	metrics   *metrics.Prometheus
+	logger    *zap.SugaredLogger
+}
+
+// Assess проверяет текст по словарям и, если включено, с помощью модели.
+// Проверка моделью ограничена SAFETY_AI_TIMEOUT_SECONDS и прерывается
+// вместе с ctx; при ошибке остаётся результат словарей.
+func (c *Checker) Assess(ctx context.Context, userID int, text string) Assessment {
+	if !c.config.SAFETY_ENABLED {
+		return Assessment{}
+	}
+	normalized := normalize(text)
+	if normalized == "" {
+		return Assessment{}
+	}
+
+	result := Assessment{Locale: DetectLocale(text)}
+	for _, lex := range c.lexicons[kindCrisis] {
+		if matches := lex.match(normalized); len(matches) > 0 {
+			result.Flagged = true
+			result.Source = SourceLexicon
+			result.Locale = lex.locale
+			result.Matches = append(result.Matches, matches...)
+		}
+	}
+
+	if !result.Flagged && c.config.SAFETY_AI_CHECK_ENABLED && c.config.AI_ENABLED {
+		flagged, err := c.classify(ctx, userID, text)
+		if err != nil {
+			c.logger.Errorf("не удалось проверить запись с помощью модели: %v", err)
+		}
+		if flagged {
+			result.Flagged = true
+			result.Source = SourceModel
+		}
+	}
+
+	if result.Flagged {
+		c.metrics.SafetyFlagsTotal.WithLabelValues(result.Source, result.Locale).Inc()
+	}
+	return result
+}
+
+// ScreenOutput возвращает false, если ответ модели содержит запрещённые фразы.
+func (c *Checker) ScreenOutput(text string) bool {
+	normalized := normalize(text)
+	for _, lex := range c.lexicons[kindDenylist] {
+		if matches := lex.match(normalized); len(matches) > 0 {
+			c.logger.Warnf("ответ модели отклонён фильтром безопасности: %v", matches)
+			return false
+		}
+	}
+	return true
+}
+
+// Resources возвращает контакты помощи на нужном языке, по умолчанию на английском.
+func (c *Checker) Resources(locale string) models.CrisisResources {
+	if res, ok := c.resources[locale]; ok {
+		return res
+	}
+	return c.resources[defaultLocale]
+}
+
+// classify спрашивает модель, есть ли в тексте признаки кризиса. Расход
+// записывается на пользователя, но лимиты токенов проверку не
+// останавливают.
+func (c *Checker) classify(ctx context.Context, userID int, text string) (bool, error) {
+	if c.config.PII_REDACTION_ENABLED {
+		text = c.redactor.NewSession().Redact(text)
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.config.SAFETY_AI_TIMEOUT_SECONDS)*time.Second)
+	defer cancel()
+	answer, err := c.ai.Complete(ctx, []utils.OpenRouterMessage{
+		{Role: "system", Content: classifierPrompt},
+		{Role: "user", Content: text},
+	})
+	c.usage.RecordUsage(userID, answer)
+	if err != nil {
+		return false, err
+	}
+	return strings.HasPrefix(strings.ToUpper(strings.TrimSpace(answer.Text)), "YES"), nil
+}
+
+// DetectLocale грубо определяет язык текста по преобладающему алфавиту.
+func DetectLocale(text string) string {
+	if lang, ok := i18n.Detect(text); ok {
+		return lang
+	}
+	return defaultLocale
+}
+
+func loadResources(path string) (map[string]models.CrisisResources, error) {
+	resources := map[string]models.CrisisResources{}
+	if err := json.Unmarshal(embeddedResources, &resources); err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return resources, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	overrides := map[string]models.CrisisResources{}
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, err
+	}
+	for locale, res := range overrides {
+		resources[locale] = res
+	}
+	return resources, nil
+}
+
+func NewChecker(
+	cfg *config.Config,
+	ai Completer,
+	usage UsageRecorder,
+	redactor *privacy.Redactor,
+	prometheus *metrics.Prometheus,
+	log *zap.SugaredLogger,
+) *Checker {
+	switch cfg.SAFETY_CRISIS_MODE {
+	case ModeReplace, ModeAppend:
+	default:
+		log.Fatalf("Неизвестный режим SAFETY_CRISIS_MODE=%s, допустимы %s и %s", cfg.SAFETY_CRISIS_MODE, ModeReplace, ModeAppend)
+	}
+
+	lexicons, err := loadLexicons(cfg.SAFETY_LEXICON_DIR)
+	if err != nil {
+		log.Fatalf("Не удалось загрузить словари безопасности: %v", err)
+	}
+	resources, err := loadResources(cfg.SAFETY_RESOURCES_PATH)
+	if err != nil {
+		log.Fatalf("Не удалось загрузить контакты помощи: %v", err)
+	}
+
+	holdWords := 1
+	for _, lex := range lexicons[kindDenylist] {
+		holdWords = max(holdWords, lex.maxWords)
+	}
+
+	return &Checker{
+		config:    cfg,
+		holdWords: holdWords,
+		lexicons:  lexicons,
+		resources: resources,
+		ai:        ai,
+		usage:     usage,
+		redactor:  redactor,
+		metrics:   prometheus,
+		logger:    log,
+	}
+}
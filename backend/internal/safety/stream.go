@@ -0,0 +1,70 @@
+package safety
+
+import (
+	"strings"
+	"unicode"
+)
+
+// OutputStream проверяет ответ модели, приходящий по частям, до того как
+// он будет показан пользователю. Фразы из словаря совпадают по целым
+// словам и могут состоять из нескольких слов, поэтому последние слова
+// придерживаются — столько, сколько их в самой длинной запрещённой фразе.
+// Так начало фразы («kill ») не уходит пользователю раньше её конца.
+// После первого совпадения поток блокируется и больше ничего не отдаёт.
+type OutputStream struct {
+	checker  *Checker
+	text     strings.Builder
+	released int
+	blocked  bool
+}
+
+func (c *Checker) NewOutputStream() *OutputStream {
+	return &OutputStream{checker: c}
+}
+
+// Push добавляет часть ответа и возвращает текст, который можно показать.
+func (s *OutputStream) Push(delta string) string {
+	if s.blocked {
+		return ""
+	}
+	s.text.WriteString(delta)
+	if !s.checker.ScreenOutput(s.text.String()) {
+		s.blocked = true
+		return ""
+	}
+
+	// Начала слов в ещё не отданном тексте; отдаётся всё до начала
+	// holdWords-го слова с конца
+	full := s.text.String()
+	var starts []int
+	inWord := false
+	for i, r := range full[s.released:] {
+		letter := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if letter && !inWord {
+			starts = append(starts, s.released+i)
+		}
+		inWord = letter
+	}
+	if len(starts) < s.checker.holdWords {
+		return ""
+	}
+	cut := starts[len(starts)-s.checker.holdWords]
+	out := full[s.released:cut]
+	s.released = cut
+	return out
+}
+
+// Flush возвращает придержанный остаток в конце ответа.
+func (s *OutputStream) Flush() string {
+	if s.blocked {
+		return ""
+	}
+	out := s.text.String()[s.released:]
+	s.released = s.text.Len()
+	return out
+}
+
+// Blocked сообщает, что ответ не прошёл фильтр.
+func (s *OutputStream) Blocked() bool {
+	return s.blocked
+}
@@ -0,0 +1,57 @@
+package safety
+
+import (
+	"sentimenta/internal/config"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func newTestChecker(t *testing.T) *Checker {
+	t.Helper()
+	return NewChecker(&config.Config{SAFETY_CRISIS_MODE: ModeReplace}, nil, nil, nil, nil, zap.NewNop().Sugar())
+}
+
+func TestOutputStream(t *testing.T) {
+	c := newTestChecker(t)
+
+	tests := []struct {
+		name    string
+		chunks  []string
+		blocked bool
+	}{
+		{"plain text", []string{"Попробуйте ", "выйти на про", "гулку и отдохнуть."}, false},
+		{"phrase split across chunks", []string{"You should just kill ", "yourself."}, true},
+		{"phrase split inside a word", []string{"Please kill your", "self now"}, true},
+		{"long phrase split word by word", []string{"a ", "painless ", "way ", "to ", "die"}, true},
+		{"ru phrase split across chunks", []string{"Тебе лучше ", "убей ", "себя"}, true},
+		{"phrase prefix without phrase", []string{"Don't kill ", "your plants by overwatering."}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := c.NewOutputStream()
+			var out strings.Builder
+			for _, chunk := range tt.chunks {
+				out.WriteString(s.Push(chunk))
+			}
+			out.WriteString(s.Flush())
+
+			if s.Blocked() != tt.blocked {
+				t.Fatalf("Blocked() = %v, want %v", s.Blocked(), tt.blocked)
+			}
+			full := strings.Join(tt.chunks, "")
+			if !tt.blocked && out.String() != full {
+				t.Errorf("released %q, want %q", out.String(), full)
+			}
+			if tt.blocked {
+				for _, word := range []string{"kill", "painless", "убей"} {
+					if strings.Contains(strings.ToLower(out.String()), word) {
+						t.Errorf("released %q contains %q", out.String(), word)
+					}
+				}
+			}
+		})
+	}
+}
@@ -1,61 +1,135 @@
 package security
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	cfg "sentimenta/internal/config"
 	errs "sentimenta/internal/errors"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
 )
 
 type JWT struct {
 	config *cfg.Config
+	// nil, если ключи не настроены и токены подписываются JWT_SECRET
+	keys *KeyRing
+}
+
+// tokenTTL — срок действия токена доступа.
+const tokenTTL = 720 * time.Hour
+
+// Claims — содержимое токена доступа. Subject — идентификатор
+// пользователя, ID (jti) — уникальный идентификатор токена.
+type Claims struct {
+	jwt.RegisteredClaims
 }
 
 func (j JWT) GenerateJWT(userID string) (string, error) {
-	claims := jwt.MapClaims{
-		"sub": userID,
-		"exp": time.Now().Add(time.Hour * 720).Unix(),
-		"iat": time.Now().Unix(),
-		"iss": "my-api",
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(j.config.JWT_SECRET))
-}
+	now := time.Now()
+	claims := Claims{RegisteredClaims: jwt.RegisteredClaims{
+		Subject:   userID,
+		Issuer:    j.config.JWT_ISSUER,
+		ExpiresAt: jwt.NewNumericDate(now.Add(tokenTTL)),
+		NotBefore: jwt.NewNumericDate(now),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ID:        hex.EncodeToString(jti),
+	}}
+	if j.config.JWT_AUDIENCE != "" {
+		claims.Audience = jwt.ClaimStrings{j.config.JWT_AUDIENCE}
+	}
 
-func (j JWT) ParseJWT(tokenStr string, secretKey string) (string, error) {
-	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-		// Проверка метода подписи
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errs.ErrUnsupportedSignatureMethod
-		}
-		return []byte(secretKey), nil
-	})
+	if j.keys == nil {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		return token.SignedString([]byte(j.config.JWT_SECRET))
+	}
+
+	key, err := j.keys.current()
 	if err != nil {
 		return "", err
 	}
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.alg), claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+// ParseJWT проверяет подпись и стандартные поля токена: exp, iat и sub
+// обязательны, nbf и iat проверяются с допуском JWT_LEEWAY_SECONDS, iss и aud должны
+// совпадать с настройками. Пустой JWT_AUDIENCE отключает проверку aud.
+func (j JWT) ParseJWT(tokenStr string) (*Claims, error) {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg(), AlgEdDSA, AlgRS256}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithIssuer(j.config.JWT_ISSUER),
+		jwt.WithLeeway(time.Duration(j.config.JWT_LEEWAY_SECONDS) * time.Second),
+	}
+	if j.config.JWT_AUDIENCE != "" {
+		options = append(options, jwt.WithAudience(j.config.JWT_AUDIENCE))
+	}
 
-	// Проверка на валидность токена и срок действия
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		// Проверка срока действия
-		if exp, ok := claims["exp"].(float64); ok {
-			if exp < float64(time.Now().Unix()) {
-				return "", errs.ErrTokenExpired // Если токен истёк
-			}
-		} else {
-			return "", errs.ErrNoExpClaim // Если в токене нет поля exp
+	var claims Claims
+	if _, err := jwt.ParseWithClaims(tokenStr, &claims, j.verificationKey, options...); err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, errs.ErrTokenExpired
 		}
+		return nil, err
+	}
+	// WithIssuedAt проверяет iat, только если он есть, а по iat проверяется
+	// отзыв токенов
+	if claims.IssuedAt == nil {
+		return nil, errs.ErrInvalidToken
+	}
+	if claims.Subject == "" {
+		return nil, errs.ErrNotFoundInJWT
+	}
+	return &claims, nil
+}
 
-		// Извлечение userID из claim
-		if uid, ok := claims["sub"].(string); ok {
-			return uid, nil
+// verificationKey выбирает ключ проверки подписи по kid. После перехода
+// на ключи токены HS256 принимаются, только пока задан JWT_SECRET и не
+// прошёл срок действия токена с появления первого ключа: так выданные
+// раньше токены доживают свой срок, а утёкший JWT_SECRET не остаётся
+// рабочим навсегда.
+func (j JWT) verificationKey(t *jwt.Token) (interface{}, error) {
+	if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
+		if j.config.JWT_SECRET == "" {
+			return nil, errs.ErrUnsupportedSignatureMethod
 		}
+		if j.keys != nil && !time.Now().Before(j.keys.legacyCutoff()) {
+			return nil, errs.ErrUnsupportedSignatureMethod
+		}
+		return []byte(j.config.JWT_SECRET), nil
+	}
+
+	if j.keys == nil {
+		return nil, errs.ErrUnsupportedSignatureMethod
 	}
+	kid, _ := t.Header["kid"].(string)
+	key, ok := j.keys.lookup(kid)
+	if !ok {
+		return nil, errs.ErrUnknownKeyID
+	}
+	// Метод подписи должен совпадать с типом ключа
+	if t.Method.Alg() != key.alg {
+		return nil, errs.ErrUnsupportedSignatureMethod
+	}
+	return key.private.Public(), nil
+}
 
-	return "", errs.ErrNotFoundInJWT
+// Keys возвращает связку ключей подписи или nil, если используется
+// JWT_SECRET.
+func (j JWT) Keys() *KeyRing {
+	return j.keys
 }
 
-func NewJWT(cfg *cfg.Config) *JWT {
-	return &JWT{config: cfg}
+func NewJWT(cfg *cfg.Config, log *zap.SugaredLogger) *JWT {
+	return &JWT{config: cfg, keys: NewKeyRing(cfg, log)}
 }
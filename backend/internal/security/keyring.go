@@ -0,0 +1,341 @@
+package security
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sentimenta/internal/config"
+	errs "sentimenta/internal/errors"
+	"sentimenta/internal/models"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	AlgEdDSA = "EdDSA"
+	AlgRS256 = "RS256"
+)
+
+// kidTimeLayout — формат времени создания в начале kid. Порядок ключей
+// определяется им, а не временем изменения файла, которое меняется при
+// копировании и восстановлении из резервной копии.
+const kidTimeLayout = "20060102T150405Z"
+
+// reloadInterval — как часто незнакомый kid может вызвать перечитывание
+// каталога. Иначе поток токенов со случайным kid читал бы диск на каждый
+// запрос.
+const reloadInterval = 10 * time.Second
+
+// signingKey — ключ подписи токенов. created берётся из kid, а для файлов
+// с kid другого вида — из времени изменения файла.
+type signingKey struct {
+	kid     string
+	alg     string
+	private crypto.Signer
+	created time.Time
+}
+
+// KeyRing хранит ключи подписи из каталога JWT_KEYS_DIR. Каждый файл
+// <kid>.pem — закрытый ключ Ed25519 или RSA в PKCS#8.
+//
+// Токены подписываются самым новым ключом. Предыдущий ключ после
+// появления нового ещё overlap принимается при проверке, чтобы выданные
+// им токены дожили до конца срока действия. Ключи старше этого окна в
+// JWKS не публикуются, но файлы не удаляются.
+//
+// Несколько экземпляров сервера могут делить один каталог: перед ротацией
+// каталог перечитывается, а новые ключи подхватываются при следующей
+// проверке.
+type KeyRing struct {
+	mu         sync.RWMutex
+	keys       []signingKey // по возрастанию created
+	lastReload time.Time
+
+	dir         string
+	alg         string
+	rotateEvery time.Duration
+	overlap     time.Duration
+	logger      *zap.SugaredLogger
+}
+
+// Load перечитывает ключи из каталога.
+func (r *KeyRing) Load() error {
+	files, err := filepath.Glob(filepath.Join(r.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	var keys []signingKey
+	for _, file := range files {
+		key, err := readKey(file)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b signingKey) int {
+		if c := a.created.Compare(b.created); c != 0 {
+			return c
+		}
+		return strings.Compare(a.kid, b.kid)
+	})
+
+	r.mu.Lock()
+	r.keys = keys
+	r.lastReload = time.Now()
+	r.mu.Unlock()
+	return nil
+}
+
+// Rotate создаёт новый ключ, который сразу становится текущим.
+func (r *KeyRing) Rotate() (string, error) {
+	private, err := generateKey(r.alg)
+	if err != nil {
+		return "", err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	kid := time.Now().UTC().Format(kidTimeLayout) + "-" + hex.EncodeToString(suffix)
+	file, err := os.OpenFile(filepath.Join(r.dir, kid+".pem"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", err
+	}
+	if err := pem.Encode(file, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		file.Close()
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+	return kid, r.Load()
+}
+
+// RotateIfDue создаёт новый ключ, если ключей нет или текущий старше
+// JWT_KEY_ROTATION_DAYS.
+func (r *KeyRing) RotateIfDue() error {
+	if err := r.Load(); err != nil {
+		return err
+	}
+	r.mu.RLock()
+	due := len(r.keys) == 0 ||
+		(r.rotateEvery > 0 && time.Since(r.keys[len(r.keys)-1].created) >= r.rotateEvery)
+	r.mu.RUnlock()
+	if !due {
+		return nil
+	}
+
+	kid, err := r.Rotate()
+	if err != nil {
+		return err
+	}
+	r.logger.Infof("JWT: создан новый ключ подписи %s", kid)
+	return nil
+}
+
+// RunRotation периодически вызывает RotateIfDue, пока не отменён ctx.
+// Между ротациями так же подхватываются ключи, созданные другими
+// экземплярами.
+func (r *KeyRing) RunRotation(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := r.RotateIfDue(); err != nil {
+			r.logger.Errorf("JWT: не удалось обновить ключи подписи: %v", err)
+		}
+	}
+}
+
+func (r *KeyRing) current() (signingKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.keys) == 0 {
+		return signingKey{}, errs.ErrNoSigningKey
+	}
+	return r.keys[len(r.keys)-1], nil
+}
+
+// lookup возвращает ключ для проверки подписи, если он ещё действует.
+// Незнакомый kid мог появиться после ротации на другом экземпляре, поэтому
+// каталог перечитывается, но не чаще раза в reloadInterval.
+func (r *KeyRing) lookup(kid string) (signingKey, bool) {
+	key, active, found := r.find(kid)
+	if found || !r.reloadDue() {
+		return key, active
+	}
+	if err := r.Load(); err != nil {
+		r.logger.Errorf("JWT: не удалось перечитать ключи подписи: %v", err)
+		return signingKey{}, false
+	}
+	key, active, _ = r.find(kid)
+	return key, active
+}
+
+func (r *KeyRing) find(kid string) (key signingKey, active, found bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for i, key := range r.keys {
+		if key.kid == kid {
+			return key, r.active(i), true
+		}
+	}
+	return signingKey{}, false, false
+}
+
+// reloadDue отмечает перечитывание и сообщает, можно ли его выполнить.
+func (r *KeyRing) reloadDue() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.lastReload) < reloadInterval {
+		return false
+	}
+	r.lastReload = time.Now()
+	return true
+}
+
+// legacyCutoff — момент, после которого токены HS256 больше не
+// принимаются: срок действия токена после появления первого ключа. Все
+// токены, выданные по JWT_SECRET до перехода на ключи, к этому времени
+// истекли.
+func (r *KeyRing) legacyCutoff() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.keys) == 0 {
+		return time.Time{}
+	}
+	return r.keys[0].created.Add(tokenTTL)
+}
+
+// active сообщает, принимается ли ключ i: он текущий или заменён не
+// раньше чем overlap назад.
+func (r *KeyRing) active(i int) bool {
+	if i == len(r.keys)-1 {
+		return true
+	}
+	return time.Since(r.keys[i+1].created) < r.overlap
+}
+
+// JWKS возвращает открытые части действующих ключей.
+func (r *KeyRing) JWKS() models.JWKSet {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	set := models.JWKSet{Keys: []models.JWK{}}
+	for i, key := range r.keys {
+		if r.active(i) {
+			set.Keys = append(set.Keys, publicJWK(key))
+		}
+	}
+	return set
+}
+
+func publicJWK(key signingKey) models.JWK {
+	jwk := models.JWK{Kid: key.kid, Alg: key.alg, Use: "sig"}
+	switch public := key.private.Public().(type) {
+	case ed25519.PublicKey:
+		jwk.Kty, jwk.Crv = "OKP", "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	}
+	return jwk
+}
+
+func readKey(file string) (signingKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return signingKey{}, err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return signingKey{}, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return signingKey{}, errs.ErrSigningKeyFormat
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return signingKey{}, err
+	}
+
+	key := signingKey{
+		kid:     strings.TrimSuffix(filepath.Base(file), ".pem"),
+		created: info.ModTime(),
+	}
+	stamp, _, _ := strings.Cut(key.kid, "-")
+	if created, err := time.Parse(kidTimeLayout, stamp); err == nil {
+		key.created = created
+	}
+	switch private := parsed.(type) {
+	case ed25519.PrivateKey:
+		key.alg, key.private = AlgEdDSA, private
+	case *rsa.PrivateKey:
+		key.alg, key.private = AlgRS256, private
+	default:
+		return signingKey{}, errs.ErrSigningKeyFormat
+	}
+	return key, nil
+}
+
+func generateKey(alg string) (crypto.Signer, error) {
+	switch alg {
+	case AlgEdDSA:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		return private, err
+	case AlgRS256:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		return nil, errs.ErrUnsupportedSignatureMethod
+	}
+}
+
+// NewKeyRing загружает ключи из cfg.JWT_KEYS_DIR и создаёт первый ключ,
+// если каталог пуст. Возвращает nil, если каталог не задан.
+func NewKeyRing(cfg *config.Config, log *zap.SugaredLogger) *KeyRing {
+	if cfg.JWT_KEYS_DIR == "" {
+		return nil
+	}
+
+	r := &KeyRing{
+		dir:         cfg.JWT_KEYS_DIR,
+		alg:         cfg.JWT_ALG,
+		rotateEvery: time.Duration(cfg.JWT_KEY_ROTATION_DAYS) * 24 * time.Hour,
+		overlap:     time.Duration(cfg.JWT_KEY_OVERLAP_DAYS) * 24 * time.Hour,
+		logger:      log,
+	}
+	if err := r.RotateIfDue(); err != nil {
+		log.Fatalf("Не удалось загрузить ключи подписи JWT: %v", err)
+	}
+	return r
+}
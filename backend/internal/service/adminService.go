@@ -0,0 +1,143 @@
+package service
+
+import (
+	errs "sentimenta/internal/errors"
+	m "sentimenta/internal/models"
+	repo "sentimenta/internal/repository"
+	"sentimenta/internal/ws"
+	"slices"
+	"time"
+
+	"github.com/jinzhu/copier"
+	"go.uber.org/zap"
+)
+
+var roles = []string{m.RoleUser, m.RoleAdmin}
+
+type adminService struct {
+	userRepo  repo.UserRepository
+	statsRepo repo.StatsRepository
+	connMgr   *ws.ConnectionManager
+	logger    *zap.SugaredLogger
+}
+
+func (s *adminService) SearchUsers(query string, page, limit int) (m.Page[m.UserAdmin], error) {
+	users, total, err := s.userRepo.SearchUsers(query, page, limit)
+	if err != nil {
+		return m.Page[m.UserAdmin]{}, err
+	}
+
+	items := make([]m.UserAdmin, 0, len(users))
+	if err := copier.Copy(&items, users); err != nil {
+		return m.Page[m.UserAdmin]{}, err
+	}
+	return m.Page[m.UserAdmin]{
+		Items: items,
+		Page:  page,
+		Limit: limit,
+		Total: total,
+	}, nil
+}
+
+func (s *adminService) GetUser(userID string) (m.UserAdmin, error) {
+	user, err := s.userRepo.GetUser(userID)
+	if err != nil {
+		return m.UserAdmin{}, err
+	}
+	return toUserAdmin(user)
+}
+
+// SetDisabled блокирует или разблокирует пользователя. Заблокированный
+// пользователь сразу теряет доступ: его токены отклоняются в CheckSession.
+func (s *adminService) SetDisabled(adminID, userID string, disabled bool) (m.UserAdmin, error) {
+	if disabled && adminID == userID {
+		return m.UserAdmin{}, errs.ErrAdminSelf
+	}
+	if err := s.update(userID, map[string]any{"disabled": disabled}); err != nil {
+		return m.UserAdmin{}, err
+	}
+	if disabled {
+		s.connMgr.Close(userID)
+	}
+	s.logger.Infof("Администратор %s изменил блокировку пользователя %s: %v", adminID, userID, disabled)
+	return s.GetUser(userID)
+}
+
+// ForceLogout отзывает все выданные пользователю токены.
+func (s *adminService) ForceLogout(userID string) error {
+	if err := s.update(userID, map[string]any{"tokens_revoked_at": time.Now()}); err != nil {
+		return err
+	}
+	s.connMgr.Close(userID)
+	return nil
+}
+
+func (s *adminService) SetRole(adminID, userID, role string) (m.UserAdmin, error) {
+	if !slices.Contains(roles, role) {
+		return m.UserAdmin{}, errs.ErrUnknownRole
+	}
+	if adminID == userID && role != m.RoleAdmin {
+		return m.UserAdmin{}, errs.ErrAdminSelf
+	}
+	if err := s.update(userID, map[string]any{"role": role}); err != nil {
+		return m.UserAdmin{}, err
+	}
+	s.logger.Infof("Администратор %s назначил пользователю %s роль %s", adminID, userID, role)
+	return s.GetUser(userID)
+}
+
+// SetUseAI отключает или снова разрешает ИИ для пользователя. Флаг
+// отдельный от use_ai, который пользователь меняет сам, поэтому снять
+// блокировку может только администратор.
+func (s *adminService) SetUseAI(adminID, userID string, useAI bool) (m.UserAdmin, error) {
+	if err := s.update(userID, map[string]any{"ai_disabled_by_admin": !useAI}); err != nil {
+		return m.UserAdmin{}, err
+	}
+	s.logger.Infof("Администратор %s изменил доступ к ИИ пользователя %s: %v", adminID, userID, useAI)
+	return s.GetUser(userID)
+}
+
+func (s *adminService) GetSystemStats() (m.SystemStats, error) {
+	return s.statsRepo.GetSystemStats(time.Now().AddDate(0, 0, -7))
+}
+
+// PromoteAdmins назначает роль администратора пользователям из
+// ADMIN_USER_IDS. Вызывается при запуске, чтобы в системе был хотя бы
+// один администратор; снять роль с этих пользователей через API можно
+// только после удаления их из ADMIN_USER_IDS.
+func (s *adminService) PromoteAdmins(userIDs []string) {
+	for _, userID := range userIDs {
+		if err := s.update(userID, map[string]any{"role": m.RoleAdmin}); err != nil {
+			s.logger.Errorf("не удалось назначить администратора %s: %v", userID, err)
+		}
+	}
+}
+
+// update проверяет, что пользователь существует, и обновляет поля.
+func (s *adminService) update(userID string, updates map[string]any) error {
+	user, err := s.userRepo.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	return s.userRepo.UpdateUser(user.Uid, updates)
+}
+
+func toUserAdmin(user m.User) (m.UserAdmin, error) {
+	var result m.UserAdmin
+	err := copier.Copy(&result, user)
+	return result, err
+}
+
+func NewAdminService(
+	userRepo repo.UserRepository,
+	statsRepo repo.StatsRepository,
+	wsConnMgr *ws.ConnectionManager,
+	logger *zap.SugaredLogger,
+) AdminService {
+	return &adminService{
+		userRepo:  userRepo,
+		statsRepo: statsRepo,
+		connMgr:   wsConnMgr,
+		logger:    logger,
+	}
+}
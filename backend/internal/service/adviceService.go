@@ -1,16 +1,23 @@
 package service
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"net/http"
+	"sentimenta/internal/ai"
 	"sentimenta/internal/config"
+	errs "sentimenta/internal/errors"
+	"sentimenta/internal/i18n"
+	"sentimenta/internal/metrics"
 	"sentimenta/internal/models"
+	"sentimenta/internal/privacy"
+	"sentimenta/internal/prompts"
 	repo "sentimenta/internal/repository"
+	"sentimenta/internal/safety"
 	"sentimenta/internal/utils"
+	"sentimenta/internal/ws"
+	"slices"
 	"strconv"
 	"time"
 
@@ -22,10 +29,36 @@ type adviceService struct {
 	repo     repo.AdviceRepository
 	moodRepo repo.MoodRepository
 	userRepo repo.UserRepository
+	usage    AIUsageService
 	logger   *zap.SugaredLogger
 	config   *config.Config
+	metrics  *metrics.Prometheus
+	ai       *ai.Client
+	safety   *safety.Checker
+	redactor *privacy.Redactor
+	prompts  *prompts.Store
+	connMgr  *ws.ConnectionManager
 }
 
+// recentFeedbackLimit — сколько последних оценок передаётся модели.
+const recentFeedbackLimit = 5
+
+// Шкала оценки настроения, подставляется в промпт.
+const (
+	moodScoreMin = 1
+	moodScoreMax = 5
+)
+
+// promptLanguages — название языка совета для промпта.
+var promptLanguages = map[string]string{
+	i18n.RU: "Russian",
+	i18n.EN: "English",
+}
+
+// safetyModel записывается в Advice.Model, когда вместо ответа модели
+// пользователь получает контакты помощи.
+const safetyModel = "safety"
+
 func (s *adviceService) CreateAdvice(userID string, text string, date time.Time) (models.Advice, error) {
 	uidInt, err := strconv.Atoi(userID)
 	if err != nil {
@@ -53,8 +86,17 @@ func (s *adviceService) GetAdvice(userID string, date time.Time) (models.Advice,
 	return s.repo.GetAdvice(userID, date)
 }
 
-func (s *adviceService) GenerateAdvice(userID int, date time.Time) (models.Advice, error) {
+func (s *adviceService) GenerateAdvice(ctx context.Context, userID int, date time.Time) (models.Advice, error) {
 	uidStr := fmt.Sprintf("%v", userID)
+	user, err := s.userRepo.GetUser(uidStr)
+	if err != nil {
+		return models.Advice{}, err
+	}
+	// Блокировка администратора проверяется и здесь: совет мог быть
+	// запущен до неё, например по записи настроения
+	if user.AIDisabledByAdmin {
+		return models.Advice{}, errs.ErrAIDisabled
+	}
 	lastMoods, err := s.moodRepo.GetLastMoods(uidStr, 30)
 	if err != nil {
 		return models.Advice{}, err
@@ -64,23 +106,66 @@ func (s *adviceService) GenerateAdvice(userID int, date time.Time) (models.Advic
 		lastAdvice = models.Advice{Text: ""}
 	}
 
-	// Строим DTO
-	// Подготовим moods
-	var moods []models.MoodAdd
+	// Строим DTO: запись за date становится last_mood, более поздние отбрасываются
+	dateStr := date.Format("2006-01-02")
+	var lastMood models.PromptMood
+	var moods []models.PromptMood
+	var moodDates []time.Time
 	for _, m := range lastMoods {
-		moods = append(moods, models.MoodAdd{
+		mood := models.PromptMood{
 			Score:       m.Score,
 			Emotions:    m.Emotions,
 			Description: m.Description,
-			Date:        m.Date,
-		})
+		}
+		// Шифротекст и записи пользователя в режиме сквозного шифрования
+		// модели не передаются: совет строится по оценке и эмоциям
+		if m.E2E != nil || user.E2EEnabled {
+			mood.Description = ""
+		}
+		switch {
+		case m.Date.Format("2006-01-02") == dateStr:
+			lastMood = mood
+		case m.Date.After(date):
+		default:
+			moods = append(moods, mood)
+			moodDates = append(moodDates, m.Date)
+		}
+	}
+
+	// Язык совета: из настроек, иначе по самим записям. Короткие записи и
+	// записи из одних эмодзи язык не определяют, поэтому смотрим и старые
+	texts := []string{lastMood.Description, lastMood.Emotions}
+	for _, mood := range moods {
+		texts = append(texts, mood.Description, mood.Emotions)
 	}
+	locale := i18n.Resolve(user.Locale, append(texts, lastAdvice.Text)...)
 
-	var lastMood models.MoodAdd
+	layout := i18n.DateLayout(locale)
+	lastMood.Date = date.Format(layout)
+	for i := range moods {
+		moods[i].Date = moodDates[i].Format(layout)
+	}
+
+	advice := models.Advice{
+		UserID: userID,
+		Date:   date,
+		Model:  s.config.AI_MODEL,
+
+		PromptVersion: s.prompts.Assign(userID),
+	}
 
-	for i, m := range moods {
-		if m.Date.After(date) {
-			moods = append(moods[:i], moods[i+1:]...)
+	assessment := s.safety.Assess(ctx, userID, lastMood.Description+"\n"+lastMood.Emotions)
+	if assessment.Flagged {
+		resourcesLocale := assessment.Locale
+		if user.Locale != "" {
+			resourcesLocale = user.Locale
+		}
+		resources := s.safety.Resources(resourcesLocale)
+		advice.CrisisResources = &resources
+		if s.config.SAFETY_CRISIS_MODE != safety.ModeAppend {
+			advice.Text = resources.Message
+			advice.Model = safetyModel
+			return advice, nil
 		}
 	}
 
@@ -90,93 +175,346 @@ func (s *adviceService) GenerateAdvice(userID int, date time.Time) (models.Advic
 		Moods:          moods,
 	}
 
-	// Преобразуем в JSON (например, для отправки куда-то)
-	// systemPrompt := `Ты — умный и заботливый помощник. На основе настроения пользователя за последние дни и предыдущего совета, составь короткий полезный совет по улучшению настроения. Учитывай, если настроение падало или росло.`
+	if s.config.AI_FEEDBACK_ENABLED {
+		feedback, err := s.repo.GetRecentFeedback(uidStr, recentFeedbackLimit)
+		if err != nil {
+			s.logger.Errorf("не удалось получить оценки советов: %v", err)
+		}
+		for _, f := range feedback {
+			payload.RecentFeedback = append(payload.RecentFeedback, models.AdviceFeedback{
+				Advice:  f.Text,
+				Helpful: *f.Rating > 0,
+				Comment: f.FeedbackComment,
+			})
+		}
+	}
+
+	var redaction *privacy.Session
+	userName := user.Username
+	if s.config.PII_REDACTION_ENABLED && user.RedactPII {
+		redaction = s.redactor.NewSession(user.Username)
+		redactAdviceRequest(redaction, &payload)
+		userName = redaction.Redact(userName)
+	}
+
+	systemPrompt, err := s.prompts.Render(advice.PromptVersion, prompts.Vars{
+		Locale:   locale,
+		Language: promptLanguages[locale],
+		UserName: userName,
+		Style:    s.config.AI_ADVICE_STYLE,
+		ScaleMin: moodScoreMin,
+		ScaleMax: moodScoreMax,
+	})
+	if err != nil {
+		return models.Advice{}, err
+	}
 
 	userContentBytes, err := json.MarshalIndent(payload, "", "  ")
 	if err != nil {
 		return models.Advice{}, err
 	}
 
-	reqBody := utils.OpenRouterRequest{
-		Model: s.config.AI_MODEL,
-		Messages: []utils.OpenRouterMessage{
-			{
-				Role:    "system",
-				Content: s.config.SYSTEM_PROMPT,
-			},
-			{
-				Role:    "user",
-				Content: string(userContentBytes),
-			},
+	messages := []utils.OpenRouterMessage{
+		{
+			Role:    "system",
+			Content: systemPrompt,
+		},
+		{
+			Role:    "user",
+			Content: string(userContentBytes),
 		},
 	}
 
-	reqBytes, err := json.Marshal(reqBody)
+	reservation, err := s.usage.Reserve(userID, messages)
 	if err != nil {
 		return models.Advice{}, err
 	}
 
-	req, err := http.NewRequest("POST", "https://openrouter.ai/api/v1/chat/completions", bytes.NewBuffer(reqBytes))
+	// Пока пользователь подключён по WebSocket, совет отправляется ему
+	// частями. Когда он закроет все подключения, генерация отменяется.
+	// При кризисных формулировках ответ не стримится: его нужно сначала
+	// дополнить контактами помощи
+	var stream *adviceStream
+	if s.config.AI_STREAMING_ENABLED && !assessment.Flagged {
+		if wsCtx, ok := s.connMgr.Context(uidStr); ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithCancel(ctx)
+			defer cancel()
+			defer context.AfterFunc(wsCtx, cancel)()
+
+			stream = &adviceStream{service: s, userID: uidStr, date: dateStr, screen: s.safety.NewOutputStream()}
+			if redaction != nil {
+				stream.restorer = redaction.NewStreamRestorer()
+			}
+		}
+	}
+	// Уже показанный текст нужно отозвать, если совет не получился
+	defer func() {
+		if stream != nil && stream.sent && advice.Text == "" && ctx.Err() == nil {
+			s.publishAdvice(uidStr, ws.EventAdviceFailed, models.AdviceDelta{Date: dateStr})
+		}
+	}()
+
+	var completion ai.Completion
+	if stream != nil {
+		completion, err = s.ai.Stream(ctx, messages, stream.push)
+		if err == nil {
+			stream.flush()
+		}
+	} else {
+		completion, err = s.ai.Complete(ctx, messages)
+	}
+	s.usage.Settle(reservation, completion)
 	if err != nil {
 		return models.Advice{}, err
 	}
+	advice.Model = completion.Model
+
+	generatedText := completion.Text
+	if redaction != nil {
+		generatedText = redaction.Restore(generatedText)
+	}
+
+	// Ответ модели не сохраняется, если он не прошёл фильтр
+	if !s.safety.ScreenOutput(generatedText) {
+		s.metrics.SafetyOutputBlockedTotal.WithLabelValues(advice.Model).Inc()
+		if !assessment.Flagged {
+			return models.Advice{}, errs.ErrAdviceUnsafe
+		}
+		advice.Text = advice.CrisisResources.Message
+		advice.Model = safetyModel
+		return advice, nil
+	}
+
+	advice.Text = generatedText
+	if assessment.Flagged {
+		advice.Text += "\n\n" + advice.CrisisResources.Message
+	}
+	return advice, nil
+}
+
+// adviceStream пересылает пользователю части совета. Текст показывается
+// только после восстановления персональных данных и проверки фильтром
+// безопасности: фраза из запрещённого словаря не должна дойти до
+// пользователя даже частично.
+type adviceStream struct {
+	service  *adviceService
+	userID   string
+	date     string
+	restorer *privacy.StreamRestorer
+	screen   *safety.OutputStream
+	sent     bool
+}
+
+func (a *adviceStream) push(delta string) {
+	if a.restorer != nil {
+		delta = a.restorer.Push(delta)
+	}
+	a.publish(a.screen.Push(delta))
+}
+
+func (a *adviceStream) flush() {
+	if a.restorer != nil {
+		a.publish(a.screen.Push(a.restorer.Flush()))
+	}
+	a.publish(a.screen.Flush())
+}
+
+func (a *adviceStream) publish(text string) {
+	if text == "" {
+		return
+	}
+	a.sent = true
+	a.service.publishAdvice(a.userID, ws.EventAdviceDelta, models.AdviceDelta{Date: a.date, Text: text})
+}
+
+func (s *adviceService) publishAdvice(userID, eventType string, delta models.AdviceDelta) {
+	if err := s.connMgr.Publish(userID, ws.Event{Type: eventType, Data: delta}); err != nil && !errors.Is(err, ws.ErrNoConnections) {
+		s.logger.Warnf("не удалось отправить %s по WS: %v", eventType, err)
+	}
+}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+s.config.AI_API_KEY)
-	// req.Header.Set("HTTP-Referer", "https://your-site.com") // если надо
-	// req.Header.Set("X-Title", "MoodTrackerApp")             // если надо
+// redactAdviceRequest скрывает персональные данные во всех текстовых полях запроса к модели.
+func redactAdviceRequest(session *privacy.Session, payload *models.AdviceRequest) {
+	redactMood := func(mood *models.PromptMood) {
+		mood.Emotions = session.Redact(mood.Emotions)
+		mood.Description = session.Redact(mood.Description)
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	payload.PreviousAdvice = session.Redact(payload.PreviousAdvice)
+	redactMood(&payload.LastMood)
+	for i := range payload.Moods {
+		redactMood(&payload.Moods[i])
+	}
+	for i := range payload.RecentFeedback {
+		payload.RecentFeedback[i].Advice = session.Redact(payload.RecentFeedback[i].Advice)
+		payload.RecentFeedback[i].Comment = session.Redact(payload.RecentFeedback[i].Comment)
+	}
+}
+
+// RequestAdvice генерирует совет на дату по запросу пользователя и
+// сохраняет его как новую версию.
+func (s *adviceService) RequestAdvice(ctx context.Context, userID string, date time.Time) (models.Advice, error) {
+	user, err := s.userRepo.GetUser(userID)
 	if err != nil {
 		return models.Advice{}, err
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			s.logger.Errorf("Failed to close response body: %v", err)
-		}
-	}()
+	if !s.config.AI_ENABLED || !user.AIAllowed() {
+		return models.Advice{}, errs.ErrAIDisabled
+	}
 
-	bodyBytes, err := io.ReadAll(resp.Body)
+	advice, err := s.GenerateAdvice(ctx, user.Uid, date)
 	if err != nil {
 		return models.Advice{}, err
 	}
+	if err := s.repo.SaveAdvice(&advice); err != nil {
+		return models.Advice{}, err
+	}
+	return advice, nil
+}
 
-	// Можно логировать полный ответ
-	// Разбор результата
-	var result struct {
-		Choices []struct {
-			Message struct {
-				Content string `json:"content"`
-			} `json:"message"`
-		} `json:"choices"`
+// BackfillAdvice генерирует советы за дни в [from, to], в которые есть
+// записи, но ещё нет совета.
+func (s *adviceService) BackfillAdvice(ctx context.Context, userID string, from, to time.Time) ([]models.Advice, error) {
+	user, err := s.userRepo.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.config.AI_ENABLED || !user.AIAllowed() {
+		return nil, errs.ErrAIDisabled
 	}
 
-	err = json.Unmarshal(bodyBytes, &result)
+	moods, err := s.moodRepo.GetMoods(userID)
+	if err != nil {
+		return nil, err
+	}
+	advices, err := s.repo.GetAdvices(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(map[string]bool, len(advices))
+	for _, advice := range advices {
+		done[advice.Date.Format("2006-01-02")] = true
+	}
+	slices.SortFunc(moods, func(a, b models.Mood) int {
+		return a.Date.Compare(b.Date)
+	})
+
+	var created []models.Advice
+	for _, mood := range moods {
+		date := mood.Date.Format("2006-01-02")
+		if done[date] || mood.Date.Before(from) || mood.Date.After(to) {
+			continue
+		}
+		done[date] = true
+
+		advice, err := s.GenerateAdvice(ctx, user.Uid, mood.Date)
+		if err != nil {
+			return created, err
+		}
+		if err := s.repo.SaveAdvice(&advice); err != nil {
+			return created, err
+		}
+		created = append(created, advice)
+	}
+	return created, nil
+}
+
+func (s *adviceService) RegenerateAdvice(ctx context.Context, userID, adviceID string) (models.Advice, error) {
+	advice, err := s.repo.GetAdviceByID(userID, adviceID)
 	if err != nil {
 		return models.Advice{}, err
 	}
+	return s.RequestAdvice(ctx, userID, advice.Date)
+}
 
-	if len(result.Choices) == 0 {
-		return models.Advice{}, fmt.Errorf("AI вернул пустой результат")
+func (s *adviceService) GetAdviceHistory(userID, adviceID string, page, limit int) (models.Page[models.AdviceVersion], error) {
+	advice, err := s.repo.GetAdviceByID(userID, adviceID)
+	if err != nil {
+		return models.Page[models.AdviceVersion]{}, err
 	}
 
-	generatedText := result.Choices[0].Message.Content
+	versions, total, err := s.repo.GetAdviceVersions(advice.UserID, advice.Uid, page, limit)
+	if err != nil {
+		return models.Page[models.AdviceVersion]{}, err
+	}
 
-	// Сохраняем результат как Advice
-	advice := models.Advice{
-		UserID: userID,
-		Date:   date,
-		Text:   generatedText,
+	return models.Page[models.AdviceVersion]{
+		Items: versions,
+		Page:  page,
+		Limit: limit,
+		Total: total,
+	}, nil
+}
+
+func (s *adviceService) RateAdvice(userID, adviceID string, rating int16, comment string) (models.Advice, error) {
+	if rating != models.RatingThumbsUp && rating != models.RatingThumbsDown {
+		return models.Advice{}, errs.ErrAdviceRating
+	}
+	if len([]rune(comment)) > s.config.ADVICE_FEEDBACK_LENGTH_MAX {
+		return models.Advice{}, errs.ErrAdviceFeedbackLength
 	}
 
+	advice, err := s.repo.GetAdviceByID(userID, adviceID)
+	if err != nil {
+		return models.Advice{}, err
+	}
+
+	advice.Rating = &rating
+	advice.FeedbackComment = comment
+	previous, err := s.repo.RateAdvice(&advice)
+	if err != nil {
+		return models.Advice{}, err
+	}
+
+	// Считаются только новые оценки и смена оценки, а не повторная
+	// отправка той же или правка комментария
+	if previous != nil && *previous == rating {
+		return advice, nil
+	}
+	ratingLabel := "up"
+	if rating == models.RatingThumbsDown {
+		ratingLabel = "down"
+	}
+	s.metrics.AdviceFeedbackTotal.WithLabelValues(advice.Model, advice.PromptVersion, ratingLabel).Inc()
+
 	return advice, nil
 }
 
+func (s *adviceService) GetFeedbackStats() ([]models.AdviceFeedbackStats, error) {
+	return s.repo.GetFeedbackStats()
+}
+
 func (s *adviceService) GetLastAdvice(userID string) (models.Advice, error) {
 	return s.repo.GetLastAdvice(userID)
 }
-func NewAdviceService(repo repo.AdviceRepository, moodRepo repo.MoodRepository, userRepo repo.UserRepository, config *config.Config, logger *zap.SugaredLogger) AdviceService {
-	return &adviceService{repo: repo, moodRepo: moodRepo, userRepo: userRepo, config: config, logger: logger}
+func NewAdviceService(
+	repo repo.AdviceRepository,
+	moodRepo repo.MoodRepository,
+	userRepo repo.UserRepository,
+	usage AIUsageService,
+	config *config.Config,
+	logger *zap.SugaredLogger,
+	metrics *metrics.Prometheus,
+	aiClient *ai.Client,
+	safetyChecker *safety.Checker,
+	redactor *privacy.Redactor,
+	prompts *prompts.Store,
+	connMgr *ws.ConnectionManager,
+) AdviceService {
+	return &adviceService{
+		repo:     repo,
+		moodRepo: moodRepo,
+		userRepo: userRepo,
+		usage:    usage,
+		config:   config,
+		logger:   logger,
+		metrics:  metrics,
+		ai:       aiClient,
+		safety:   safetyChecker,
+		redactor: redactor,
+		prompts:  prompts,
+		connMgr:  connMgr,
+	}
 }
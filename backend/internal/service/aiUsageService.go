@@ -0,0 +1,116 @@
+package service
+
+import (
+	"sentimenta/internal/ai"
+	"sentimenta/internal/config"
+	errs "sentimenta/internal/errors"
+	"sentimenta/internal/metrics"
+	m "sentimenta/internal/models"
+	repo "sentimenta/internal/repository"
+	"sentimenta/internal/utils"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// completionReserve — сколько токенов ответа резервируется до запроса.
+// Совет занимает несколько абзацев, настоящий расход станет известен
+// после ответа.
+const completionReserve = 1024
+
+type aiUsageService struct {
+	repo    repo.AIUsageRepository
+	config  *config.Config
+	metrics *metrics.Prometheus
+	logger  *zap.SugaredLogger
+}
+
+// Reserve проверяет лимиты токенов пользователя за сутки и месяц и
+// резервирует оценку расхода на запрос messages. Резерв учитывается в
+// лимитах, пока не вызван Settle, поэтому параллельные запросы не
+// проходят проверку по одному и тому же остатку.
+func (s *aiUsageService) Reserve(userID int, messages []utils.OpenRouterMessage) (m.AIUsage, error) {
+	now := time.Now().UTC()
+	reservation := m.AIUsage{
+		UserID: userID,
+		Day:    now.Truncate(24 * time.Hour),
+		Model:  s.config.AI_MODEL,
+	}
+
+	var quotas []m.AIQuota
+	if s.config.AI_QUOTA_DAILY_TOKENS > 0 {
+		quotas = append(quotas, m.AIQuota{Period: "day", Since: now, Limit: s.config.AI_QUOTA_DAILY_TOKENS})
+	}
+	if s.config.AI_QUOTA_MONTHLY_TOKENS > 0 {
+		monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		quotas = append(quotas, m.AIQuota{Period: "month", Since: monthStart, Limit: s.config.AI_QUOTA_MONTHLY_TOKENS})
+	}
+	if len(quotas) == 0 {
+		return reservation, nil
+	}
+
+	reservation.ReservedTokens = ai.EstimatePromptTokens(messages) + completionReserve
+	exceeded, err := s.repo.ReserveUsage(&reservation, quotas)
+	if err != nil {
+		return m.AIUsage{}, err
+	}
+	if exceeded != nil {
+		s.metrics.AIQuotaExceededTotal.WithLabelValues(exceeded.Period).Inc()
+		if exceeded.Period == "day" {
+			return m.AIUsage{}, errs.ErrAIQuotaDaily
+		}
+		return m.AIUsage{}, errs.ErrAIQuotaMonthly
+	}
+	return reservation, nil
+}
+
+// Settle снимает резерв и записывает настоящий расход completion. Его
+// нужно вызывать и после ошибки модели: оборвавшийся ответ тоже
+// оплачивается.
+func (s *aiUsageService) Settle(reservation m.AIUsage, completion ai.Completion) {
+	usage := s.usage(reservation.UserID, completion)
+	var err error
+	if reservation.ReservedTokens > 0 {
+		err = s.repo.SettleUsage(&reservation, &usage)
+	} else if usage.Requests > 0 {
+		err = s.repo.AddUsage(&usage)
+	}
+	if err != nil {
+		s.logger.Errorf("Пользователь %d: не удалось сохранить расход токенов: %v", reservation.UserID, err)
+	}
+}
+
+// RecordUsage записывает расход запроса без резерва, например проверки
+// записи моделью.
+func (s *aiUsageService) RecordUsage(userID int, completion ai.Completion) {
+	s.Settle(m.AIUsage{UserID: userID}, completion)
+}
+
+func (s *aiUsageService) usage(userID int, completion ai.Completion) m.AIUsage {
+	usage := m.AIUsage{
+		UserID:           userID,
+		Day:              time.Now().UTC().Truncate(24 * time.Hour),
+		Model:            completion.Model,
+		PromptTokens:     completion.Usage.PromptTokens,
+		CompletionTokens: completion.Usage.CompletionTokens,
+		Cost:             completion.Usage.Cost,
+	}
+	if completion.Usage.Tokens() > 0 {
+		usage.Requests = 1
+	}
+	return usage
+}
+
+func NewAIUsageService(
+	repo repo.AIUsageRepository,
+	config *config.Config,
+	metrics *metrics.Prometheus,
+	logger *zap.SugaredLogger,
+) AIUsageService {
+	return &aiUsageService{
+		repo:    repo,
+		config:  config,
+		metrics: metrics,
+		logger:  logger,
+	}
+}
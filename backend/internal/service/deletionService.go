@@ -0,0 +1,309 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sentimenta/internal/config"
+	errs "sentimenta/internal/errors"
+	"sentimenta/internal/hash"
+	"sentimenta/internal/i18n"
+	"sentimenta/internal/mail"
+	m "sentimenta/internal/models"
+	repo "sentimenta/internal/repository"
+	"sentimenta/internal/ws"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type deletionService struct {
+	repo      repo.DeletionRepository
+	userRepo  repo.UserRepository
+	passwords *hash.Registry
+	mailer    mail.Mailer
+	connMgr   *ws.ConnectionManager
+	config    *config.Config
+	logger    *zap.SugaredLogger
+}
+
+// deletionCodeTTL — сколько действует код подтверждения удаления.
+const deletionCodeTTL = 15 * time.Minute
+
+// deletionCodeAttempts — сколько раз можно ошибиться в коде, после чего
+// нужно запросить новый.
+const deletionCodeAttempts = 5
+
+// deletionCodeMessages — текст письма с кодом: код, срок в минутах.
+var deletionCodeMessages = map[string]struct{ subject, text string }{
+	i18n.RU: {
+		subject: "Удаление аккаунта Sentimenta",
+		text: `Код для подтверждения удаления аккаунта: %s
+
+Код действует %d мин. Если вы не запрашивали удаление, не сообщайте код никому и смените пароль.
+`,
+	},
+	i18n.EN: {
+		subject: "Sentimenta account deletion",
+		text: `Your code to confirm account deletion: %s
+
+The code is valid for %d minutes. If you did not request deletion, do not share this code with anyone and change your password.
+`,
+	},
+}
+
+// SendDeletionCode отправляет на почту аккаунта код, которым можно
+// подтвердить удаление вместо пароля. Письмо доказывает доступ к почте,
+// а не только к открытой сессии.
+func (s *deletionService) SendDeletionCode(userID, lang string) error {
+	user, err := s.userRepo.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	code, err := randomCode()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := s.repo.SaveDeletionCode(&m.DeletionCode{
+		UserID:    user.Uid,
+		CodeHash:  hashSecret(strconv.Itoa(user.Uid) + ":" + code),
+		ExpiresAt: now.Add(deletionCodeTTL),
+		CreatedAt: now,
+	}); err != nil {
+		return err
+	}
+
+	if user.Locale != "" {
+		lang = user.Locale
+	}
+	msg, ok := deletionCodeMessages[lang]
+	if !ok {
+		msg = deletionCodeMessages[i18n.Default]
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return s.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: msg.subject,
+		Text:    fmt.Sprintf(msg.text, code, int(deletionCodeTTL.Minutes())),
+	})
+}
+
+// RequestDeletion подтверждает личность пользователя и назначает удаление
+// аккаунта через ACCOUNT_DELETION_GRACE_DAYS дней. Без отсрочки аккаунт
+// удаляется сразу.
+func (s *deletionService) RequestDeletion(userID string, req m.AccountDeleteReq) (m.AccountDeletion, error) {
+	user, err := s.userRepo.GetUser(userID)
+	if err != nil {
+		return m.AccountDeletion{}, err
+	}
+	if err := s.reauthenticate(user, req); err != nil {
+		return m.AccountDeletion{}, err
+	}
+
+	_, err = s.repo.GetScheduledDeletion(user.Uid)
+	if err == nil {
+		return m.AccountDeletion{}, errs.ErrDeletionScheduled
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return m.AccountDeletion{}, err
+	}
+
+	receiptID, err := newReceiptID()
+	if err != nil {
+		return m.AccountDeletion{}, err
+	}
+
+	now := time.Now()
+	deletion := m.AccountDeletion{
+		ReceiptID:   receiptID,
+		UserID:      user.Uid,
+		Status:      m.DeletionScheduled,
+		RequestedAt: now,
+		ScheduledAt: now.AddDate(0, 0, s.config.ACCOUNT_DELETION_GRACE_DAYS),
+	}
+	if err := s.repo.CreateDeletion(&deletion); err != nil {
+		return m.AccountDeletion{}, err
+	}
+	s.logger.Infof("Удаление аккаунта %d назначено на %s, квитанция %s", user.Uid, deletion.ScheduledAt.Format(time.RFC3339), receiptID)
+
+	if s.config.ACCOUNT_DELETION_GRACE_DAYS <= 0 {
+		if err := s.complete(&deletion); err != nil {
+			return m.AccountDeletion{}, err
+		}
+	}
+	return deletion, nil
+}
+
+func (s *deletionService) CancelDeletion(userID string) (m.AccountDeletion, error) {
+	uidInt, err := strconv.Atoi(userID)
+	if err != nil {
+		return m.AccountDeletion{}, err
+	}
+
+	deletion, err := s.repo.GetScheduledDeletion(uidInt)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return m.AccountDeletion{}, errs.ErrDeletionNotScheduled
+		}
+		return m.AccountDeletion{}, err
+	}
+
+	now := time.Now()
+	deletion.Status = m.DeletionCancelled
+	deletion.CancelledAt = &now
+	if err := s.repo.CancelDeletion(&deletion); err != nil {
+		return m.AccountDeletion{}, err
+	}
+	s.logger.Infof("Удаление аккаунта %d отменено, квитанция %s", uidInt, deletion.ReceiptID)
+	return deletion, nil
+}
+
+func (s *deletionService) GetReceipt(receiptID string) (m.AccountDeletion, error) {
+	return s.repo.GetDeletionByReceipt(receiptID)
+}
+
+func (s *deletionService) GetDeletions(page, limit int) (m.Page[m.AccountDeletion], error) {
+	deletions, total, err := s.repo.GetDeletions(page, limit)
+	if err != nil {
+		return m.Page[m.AccountDeletion]{}, err
+	}
+	return m.Page[m.AccountDeletion]{
+		Items: deletions,
+		Page:  page,
+		Limit: limit,
+		Total: total,
+	}, nil
+}
+
+// PurgeDue удаляет аккаунты, у которых истекла отсрочка, и возвращает
+// количество удалённых.
+func (s *deletionService) PurgeDue() (int, error) {
+	deletions, err := s.repo.GetDueDeletions(time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	var errList []error
+	purged := 0
+	for i := range deletions {
+		if err := s.complete(&deletions[i]); err != nil {
+			errList = append(errList, err)
+			continue
+		}
+		purged++
+	}
+	return purged, errors.Join(errList...)
+}
+
+// RunPurger периодически вызывает PurgeDue, пока не отменён ctx.
+func (s *deletionService) RunPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.PurgeDue(); err != nil {
+			s.logger.Errorf("не удалось удалить аккаунты: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *deletionService) complete(deletion *m.AccountDeletion) error {
+	if err := s.repo.CompleteDeletion(deletion); err != nil {
+		return err
+	}
+	s.connMgr.Close(strconv.Itoa(deletion.UserID))
+	s.logger.Infof("Аккаунт %d удалён, квитанция %s", deletion.UserID, deletion.ReceiptID)
+	return nil
+}
+
+// reauthenticate требует код из письма или пароль. У аккаунтов без пароля
+// (вход через OAuth, passkey или ссылку) остаётся только код: почту
+// аккаунта знает любой, у кого есть сессия.
+func (s *deletionService) reauthenticate(user m.User, req m.AccountDeleteReq) error {
+	if req.Code != "" {
+		return s.checkDeletionCode(user.Uid, req.Code)
+	}
+	if user.PasswordHash != nil {
+		if req.Password == "" {
+			return errs.ErrDeletionReauth
+		}
+		ok, _, err := s.passwords.Verify(req.Password, *user.PasswordHash)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errs.ErrWrongPassword
+		}
+		return nil
+	}
+
+	return errs.ErrDeletionReauth
+}
+
+// checkDeletionCode проверяет код и сразу его использует.
+func (s *deletionService) checkDeletionCode(userID int, code string) error {
+	stored, err := s.repo.GetDeletionCode(userID, time.Now())
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errs.ErrDeletionCodeInvalid
+	}
+	if err != nil {
+		return err
+	}
+	if stored.Attempts >= deletionCodeAttempts {
+		return errs.ErrDeletionCodeInvalid
+	}
+	hashed := hashSecret(strconv.Itoa(userID) + ":" + strings.TrimSpace(code))
+	if subtle.ConstantTimeCompare([]byte(hashed), []byte(stored.CodeHash)) != 1 {
+		if err := s.repo.IncrementDeletionCodeAttempts(stored.Uid); err != nil {
+			return err
+		}
+		return errs.ErrDeletionCodeInvalid
+	}
+	err = s.repo.UseDeletionCode(stored.Uid)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errs.ErrDeletionCodeInvalid
+	}
+	return err
+}
+
+func newReceiptID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func NewDeletionService(
+	repo repo.DeletionRepository,
+	userRepo repo.UserRepository,
+	passwords *hash.Registry,
+	mailer mail.Mailer,
+	wsConnMgr *ws.ConnectionManager,
+	cfg *config.Config,
+	logger *zap.SugaredLogger,
+) DeletionService {
+	return &deletionService{
+		repo:      repo,
+		userRepo:  userRepo,
+		passwords: passwords,
+		mailer:    mailer,
+		connMgr:   wsConnMgr,
+		config:    cfg,
+		logger:    logger,
+	}
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	m "sentimenta/internal/models"
+	repo "sentimenta/internal/repository"
+	"time"
+
+	"github.com/jinzhu/copier"
+)
+
+// exportPageLimit — размер страницы при выгрузке постраничных данных.
+const exportPageLimit = 100
+
+type exportService struct {
+	userRepo          repo.UserRepository
+	moodRepo          repo.MoodRepository
+	adviceRepo        repo.AdviceRepository
+	securityEventRepo repo.SecurityEventRepository
+}
+
+// ExportUser собирает профиль, записи, советы с историей версий и журнал
+// безопасности пользователя.
+func (s *exportService) ExportUser(userID string) (m.UserExport, error) {
+	user, err := s.userRepo.GetUser(userID)
+	if err != nil {
+		return m.UserExport{}, err
+	}
+
+	export := m.UserExport{ExportedAt: time.Now()}
+	if err := copier.Copy(&export.User, user); err != nil {
+		return m.UserExport{}, err
+	}
+
+	if export.Moods, err = s.moodRepo.GetMoods(userID); err != nil {
+		return m.UserExport{}, err
+	}
+
+	advices, err := s.adviceRepo.GetAdvices(userID)
+	if err != nil {
+		return m.UserExport{}, err
+	}
+	for _, advice := range advices {
+		versions, err := collectPages(func(page int) ([]m.AdviceVersion, int64, error) {
+			return s.adviceRepo.GetAdviceVersions(user.Uid, advice.Uid, page, exportPageLimit)
+		})
+		if err != nil {
+			return m.UserExport{}, err
+		}
+		export.Advices = append(export.Advices, m.AdviceExport{Advice: advice, Versions: versions})
+	}
+
+	filter := m.SecurityEventFilter{UserID: &user.Uid}
+	export.SecurityEvents, err = collectPages(func(page int) ([]m.SecurityEvent, int64, error) {
+		return s.securityEventRepo.GetEvents(filter, page, exportPageLimit)
+	})
+	if err != nil {
+		return m.UserExport{}, err
+	}
+	return export, nil
+}
+
+// collectPages читает все страницы постраничного запроса.
+func collectPages[T any](fetch func(page int) ([]T, int64, error)) ([]T, error) {
+	var result []T
+	for page := 1; ; page++ {
+		items, total, err := fetch(page)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, items...)
+		if len(items) == 0 || int64(len(result)) >= total {
+			return result, nil
+		}
+	}
+}
+
+func NewExportService(
+	userRepo repo.UserRepository,
+	moodRepo repo.MoodRepository,
+	adviceRepo repo.AdviceRepository,
+	securityEventRepo repo.SecurityEventRepository,
+) ExportService {
+	return &exportService{
+		userRepo:          userRepo,
+		moodRepo:          moodRepo,
+		adviceRepo:        adviceRepo,
+		securityEventRepo: securityEventRepo,
+	}
+}
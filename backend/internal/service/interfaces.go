@@ -1,7 +1,11 @@
 package service
 
 import (
+	"context"
+	"io"
+	"sentimenta/internal/ai"
 	m "sentimenta/internal/models"
+	"sentimenta/internal/utils"
 	"time"
 )
 
@@ -10,18 +14,20 @@ import (
 type UserService interface {
 	CreateUser(username, email string, password *string, timezone string) (m.User, error)
 	GetUser(id string) (m.User, error)
-	UpdateUser(userID string, u m.UserUpdateReq) (m.User, error)
+	UpdateUser(userID string, u m.UserUpdateReq, event m.SecurityEvent) (m.User, error)
 	DeleteUser(id string) error
-	ChangePassword(userID, password, newPassword string) error
+	ChangePassword(userID, password, newPassword string, event m.SecurityEvent) error
 	Authenticate(email, password string) (m.User, error)
 	GetUserByEmail(email string) (m.User, error)
+	CheckSession(userID string, issuedAt time.Time) (m.User, error)
+	ResetPassword(userID, newPassword string) error
 }
 
 type MoodService interface {
 	GetMoods(userID string) ([]m.Mood, error)
-	CreateMood(userID string, score int16, emotions, description string, date time.Time) (m.Mood, error)
-	UpdateMood(userID string, m *m.Mood) error
-	DeleteMood(id string) error
+	CreateMood(ctx context.Context, userID string, score int16, emotions, description string, date time.Time, e2e *m.E2EMetadata) (m.Mood, error)
+	UpdateMood(ctx context.Context, userID string, m *m.Mood) error
+	DeleteMood(userID, id string) error
 }
 
 type AdviceService interface {
@@ -29,5 +35,65 @@ type AdviceService interface {
 	GetAdvices(userID string) ([]m.Advice, error)
 	CreateAdvice(userID string, text string, date time.Time) (m.Advice, error)
 	GetLastAdvice(userID string) (m.Advice, error)
-	GenerateAdvice(userID int, date time.Time) (m.Advice, error)
+	GenerateAdvice(ctx context.Context, userID int, date time.Time) (m.Advice, error)
+	RequestAdvice(ctx context.Context, userID string, date time.Time) (m.Advice, error)
+	RegenerateAdvice(ctx context.Context, userID, adviceID string) (m.Advice, error)
+	GetAdviceHistory(userID, adviceID string, page, limit int) (m.Page[m.AdviceVersion], error)
+	RateAdvice(userID, adviceID string, rating int16, comment string) (m.Advice, error)
+	GetFeedbackStats() ([]m.AdviceFeedbackStats, error)
+	BackfillAdvice(ctx context.Context, userID string, from, to time.Time) ([]m.Advice, error)
+}
+
+type DeletionService interface {
+	SendDeletionCode(userID, lang string) error
+	RequestDeletion(userID string, req m.AccountDeleteReq) (m.AccountDeletion, error)
+	CancelDeletion(userID string) (m.AccountDeletion, error)
+	GetReceipt(receiptID string) (m.AccountDeletion, error)
+	GetDeletions(page, limit int) (m.Page[m.AccountDeletion], error)
+	PurgeDue() (int, error)
+	RunPurger(ctx context.Context, interval time.Duration)
+}
+
+type SecurityEventService interface {
+	Record(e m.SecurityEvent)
+	RecordOAuthLogin(e m.SecurityEvent, provider string)
+	GetUserEvents(userID, page, limit int) (m.Page[m.SecurityEvent], error)
+	GetEvents(filter m.SecurityEventFilter, page, limit int) (m.Page[m.SecurityEvent], error)
+}
+
+type AdminService interface {
+	SearchUsers(query string, page, limit int) (m.Page[m.UserAdmin], error)
+	GetUser(userID string) (m.UserAdmin, error)
+	SetDisabled(adminID, userID string, disabled bool) (m.UserAdmin, error)
+	ForceLogout(userID string) error
+	SetRole(adminID, userID, role string) (m.UserAdmin, error)
+	SetUseAI(adminID, userID string, useAI bool) (m.UserAdmin, error)
+	GetSystemStats() (m.SystemStats, error)
+	PromoteAdmins(userIDs []string)
+}
+
+type ExportService interface {
+	ExportUser(userID string) (m.UserExport, error)
+}
+
+type PasskeyService interface {
+	BeginRegistration(userID string) (m.PasskeyOptions, error)
+	FinishRegistration(userID, sessionID, name string, body io.Reader) (m.Passkey, error)
+	BeginLogin() (m.PasskeyOptions, error)
+	FinishLogin(sessionID string, body io.Reader) (m.User, error)
+	GetPasskeys(userID string) ([]m.Passkey, error)
+	DeletePasskey(userID string, id int) error
+}
+
+// AIUsageService учитывает расход модели пользователями и следит за
+// лимитами AI_QUOTA_DAILY_TOKENS и AI_QUOTA_MONTHLY_TOKENS.
+type AIUsageService interface {
+	Reserve(userID int, messages []utils.OpenRouterMessage) (m.AIUsage, error)
+	Settle(reservation m.AIUsage, completion ai.Completion)
+	RecordUsage(userID int, completion ai.Completion)
+}
+
+type MagicLinkService interface {
+	RequestLink(email, lang string) (string, error)
+	Redeem(req m.MagicLinkVerifyReq, binding string) (m.User, bool, error)
 }
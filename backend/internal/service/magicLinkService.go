@@ -0,0 +1,267 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"sentimenta/internal/config"
+	errs "sentimenta/internal/errors"
+	"sentimenta/internal/i18n"
+	"sentimenta/internal/mail"
+	m "sentimenta/internal/models"
+	"sentimenta/internal/repository"
+	"sentimenta/internal/utils"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// magicLinkCodeAttempts — сколько раз можно ошибиться в коде из письма,
+// прежде чем ссылка перестанет действовать.
+const magicLinkCodeAttempts = 5
+
+type magicLinkService struct {
+	repo     repository.MagicLinkRepository
+	userRepo repository.UserRepository
+	mailer   mail.Mailer
+	config   *config.Config
+	logger   *zap.SugaredLogger
+}
+
+// RequestLink отправляет на почту ссылку и код для входа и возвращает
+// значение привязки, которое нужно сохранить в браузере. Подтвердить вход
+// можно только из браузера с этой привязкой.
+//
+// Ответ не зависит от того, есть ли аккаунт с такой почтой: ни по
+// содержанию, ни по времени. Поэтому ссылка создаётся и письмо уходит уже
+// после ответа. Если аккаунта нет и регистрация отключена, письмо не
+// отправляется.
+func (s *magicLinkService) RequestLink(email, lang string) (string, error) {
+	if !s.config.MAGIC_LINK_ENABLED {
+		return "", errs.ErrMagicLinkDisabled
+	}
+	email = strings.TrimSpace(email)
+	if !utils.IsValidEmail(email) || strings.ContainsAny(email, "\r\n") {
+		return "", errs.ErrEmailValidation
+	}
+
+	binding, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	go func() {
+		if err := s.sendLink(email, lang, binding); err != nil {
+			s.logger.Errorf("Не удалось отправить ссылку для входа: %v", err)
+		}
+	}()
+	return binding, nil
+}
+
+// sendLink создаёт ссылку, привязанную к binding, и отправляет её на почту.
+func (s *magicLinkService) sendLink(email, lang, binding string) error {
+	user, err := s.userRepo.GetUserByEmail(email)
+	if errors.Is(err, gorm.ErrRecordNotFound) && !s.config.REGISTRATION_ENABLED {
+		return nil
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	// Язык письма — из настроек пользователя, для новых — язык запроса
+	if err == nil && user.Locale != "" {
+		lang = user.Locale
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+	code, err := randomCode()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	link := m.MagicLink{
+		Email:       email,
+		TokenHash:   hashSecret(token),
+		CodeHash:    hashSecret(email + ":" + code),
+		BindingHash: hashSecret(binding),
+		ExpiresAt:   now.Add(time.Duration(s.config.MAGIC_LINK_TTL_MINUTES) * time.Minute),
+		CreatedAt:   now,
+	}
+	if err := s.repo.CreateLink(&link); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return s.mailer.Send(ctx, s.message(email, token, code, lang))
+}
+
+// Redeem проверяет токен или код, отмечает ссылку использованной и
+// возвращает пользователя. Если аккаунта ещё нет, он создаётся в той же
+// транзакции, и второй результат равен true. Для заблокированного
+// пользователя возвращается он же вместе с ErrUserDisabled.
+func (s *magicLinkService) Redeem(req m.MagicLinkVerifyReq, binding string) (m.User, bool, error) {
+	if !s.config.MAGIC_LINK_ENABLED {
+		return m.User{}, false, errs.ErrMagicLinkDisabled
+	}
+	if binding == "" {
+		return m.User{}, false, errs.ErrMagicLinkBinding
+	}
+
+	now := time.Now()
+	link, err := s.findLink(req, hashSecret(binding), now)
+	if err != nil {
+		return m.User{}, false, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(binding)), []byte(link.BindingHash)) != 1 {
+		return m.User{}, false, errs.ErrMagicLinkBinding
+	}
+
+	user, err := s.userRepo.GetUserByEmail(link.Email)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return m.User{}, false, err
+	}
+	if err == nil {
+		if err := s.useLink(link.Uid, now, nil); err != nil {
+			return m.User{}, false, err
+		}
+		if user.Disabled {
+			return *user, false, errs.ErrUserDisabled
+		}
+		return *user, false, nil
+	}
+
+	if !s.config.REGISTRATION_ENABLED {
+		return m.User{}, false, errs.ErrRegistrationDisabled
+	}
+	username, _, _ := strings.Cut(link.Email, "@")
+	created := m.User{
+		Username: username,
+		Email:    link.Email,
+		Timezone: req.Timezone,
+		Role:     m.RoleUser,
+	}
+	if err := s.useLink(link.Uid, now, &created); err != nil {
+		return m.User{}, false, err
+	}
+	return created, true, nil
+}
+
+// useLink отмечает ссылку использованной и, если newUser не nil, создаёт
+// пользователя. Ссылку, которую уже использовал параллельный запрос,
+// использовать нельзя.
+func (s *magicLinkService) useLink(id int, now time.Time, newUser *m.User) error {
+	err := s.repo.UseLink(id, now, newUser)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errs.ErrMagicLinkInvalid
+	}
+	return err
+}
+
+// findLink ищет ссылку по токену или по почте и коду. По коду ищется
+// только ссылка, запрошенная из этого браузера: иначе чужой запрос ссылки
+// на ту же почту подменял бы ссылку, к которой относится код.
+func (s *magicLinkService) findLink(req m.MagicLinkVerifyReq, bindingHash string, now time.Time) (m.MagicLink, error) {
+	if req.Token != "" {
+		link, err := s.repo.GetActiveLinkByToken(hashSecret(req.Token), now)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return m.MagicLink{}, errs.ErrMagicLinkInvalid
+		}
+		return link, err
+	}
+
+	email := strings.TrimSpace(req.Email)
+	if email == "" || req.Code == "" {
+		return m.MagicLink{}, errs.ErrMagicLinkInvalid
+	}
+	link, err := s.repo.GetActiveLinkByEmail(email, bindingHash, now)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return m.MagicLink{}, errs.ErrMagicLinkInvalid
+	}
+	if err != nil {
+		return m.MagicLink{}, err
+	}
+	if link.Attempts >= magicLinkCodeAttempts {
+		return m.MagicLink{}, errs.ErrMagicLinkInvalid
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(email+":"+strings.TrimSpace(req.Code))), []byte(link.CodeHash)) != 1 {
+		if err := s.repo.IncrementAttempts(link.Uid); err != nil {
+			return m.MagicLink{}, err
+		}
+		return m.MagicLink{}, errs.ErrMagicLinkInvalid
+	}
+	return link, nil
+}
+
+// magicLinkMessages — текст письма со ссылкой: ссылка, код, срок в минутах.
+var magicLinkMessages = map[string]struct{ subject, text string }{
+	i18n.RU: {
+		subject: "Вход в Sentimenta",
+		text: `Чтобы войти, откройте ссылку в том же браузере, где запрашивали вход:
+%s
+
+Или введите код: %s
+
+Ссылка и код действуют %d мин. и сработают один раз. Если вы не запрашивали вход, просто удалите это письмо.
+`,
+	},
+	i18n.EN: {
+		subject: "Sign in to Sentimenta",
+		text: `To sign in, open this link in the same browser you requested it from:
+%s
+
+Or enter the code: %s
+
+The link and code are valid for %d minutes and work once. If you did not request this, you can ignore this email.
+`,
+	},
+}
+
+func (s *magicLinkService) message(email, token, code, lang string) mail.Message {
+	link := s.config.MAGIC_LINK_URL + "?token=" + url.QueryEscape(token)
+	msg, ok := magicLinkMessages[lang]
+	if !ok {
+		msg = magicLinkMessages[i18n.Default]
+	}
+	return mail.Message{
+		To:      email,
+		Subject: msg.subject,
+		Text:    fmt.Sprintf(msg.text, link, code, s.config.MAGIC_LINK_TTL_MINUTES),
+	}
+}
+
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func randomCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func NewMagicLinkService(repo repository.MagicLinkRepository, userRepo repository.UserRepository, mailer mail.Mailer, cfg *config.Config, logger *zap.SugaredLogger) MagicLinkService {
+	return &magicLinkService{repo: repo, userRepo: userRepo, mailer: mailer, config: cfg, logger: logger}
+}
@@ -1,9 +1,13 @@
 package service
 
 import (
-	"encoding/json"
+	"context"
+	"encoding/base64"
+	"errors"
+	errs "sentimenta/internal/errors"
 	m "sentimenta/internal/models"
 	repo "sentimenta/internal/repository"
+	"sentimenta/internal/safety"
 	"sentimenta/internal/ws"
 	"strconv"
 	"time"
@@ -11,16 +15,22 @@ import (
 	"go.uber.org/zap"
 )
 
+const (
+	e2eKeyIDLengthMax = 64
+	e2eTagSize        = 16
+)
+
 type moodService struct {
 	repo       repo.MoodRepository
 	userRepo   repo.UserRepository
 	adviceRepo repo.AdviceRepository
 	adviceServ AdviceService
 	connMgr    *ws.ConnectionManager
+	safety     *safety.Checker
 	logger     *zap.SugaredLogger
 }
 
-func (s *moodService) CreateMood(userID string, score int16, emotions, description string, date time.Time) (m.Mood, error) {
+func (s *moodService) CreateMood(ctx context.Context, userID string, score int16, emotions, description string, date time.Time, e2e *m.E2EMetadata) (m.Mood, error) {
 	uidInt, err := strconv.Atoi(userID)
 	if err != nil {
 		return m.Mood{}, err
@@ -29,6 +39,9 @@ func (s *moodService) CreateMood(userID string, score int16, emotions, descripti
 	if err != nil {
 		return m.Mood{}, err
 	}
+	if err := validateE2E(user, description, e2e); err != nil {
+		return m.Mood{}, err
+	}
 
 	newMood := m.Mood{
 		Score:       score,
@@ -36,13 +49,17 @@ func (s *moodService) CreateMood(userID string, score int16, emotions, descripti
 		Description: description,
 		UserId:      uidInt,
 		Date:        date,
+		Version:     1,
+		E2E:         e2e,
 	}
 
 	if err := s.repo.CreateMood(&newMood); err != nil {
 		return m.Mood{}, err
 	}
+	s.assess(ctx, uidInt, &newMood)
+	s.publish(userID, ws.EventMoodCreated, newMood)
 
-	if user.UseAI {
+	if user.AIAllowed() {
 		loc, err := time.LoadLocation(user.Timezone)
 		if err != nil {
 			s.logger.Errorf("не удалось загрузить часовой пояс: %v", err)
@@ -52,47 +69,136 @@ func (s *moodService) CreateMood(userID string, score int16, emotions, descripti
 		dateYesterdayStr := time.Now().AddDate(0, 0, -1).In(loc).Format("2006-01-02")
 
 		if dateStr == dateNowStr || dateStr == dateYesterdayStr {
+			// Совет генерируется после ответа на запрос, поэтому контекст
+			// запроса здесь не подходит
 			go func() {
-				advice, err := s.adviceServ.GenerateAdvice(uidInt, date)
+				advice, err := s.adviceServ.GenerateAdvice(context.Background(), uidInt, date)
+				if errors.Is(err, context.Canceled) {
+					s.logger.Infof("Пользователь %s отключился, генерация advice отменена", userID)
+					return
+				}
 				if err != nil {
 					s.logger.Errorf("не удалось сгенерировать advice: %v", err)
 					return
 				}
-				if err := s.adviceRepo.CreateAdvice(&advice); err != nil {
+				if err := s.adviceRepo.SaveAdvice(&advice); err != nil {
 					s.logger.Errorf("не удалось добавить advice: %v", err)
-				}
-
-				adviceJson, err := json.Marshal(advice)
-				if err != nil {
-					s.logger.Errorf("не удался Marshal adviceJson: %v", err)
+					// Клиент уже получил части совета и должен их отбросить
+					event := ws.Event{Type: ws.EventAdviceFailed, Data: m.AdviceDelta{Date: dateStr}}
+					if err := s.connMgr.Publish(userID, event); err != nil && !errors.Is(err, ws.ErrNoConnections) {
+						s.logger.Errorf("не удалось отправить %s по WS: %v", ws.EventAdviceFailed, err)
+					}
 					return
 				}
 
-				if err := s.connMgr.Send(userID, string(adviceJson)); err != nil {
+				event := ws.Event{Type: ws.EventAdviceCreated, Version: advice.Version, Data: advice}
+				if err := s.connMgr.Publish(userID, event); err != nil && !errors.Is(err, ws.ErrNoConnections) {
 					s.logger.Errorf("не удалось отправить advice по WS: %v", err)
 				}
-
 			}()
 		}
 	}
 	return newMood, nil
 }
 
-func (s *moodService) DeleteMood(id string) error {
-	return s.repo.DeleteMood(id)
+func (s *moodService) DeleteMood(userID, id string) error {
+	mood, err := s.repo.GetMood(userID, id)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.DeleteMood(userID, id); err != nil {
+		return err
+	}
+
+	// Удаление тоже считается изменением сущности
+	mood.Version++
+	s.publish(userID, ws.EventMoodDeleted, mood)
+	return nil
 }
 
 func (s *moodService) GetMoods(userID string) ([]m.Mood, error) {
 	return s.repo.GetMoods(userID)
 }
 
-func (s *moodService) UpdateMood(userID string, m *m.Mood) error {
+func (s *moodService) UpdateMood(ctx context.Context, userID string, m *m.Mood) error {
 	uidInt, err := strconv.Atoi(userID)
 	if err != nil {
 		return err
 	}
+	user, err := s.userRepo.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if err := validateE2E(user, m.Description, m.E2E); err != nil {
+		return err
+	}
+
 	m.UserId = uidInt
-	return s.repo.UpdateMood(m)
+	if err := s.repo.UpdateMood(m); err != nil {
+		return err
+	}
+	s.assess(ctx, uidInt, m)
+	s.publish(userID, ws.EventMoodUpdated, *m)
+	return nil
+}
+
+// assess прикладывает к записи контакты помощи, если в ней найдены
+// признаки кризиса.
+func (s *moodService) assess(ctx context.Context, userID int, mood *m.Mood) {
+	// Зашифрованное на клиенте описание проверить нельзя, остаются эмоции
+	text := mood.Emotions
+	if mood.E2E == nil {
+		text = mood.Description + "\n" + mood.Emotions
+	}
+	assessment := s.safety.Assess(ctx, userID, text)
+	if assessment.Flagged {
+		resources := s.safety.Resources(assessment.Locale)
+		mood.CrisisResources = &resources
+	}
+}
+
+// validateE2E проверяет, что описание записи зашифровано на клиенте, если
+// пользователь включил сквозное шифрование, и что параметры шифра корректны.
+// Расшифровать описание сервер не может, поэтому проверяется только формат.
+func validateE2E(user m.User, description string, e2e *m.E2EMetadata) error {
+	if e2e == nil {
+		if user.E2EEnabled && description != "" {
+			return errs.ErrMoodE2ERequired
+		}
+		return nil
+	}
+
+	var nonceSize int
+	switch e2e.Alg {
+	case m.E2EAlgAESGCM:
+		nonceSize = 12
+	case m.E2EAlgXChaCha20Poly1305:
+		nonceSize = 24
+	default:
+		return errs.ErrMoodE2EInvalid
+	}
+
+	if e2e.KeyID == "" || len(e2e.KeyID) > e2eKeyIDLengthMax {
+		return errs.ErrMoodE2EInvalid
+	}
+	nonce, err := base64.StdEncoding.DecodeString(e2e.Nonce)
+	if err != nil || len(nonce) != nonceSize {
+		return errs.ErrMoodE2EInvalid
+	}
+	// Оба алгоритма добавляют к шифротексту 16-байтовый тег
+	ciphertext, err := base64.StdEncoding.DecodeString(description)
+	if err != nil || len(ciphertext) < e2eTagSize {
+		return errs.ErrMoodE2EInvalid
+	}
+	return nil
+}
+
+// publish рассылает изменение записи во все открытые сессии пользователя.
+func (s *moodService) publish(userID, eventType string, mood m.Mood) {
+	event := ws.Event{Type: eventType, Version: mood.Version, Data: mood}
+	if err := s.connMgr.Publish(userID, event); err != nil && !errors.Is(err, ws.ErrNoConnections) {
+		s.logger.Errorf("не удалось отправить %s по WS: %v", eventType, err)
+	}
 }
 
 func NewMoodService(
@@ -102,6 +208,7 @@ func NewMoodService(
 	adviceServ AdviceService,
 	logger *zap.SugaredLogger,
 	wsConnMgr *ws.ConnectionManager,
+	safetyChecker *safety.Checker,
 ) *moodService {
 	return &moodService{
 		repo:       repo,
@@ -110,5 +217,6 @@ func NewMoodService(
 		adviceServ: adviceServ,
 		logger:     logger,
 		connMgr:    wsConnMgr,
+		safety:     safetyChecker,
 	}
 }
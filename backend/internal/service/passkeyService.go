@@ -0,0 +1,330 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"sentimenta/internal/config"
+	errs "sentimenta/internal/errors"
+	m "sentimenta/internal/models"
+	"sentimenta/internal/repository"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// passkeySessionTTL — сколько ждём ответа аутентификатора.
+const passkeySessionTTL = 5 * time.Minute
+
+type passkeyService struct {
+	repo     repository.PasskeyRepository
+	userRepo repository.UserRepository
+	webauthn *webauthn.WebAuthn
+	logger   *zap.SugaredLogger
+}
+
+// passkeyUser связывает пользователя с его ключами для go-webauthn.
+// Дескриптор пользователя (user handle) — его uid в виде строки.
+type passkeyUser struct {
+	user     m.User
+	passkeys []m.Passkey
+}
+
+func (u passkeyUser) WebAuthnID() []byte {
+	return []byte(strconv.Itoa(u.user.Uid))
+}
+
+func (u passkeyUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u passkeyUser) WebAuthnDisplayName() string {
+	if u.user.Username != "" {
+		return u.user.Username
+	}
+	return u.user.Email
+}
+
+func (u passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.passkeys))
+	for _, p := range u.passkeys {
+		credentials = append(credentials, toCredential(p))
+	}
+	return credentials
+}
+
+func (s *passkeyService) BeginRegistration(userID string) (m.PasskeyOptions, error) {
+	if s.webauthn == nil {
+		return m.PasskeyOptions{}, errs.ErrPasskeysDisabled
+	}
+	user, err := s.loadUser(userID)
+	if err != nil {
+		return m.PasskeyOptions{}, err
+	}
+
+	creation, session, err := s.webauthn.BeginRegistration(user,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithExclusions(webauthn.Credentials(user.WebAuthnCredentials()).CredentialDescriptors()),
+	)
+	if err != nil {
+		return m.PasskeyOptions{}, err
+	}
+	return s.saveSession(&user.user.Uid, session, creation)
+}
+
+func (s *passkeyService) FinishRegistration(userID, sessionID, name string, body io.Reader) (m.Passkey, error) {
+	if s.webauthn == nil {
+		return m.Passkey{}, errs.ErrPasskeysDisabled
+	}
+	session, err := s.takeSession(sessionID)
+	if err != nil {
+		return m.Passkey{}, err
+	}
+	user, err := s.loadUser(userID)
+	if err != nil {
+		return m.Passkey{}, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(body)
+	if err != nil {
+		return m.Passkey{}, s.invalid(err)
+	}
+	credential, err := s.webauthn.CreateCredential(user, session, parsed)
+	if err != nil {
+		return m.Passkey{}, s.invalid(err)
+	}
+
+	if name == "" {
+		name = "Passkey " + strconv.Itoa(len(user.passkeys)+1)
+	}
+	passkey := m.Passkey{
+		UserID:          user.user.Uid,
+		Name:            name,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+	}
+	for _, transport := range credential.Transport {
+		passkey.Transports = append(passkey.Transports, string(transport))
+	}
+	if err := s.repo.CreatePasskey(&passkey); err != nil {
+		return m.Passkey{}, err
+	}
+	return passkey, nil
+}
+
+// BeginLogin начинает вход без указания пользователя: аутентификатор сам
+// предлагает ключи, сохранённые для этого сайта.
+func (s *passkeyService) BeginLogin() (m.PasskeyOptions, error) {
+	if s.webauthn == nil {
+		return m.PasskeyOptions{}, errs.ErrPasskeysDisabled
+	}
+	assertion, session, err := s.webauthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		return m.PasskeyOptions{}, err
+	}
+	return s.saveSession(nil, session, assertion)
+}
+
+// FinishLogin проверяет подпись и счётчик ключа и возвращает пользователя.
+// Для заблокированного пользователя возвращается он же вместе с
+// ErrUserDisabled, чтобы событие входа попало в журнал.
+func (s *passkeyService) FinishLogin(sessionID string, body io.Reader) (m.User, error) {
+	if s.webauthn == nil {
+		return m.User{}, errs.ErrPasskeysDisabled
+	}
+	session, err := s.takeSession(sessionID)
+	if err != nil {
+		return m.User{}, err
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBody(body)
+	if err != nil {
+		return m.User{}, s.invalid(err)
+	}
+
+	var passkey m.Passkey
+	var user passkeyUser
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		if passkey, err = s.repo.GetPasskeyByCredentialID(rawID); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(userHandle, []byte(strconv.Itoa(passkey.UserID))) {
+			return nil, errs.ErrPasskeyInvalid
+		}
+		user, err = s.loadUser(strconv.Itoa(passkey.UserID))
+		return user, err
+	}
+	credential, err := s.webauthn.ValidateDiscoverableLogin(handler, session, parsed)
+	if err != nil {
+		return user.user, s.invalid(err)
+	}
+
+	now := time.Now()
+	passkey.SignCount = credential.Authenticator.SignCount
+	passkey.CloneWarning = credential.Authenticator.CloneWarning
+	passkey.BackupState = credential.Flags.BackupState
+	passkey.LastUsedAt = &now
+	if err := s.repo.UpdatePasskey(&passkey); err != nil {
+		return user.user, err
+	}
+
+	if passkey.CloneWarning {
+		s.logger.Warnf("Пользователь %d: счётчик ключа доступа %d не вырос, возможна копия ключа", passkey.UserID, passkey.Uid)
+		return user.user, errs.ErrPasskeyCloned
+	}
+	if user.user.Disabled {
+		return user.user, errs.ErrUserDisabled
+	}
+	return user.user, nil
+}
+
+func (s *passkeyService) GetPasskeys(userID string) ([]m.Passkey, error) {
+	uid, err := strconv.Atoi(userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.repo.GetUserPasskeys(uid)
+}
+
+func (s *passkeyService) DeletePasskey(userID string, id int) error {
+	uid, err := strconv.Atoi(userID)
+	if err != nil {
+		return err
+	}
+	return s.repo.DeletePasskey(uid, id)
+}
+
+func (s *passkeyService) loadUser(userID string) (passkeyUser, error) {
+	user, err := s.userRepo.GetUser(userID)
+	if err != nil {
+		return passkeyUser{}, err
+	}
+	passkeys, err := s.repo.GetUserPasskeys(user.Uid)
+	if err != nil {
+		return passkeyUser{}, err
+	}
+	return passkeyUser{user: user, passkeys: passkeys}, nil
+}
+
+func (s *passkeyService) saveSession(userID *int, data *webauthn.SessionData, options any) (m.PasskeyOptions, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return m.PasskeyOptions{}, err
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return m.PasskeyOptions{}, err
+	}
+
+	session := m.WebAuthnSession{
+		ID:        hex.EncodeToString(id),
+		UserID:    userID,
+		Data:      raw,
+		ExpiresAt: time.Now().Add(passkeySessionTTL),
+	}
+	if err := s.repo.CreateSession(&session); err != nil {
+		return m.PasskeyOptions{}, err
+	}
+	return m.PasskeyOptions{SessionID: session.ID, Options: options}, nil
+}
+
+func (s *passkeyService) takeSession(id string) (webauthn.SessionData, error) {
+	session, err := s.repo.TakeSession(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return webauthn.SessionData{}, errs.ErrPasskeySession
+	}
+	if err != nil {
+		return webauthn.SessionData{}, err
+	}
+
+	var data webauthn.SessionData
+	if err := json.Unmarshal(session.Data, &data); err != nil {
+		return webauthn.SessionData{}, err
+	}
+	return data, nil
+}
+
+// invalid скрывает подробности ошибки проверки от клиента, оставляя их в
+// логе.
+func (s *passkeyService) invalid(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, errs.ErrPasskeyInvalid) {
+		return errs.ErrPasskeyInvalid
+	}
+	var protocolErr *protocol.Error
+	if errors.As(err, &protocolErr) {
+		s.logger.Infof("WebAuthn: %s: %s", protocolErr.Type, protocolErr.DevInfo)
+	} else {
+		s.logger.Infof("WebAuthn: %v", err)
+	}
+	return errs.ErrPasskeyInvalid
+}
+
+func toCredential(p m.Passkey) webauthn.Credential {
+	credential := webauthn.Credential{
+		ID:              p.CredentialID,
+		PublicKey:       p.PublicKey,
+		AttestationType: p.AttestationType,
+		Flags: webauthn.CredentialFlags{
+			UserPresent:    true,
+			BackupEligible: p.BackupEligible,
+			BackupState:    p.BackupState,
+		},
+		Authenticator: webauthn.Authenticator{
+			AAGUID:       p.AAGUID,
+			SignCount:    p.SignCount,
+			CloneWarning: p.CloneWarning,
+		},
+	}
+	for _, transport := range p.Transports {
+		credential.Transport = append(credential.Transport, protocol.AuthenticatorTransport(transport))
+	}
+	return credential
+}
+
+// NewPasskeyService настраивает WebAuthn по WEBAUTHN_RP_ID. Если он не
+// задан, ключи доступа отключены и методы возвращают ErrPasskeysDisabled.
+func NewPasskeyService(repo repository.PasskeyRepository, userRepo repository.UserRepository, cfg *config.Config, logger *zap.SugaredLogger) PasskeyService {
+	s := &passkeyService{repo: repo, userRepo: userRepo, logger: logger}
+	if cfg.WEBAUTHN_RP_ID == "" {
+		return s
+	}
+
+	// По умолчанию принимаем те же источники, что и CORS
+	origins := cfg.WEBAUTHN_ORIGINS
+	if len(origins) == 0 {
+		for _, origin := range cfg.ALLOWED_ORIGINS {
+			if origin = strings.TrimSpace(origin); origin != "" && origin != "*" {
+				origins = append(origins, origin)
+			}
+		}
+	}
+
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.WEBAUTHN_RP_ID,
+		RPDisplayName: cfg.WEBAUTHN_RP_NAME,
+		RPOrigins:     origins,
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: passkeySessionTTL},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: passkeySessionTTL},
+		},
+	})
+	if err != nil {
+		logger.Fatalf("Не удалось настроить WebAuthn: %v", err)
+	}
+	s.webauthn = w
+	return s
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	m "sentimenta/internal/models"
+	repo "sentimenta/internal/repository"
+
+	"go.uber.org/zap"
+)
+
+type securityEventService struct {
+	repo   repo.SecurityEventRepository
+	logger *zap.SugaredLogger
+}
+
+// Record сохраняет событие. Ошибка записи журнала не должна ломать сам
+// запрос, поэтому она только логируется.
+func (s *securityEventService) Record(e m.SecurityEvent) {
+	if err := s.repo.CreateEvent(&e); err != nil {
+		s.logger.Errorf("не удалось записать событие безопасности %s: %v", e.Type, err)
+	}
+}
+
+// RecordOAuthLogin записывает вход через OAuth и, если провайдер
+// используется с этим аккаунтом впервые, событие привязки провайдера.
+func (s *securityEventService) RecordOAuthLogin(e m.SecurityEvent, provider string) {
+	if e.UserID != nil {
+		linked, err := s.repo.HasEvent(*e.UserID, m.SecurityOAuthLink, "provider", provider)
+		if err != nil {
+			s.logger.Errorf("не удалось проверить привязку OAuth: %v", err)
+		} else if !linked {
+			link := e
+			link.Type = m.SecurityOAuthLink
+			link.Details = map[string]string{"provider": provider}
+			s.Record(link)
+		}
+	}
+
+	e.Type = m.SecurityLoginSuccess
+	e.Details = map[string]string{"method": provider}
+	s.Record(e)
+}
+
+func (s *securityEventService) GetUserEvents(userID, page, limit int) (m.Page[m.SecurityEvent], error) {
+	return s.GetEvents(m.SecurityEventFilter{UserID: &userID}, page, limit)
+}
+
+func (s *securityEventService) GetEvents(filter m.SecurityEventFilter, page, limit int) (m.Page[m.SecurityEvent], error) {
+	events, total, err := s.repo.GetEvents(filter, page, limit)
+	if err != nil {
+		return m.Page[m.SecurityEvent]{}, err
+	}
+	return m.Page[m.SecurityEvent]{
+		Items: events,
+		Page:  page,
+		Limit: limit,
+		Total: total,
+	}, nil
+}
+
+func NewSecurityEventService(repo repo.SecurityEventRepository, logger *zap.SugaredLogger) SecurityEventService {
+	return &securityEventService{repo: repo, logger: logger}
+}
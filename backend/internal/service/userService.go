@@ -4,15 +4,23 @@ import (
 	"errors"
 	errs "sentimenta/internal/errors"
 	"sentimenta/internal/hash"
+	"sentimenta/internal/i18n"
 	m "sentimenta/internal/models"
 	repo "sentimenta/internal/repository"
+	"sentimenta/internal/strength"
 	"sentimenta/internal/utils"
+	"time"
 
+	"go.uber.org/zap"
 	"gorm.io/gorm"
 )
 
 type userService struct {
-	repo repo.UserRepository
+	repo      repo.UserRepository
+	passwords *hash.Registry
+	policy    *strength.Policy
+	audit     SecurityEventService
+	logger    *zap.SugaredLogger
 }
 
 func (s *userService) CreateUser(username string, email string, password *string, timezone string) (m.User, error) {
@@ -29,7 +37,13 @@ func (s *userService) CreateUser(username string, email string, password *string
 
 	var passwordHashPtr *string
 	if password != nil {
-		hashed := hash.HashPassword(*password)
+		if err := s.policy.Check(*password, strength.Personal{Email: email, Username: username}); err != nil {
+			return m.User{}, err
+		}
+		hashed, err := s.passwords.Hash(*password)
+		if err != nil {
+			return m.User{}, err
+		}
 		passwordHashPtr = &hashed
 	}
 
@@ -38,6 +52,7 @@ func (s *userService) CreateUser(username string, email string, password *string
 		Email:        email,
 		PasswordHash: passwordHashPtr,
 		Timezone:     timezone,
+		Role:         m.RoleUser,
 	}
 	if err := s.repo.CreateUser(&newUser); err != nil {
 		return m.User{}, err
@@ -50,7 +65,9 @@ func (s *userService) GetUser(id string) (m.User, error) {
 	return s.repo.GetUser(id)
 }
 
-func (s *userService) UpdateUser(userID string, r m.UserUpdateReq) (m.User, error) {
+// UpdateUser применяет изменения и возвращает пользователя до изменения.
+// Если изменилась почта, в журнал безопасности записывается event.
+func (s *userService) UpdateUser(userID string, r m.UserUpdateReq, event m.SecurityEvent) (m.User, error) {
 	targetUser, err := s.repo.GetUser(userID)
 	if err != nil {
 		return m.User{}, err
@@ -64,12 +81,25 @@ func (s *userService) UpdateUser(userID string, r m.UserUpdateReq) (m.User, erro
 	if r.Email != nil {
 		updates["email"] = *r.Email
 	}
+	if r.Locale != nil {
+		// Пустая строка возвращает автоматический выбор языка
+		if *r.Locale != "" && !i18n.IsSupported(*r.Locale) {
+			return m.User{}, errs.ErrLocaleUnsupported
+		}
+		updates["locale"] = *r.Locale
+	}
 	if r.UseAI != nil {
 		updates["use_ai"] = *r.UseAI
 	}
 	if r.Timezone != nil {
 		updates["timezone"] = *r.Timezone
 	}
+	if r.RedactPII != nil {
+		updates["redact_pii"] = *r.RedactPII
+	}
+	if r.E2EEnabled != nil {
+		updates["e2e_enabled"] = *r.E2EEnabled
+	}
 
 	if len(updates) == 0 {
 		return m.User{}, nil
@@ -78,6 +108,9 @@ func (s *userService) UpdateUser(userID string, r m.UserUpdateReq) (m.User, erro
 	if err := s.repo.UpdateUser(targetUser.Uid, updates); err != nil {
 		return m.User{}, err
 	}
+	if r.Email != nil && *r.Email != targetUser.Email {
+		s.audit.Record(event)
+	}
 	return targetUser, nil
 }
 
@@ -90,38 +123,121 @@ func (s *userService) Authenticate(email, password string) (m.User, error) {
 	if err != nil {
 		return m.User{}, err
 	}
-	if !hash.VerifyPassword(password, *user.PasswordHash) {
-		return m.User{}, errs.ErrWrongPassword
+	// Пользователь возвращается и при неверном пароле, чтобы неудачную
+	// попытку входа можно было записать в журнал безопасности
+	if user.PasswordHash == nil {
+		return *user, errs.ErrWrongPassword
+	}
+	ok, rehash, err := s.passwords.Verify(password, *user.PasswordHash)
+	if err != nil {
+		s.logger.Errorf("Пользователь %d: не удалось проверить пароль: %v", user.Uid, err)
+		return *user, errs.ErrWrongPassword
+	}
+	if !ok {
+		return *user, errs.ErrWrongPassword
+	}
+	if user.Disabled {
+		return *user, errs.ErrUserDisabled
+	}
+
+	// Пароль известен только при входе, поэтому хеш со старым алгоритмом
+	// или параметрами заменяем сейчас. Ошибка не мешает входу
+	if rehash {
+		if hashed, err := s.passwords.Hash(password); err != nil {
+			s.logger.Warnf("Пользователь %d: не удалось пересчитать хеш пароля: %v", user.Uid, err)
+		} else if err := s.repo.UpdateUser(user.Uid, map[string]any{"password_hash": hashed}); err != nil {
+			s.logger.Warnf("Пользователь %d: не удалось сохранить новый хеш пароля: %v", user.Uid, err)
+		} else {
+			user.PasswordHash = &hashed
+		}
 	}
 
 	return *user, nil
 }
 
-func (s *userService) ChangePassword(userID, password, newPassword string) error {
+// ChangePassword меняет пароль после проверки текущего и записывает event
+// в журнал безопасности.
+func (s *userService) ChangePassword(userID, password, newPassword string, event m.SecurityEvent) error {
 	user, err := s.repo.GetUser(userID)
 	if err != nil {
 		return err
 	}
 
-	newPasswordHash := hash.HashPassword(newPassword)
-	if !hash.VerifyPassword(newPasswordHash, *user.PasswordHash) {
+	if user.PasswordHash == nil {
+		return errs.ErrWrongPassword
+	}
+	ok, _, err := s.passwords.Verify(password, *user.PasswordHash)
+	if err != nil {
+		s.logger.Errorf("Пользователь %d: не удалось проверить пароль: %v", user.Uid, err)
+		return errs.ErrWrongPassword
+	}
+	if !ok {
 		return errs.ErrWrongPassword
 	}
 
-	passwordHash := hash.HashPassword(newPassword)
-	user.PasswordHash = &passwordHash
-
-	if err := s.repo.UpdateUser(user.Uid, user); err != nil {
+	if err := s.policy.Check(newPassword, strength.Personal{Email: user.Email, Username: user.Username}); err != nil {
 		return err
 	}
+	passwordHash, err := s.passwords.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.UpdateUser(user.Uid, map[string]any{"password_hash": passwordHash}); err != nil {
+		return err
+	}
+	s.audit.Record(event)
 	return nil
 }
 
+// CheckSession отклоняет токены заблокированных пользователей и токены,
+// выданные до принудительного выхода.
+func (s *userService) CheckSession(userID string, issuedAt time.Time) (m.User, error) {
+	user, err := s.repo.GetUser(userID)
+	if err != nil {
+		return m.User{}, err
+	}
+	if user.Disabled {
+		return m.User{}, errs.ErrUserDisabled
+	}
+	if user.TokensRevokedAt != nil && issuedAt.Before(ceilSecond(*user.TokensRevokedAt)) {
+		return m.User{}, errs.ErrTokenRevoked
+	}
+	return user, nil
+}
+
+// ceilSecond округляет время вверх до целой секунды. iat хранится с
+// точностью до секунды, поэтому токен, выданный в ту же секунду, что и
+// отзыв, мог быть выдан и до него и считается отозванным.
+func ceilSecond(t time.Time) time.Time {
+	truncated := t.Truncate(time.Second)
+	if truncated.Equal(t) {
+		return t
+	}
+	return truncated.Add(time.Second)
+}
+
+// ResetPassword задаёт новый пароль без проверки старого. Используется
+// администратором.
+func (s *userService) ResetPassword(userID, newPassword string) error {
+	user, err := s.repo.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if err := s.policy.Check(newPassword, strength.Personal{Email: user.Email, Username: user.Username}); err != nil {
+		return err
+	}
+	passwordHash, err := s.passwords.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+	return s.repo.UpdateUser(user.Uid, map[string]any{"password_hash": passwordHash})
+}
+
 func (s *userService) GetUserByEmail(email string) (m.User, error) {
 	result, err := s.repo.GetUserByEmail(email)
 	return *result, err
 }
 
-func NewUserService(r repo.UserRepository) UserService {
-	return &userService{repo: r}
+func NewUserService(r repo.UserRepository, passwords *hash.Registry, policy *strength.Policy, audit SecurityEventService, logger *zap.SugaredLogger) UserService {
+	return &userService{repo: r, passwords: passwords, policy: policy, audit: audit, logger: logger}
 }
@@ -0,0 +1,130 @@
+package strength
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+var bloomMagic = [4]byte{'S', 'P', 'B', '1'}
+
+var errBloomFormat = errors.New("неверный формат фильтра утёкших паролей")
+
+// Bloom — фильтр Блума по SHA-1 паролей. Файл: сигнатура "SPB1",
+// число хеш-функций (uint32), размер в битах (uint64), затем биты.
+type Bloom struct {
+	k    uint32
+	m    uint64
+	bits []byte
+}
+
+// NewBloom создаёт пустой фильтр для n записей с долей ложных
+// срабатываний fp.
+func NewBloom(n uint64, fp float64) *Bloom {
+	if n == 0 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(fp) / (math.Ln2 * math.Ln2)))
+	k := uint32(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+	return &Bloom{k: k, m: m, bits: make([]byte, (m+7)/8)}
+}
+
+// Add добавляет SHA-1 пароля.
+func (b *Bloom) Add(digest [sha1.Size]byte) {
+	h1, h2 := split(digest)
+	for i := range uint64(b.k) {
+		bit := (h1 + i*h2) % b.m
+		b.bits[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+func (b *Bloom) Contains(digest [sha1.Size]byte) bool {
+	h1, h2 := split(digest)
+	for i := range uint64(b.k) {
+		bit := (h1 + i*h2) % b.m
+		if b.bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *Bloom) ContainsPassword(password string) bool {
+	return b.Contains(sha1.Sum([]byte(password)))
+}
+
+func (b *Bloom) WriteTo(w io.Writer) (int64, error) {
+	header := make([]byte, 16)
+	copy(header, bloomMagic[:])
+	binary.BigEndian.PutUint32(header[4:], b.k)
+	binary.BigEndian.PutUint64(header[8:], b.m)
+	n, err := w.Write(header)
+	if err != nil {
+		return int64(n), err
+	}
+	written, err := w.Write(b.bits)
+	return int64(n + written), err
+}
+
+// LoadBloom читает фильтр, записанный WriteTo.
+func LoadBloom(path string) (*Bloom, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 16 || [4]byte(data[:4]) != bloomMagic {
+		return nil, errBloomFormat
+	}
+	b := &Bloom{
+		k: binary.BigEndian.Uint32(data[4:]),
+		m: binary.BigEndian.Uint64(data[8:]),
+	}
+	b.bits = data[16:]
+	if b.k == 0 || b.m == 0 || uint64(len(b.bits)) != (b.m+7)/8 {
+		return nil, errBloomFormat
+	}
+	return b, nil
+}
+
+// ParseBreachedLine разбирает строку списка утёкших паролей: SHA-1 в hex
+// (как в выгрузке Have I Been Pwned, счётчик после двоеточия
+// игнорируется) или сам пароль.
+func ParseBreachedLine(line string) ([sha1.Size]byte, bool) {
+	line = strings.TrimRight(line, "\r")
+	if line == "" {
+		return [sha1.Size]byte{}, false
+	}
+	hash, _, _ := strings.Cut(line, ":")
+	if len(hash) == 2*sha1.Size {
+		var digest [sha1.Size]byte
+		if _, err := hex.Decode(digest[:], []byte(hash)); err == nil {
+			return digest, true
+		}
+	}
+	return sha1.Sum([]byte(line)), true
+}
+
+// CountLines считает непустые строки, чтобы подобрать размер фильтра.
+func CountLines(r io.Reader) (uint64, error) {
+	var n uint64
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if _, ok := ParseBreachedLine(scanner.Text()); ok {
+			n++
+		}
+	}
+	return n, scanner.Err()
+}
+
+func split(digest [sha1.Size]byte) (uint64, uint64) {
+	h1 := binary.BigEndian.Uint64(digest[0:8])
+	// Нечётный шаг не даёт всем k позициям совпасть
+	h2 := binary.BigEndian.Uint64(digest[8:16]) | 1
+	return h1, h2
+}
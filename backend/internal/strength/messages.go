@@ -0,0 +1,42 @@
+package strength
+
+import (
+	"fmt"
+	"sentimenta/internal/i18n"
+)
+
+var messages = map[string]map[string]string{
+	i18n.RU: {
+		CodeTooShort: "пароль должен содержать не меньше %[1]d символов",
+		CodeTooWeak:  "пароль слишком простой: добавьте длины или символов разных типов",
+		CodeHasEmail: "пароль не должен содержать адрес почты",
+		CodeHasName:  "пароль не должен содержать имя пользователя",
+		CodeBreached: "этот пароль встречается в утёкших базах, выберите другой",
+	},
+	i18n.EN: {
+		CodeTooShort: "password must be at least %[1]d characters long",
+		CodeTooWeak:  "password is too easy to guess: make it longer or mix character types",
+		CodeHasEmail: "password must not contain your email address",
+		CodeHasName:  "password must not contain your username",
+		CodeBreached: "this password appears in known data breaches, choose another one",
+	},
+}
+
+func summary(lang string) string {
+	if lang == i18n.EN {
+		return "password does not meet the requirements"
+	}
+	return "пароль не соответствует требованиям"
+}
+
+func message(lang string, v Violation) string {
+	catalog, ok := messages[lang]
+	if !ok {
+		catalog = messages[i18n.Default]
+	}
+	format := catalog[v.Code]
+	if min, ok := v.Params["min"]; ok {
+		return fmt.Sprintf(format, min)
+	}
+	return format
+}
@@ -0,0 +1,131 @@
+// Package strength проверяет новые пароли по политике: длина, оценка
+// энтропии, отсутствие почты и имени пользователя в пароле и отсутствие
+// пароля в списке утёкших.
+//
+// Список утёкших паролей хранится офлайн в виде фильтра Блума
+// (PASSWORD_BREACHED_FILE), который строится командой "password bloom"
+// из списка паролей или SHA-1 хешей в формате Have I Been Pwned.
+// Фильтр может ошибочно признать пароль утёкшим, но не пропустит пароль
+// из списка.
+package strength
+
+import (
+	"sentimenta/internal/config"
+	"sentimenta/internal/i18n"
+	"strings"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+const (
+	CodeTooShort   = "too_short"
+	CodeTooWeak    = "too_weak"
+	CodeHasEmail   = "contains_email"
+	CodeHasName    = "contains_username"
+	CodeBreached   = "breached"
+	personalMinLen = 3
+)
+
+// Violation — нарушенное правило. Message заполняется на языке запроса.
+type Violation struct {
+	Code    string         `json:"code"`
+	Message string         `json:"message"`
+	Params  map[string]int `json:"params,omitempty"`
+}
+
+// PolicyError — пароль не прошёл проверку.
+type PolicyError struct {
+	Violations []Violation
+}
+
+func (e *PolicyError) Error() string {
+	return e.Message(i18n.Default)
+}
+
+// Message — все нарушения одной строкой на языке lang.
+func (e *PolicyError) Message(lang string) string {
+	violations := e.Localize(lang)
+	messages := make([]string, len(violations))
+	for i, v := range violations {
+		messages[i] = v.Message
+	}
+	return summary(lang) + ": " + strings.Join(messages, "; ")
+}
+
+// Localize возвращает нарушения с сообщениями на языке lang.
+func (e *PolicyError) Localize(lang string) []Violation {
+	result := make([]Violation, len(e.Violations))
+	for i, v := range e.Violations {
+		v.Message = message(lang, v)
+		result[i] = v
+	}
+	return result
+}
+
+// Personal — данные пользователя, которые не должны встречаться в пароле.
+type Personal struct {
+	Email    string
+	Username string
+}
+
+type Policy struct {
+	minLength  int
+	minEntropy int
+	breached   *Bloom
+}
+
+// Check возвращает *PolicyError со всеми нарушенными правилами или nil.
+func (p *Policy) Check(password string, personal Personal) error {
+	var violations []Violation
+
+	if length := utf8.RuneCountInString(password); length < p.minLength {
+		violations = append(violations, Violation{Code: CodeTooShort, Params: map[string]int{"min": p.minLength}})
+	}
+	if p.minEntropy > 0 && Entropy(password) < float64(p.minEntropy) {
+		violations = append(violations, Violation{Code: CodeTooWeak, Params: map[string]int{"min_bits": p.minEntropy}})
+	}
+
+	lower := strings.ToLower(password)
+	email := strings.ToLower(strings.TrimSpace(personal.Email))
+	local, _, _ := strings.Cut(email, "@")
+	if containsPart(lower, email) || containsPart(lower, local) {
+		violations = append(violations, Violation{Code: CodeHasEmail})
+	}
+	if containsPart(lower, strings.ToLower(strings.TrimSpace(personal.Username))) {
+		violations = append(violations, Violation{Code: CodeHasName})
+	}
+
+	if p.breached != nil && p.breached.ContainsPassword(password) {
+		violations = append(violations, Violation{Code: CodeBreached})
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	return &PolicyError{Violations: violations}
+}
+
+// containsPart сообщает, что в пароле есть part. Слишком короткие части
+// не проверяются, иначе под запрет попадут случайные совпадения.
+func containsPart(password, part string) bool {
+	return utf8.RuneCountInString(part) >= personalMinLen && strings.Contains(password, part)
+}
+
+// NewPolicy создаёт политику по конфигурации и загружает фильтр утёкших
+// паролей, если задан PASSWORD_BREACHED_FILE.
+func NewPolicy(cfg *config.Config, log *zap.SugaredLogger) *Policy {
+	p := &Policy{
+		minLength:  cfg.PASSWORD_LENGTH_MIN,
+		minEntropy: cfg.PASSWORD_MIN_ENTROPY_BITS,
+	}
+	if cfg.PASSWORD_BREACHED_FILE != "" {
+		bloom, err := LoadBloom(cfg.PASSWORD_BREACHED_FILE)
+		if err != nil {
+			log.Fatalf("Не удалось загрузить список утёкших паролей: %v", err)
+		}
+		p.breached = bloom
+		log.Infof("Загружен список утёкших паролей: %s", cfg.PASSWORD_BREACHED_FILE)
+	}
+	return p
+}
@@ -6,6 +6,14 @@ type OpenRouterMessage struct {
 }
 
 type OpenRouterRequest struct {
-	Model    string              `json:"model"`
-	Messages []OpenRouterMessage `json:"messages"`
+	Model         string              `json:"model"`
+	Messages      []OpenRouterMessage `json:"messages"`
+	Stream        bool                `json:"stream,omitempty"`
+	StreamOptions *StreamOptions      `json:"stream_options,omitempty"`
+}
+
+// StreamOptions — параметры потоковой генерации. IncludeUsage просит
+// прислать расход токенов последним событием потока.
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
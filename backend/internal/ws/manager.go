@@ -1,43 +1,162 @@
 package ws
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+var ErrNoConnections = errors.New("не удалось найти подключение")
+
+// writeWait — сколько ждать записи в подключение. Клиент, который не
+// читает сообщения, не должен задерживать отправку остальным.
+const writeWait = 10 * time.Second
+
+// Типы событий, которые сервер отправляет клиентам.
+const (
+	EventMoodCreated   = "mood.created"
+	EventMoodUpdated   = "mood.updated"
+	EventMoodDeleted   = "mood.deleted"
+	EventAdviceCreated = "advice.created"
+	// Части совета по мере генерации. Текст предварительный: итоговый
+	// приходит в advice.created, а advice.failed означает, что полученное
+	// нужно отбросить
+	EventAdviceDelta  = "advice.delta"
+	EventAdviceFailed = "advice.failed"
+)
+
+// Event — конверт для всех сообщений, отправляемых по WebSocket.
+// Version позволяет клиенту отбросить устаревшие события и согласовать
+// оптимистичные обновления.
+type Event struct {
+	Type    string `json:"type"`
+	Version int    `json:"version,omitempty"`
+	Data    any    `json:"data"`
+}
+
+// Client — одно подключение пользователя. gorilla/websocket не допускает
+// конкурентной записи, поэтому запись идёт под мьютексом.
+type Client struct {
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+func (c *Client) WriteMessage(messageType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.conn.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+		return err
+	}
+	return c.conn.WriteMessage(messageType, data)
+}
+
+// session — контекст пользователя, который отменяется, когда закрыто
+// последнее его подключение.
+type session struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
 type ConnectionManager struct {
 	mu          sync.RWMutex
-	connections map[string]*websocket.Conn
+	connections map[string]map[*Client]struct{}
+	sessions    map[string]session
 }
 
 func NewConnectionManager() *ConnectionManager {
 	return &ConnectionManager{
-		connections: make(map[string]*websocket.Conn),
+		connections: make(map[string]map[*Client]struct{}),
+		sessions:    make(map[string]session),
 	}
 }
 
-func (m *ConnectionManager) Add(userID string, conn *websocket.Conn) {
+// Context возвращает контекст, который отменяется, когда пользователь
+// закроет все подключения. false — подключений нет.
+func (m *ConnectionManager) Context(userID string) (context.Context, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s, ok := m.sessions[userID]
+	return s.ctx, ok
+}
+
+// disconnect отменяет контекст пользователя. Вызывается под m.mu.
+func (m *ConnectionManager) disconnect(userID string) {
+	if s, ok := m.sessions[userID]; ok {
+		s.cancel()
+		delete(m.sessions, userID)
+	}
+	delete(m.connections, userID)
+}
+
+func (m *ConnectionManager) Add(userID string, conn *websocket.Conn) *Client {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.connections[userID] = conn
+
+	client := &Client{conn: conn}
+	if m.connections[userID] == nil {
+		m.connections[userID] = make(map[*Client]struct{})
+		ctx, cancel := context.WithCancel(context.Background())
+		m.sessions[userID] = session{ctx: ctx, cancel: cancel}
+	}
+	m.connections[userID][client] = struct{}{}
+	return client
 }
 
-func (m *ConnectionManager) Remove(userID string) {
+func (m *ConnectionManager) Remove(userID string, client *Client) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	delete(m.connections, userID)
+
+	delete(m.connections[userID], client)
+	if len(m.connections[userID]) == 0 {
+		m.disconnect(userID)
+	}
 }
 
+// Close закрывает все подключения пользователя, например после удаления
+// аккаунта.
+func (m *ConnectionManager) Close(userID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for client := range m.connections[userID] {
+		_ = client.conn.Close()
+	}
+	m.disconnect(userID)
+}
+
+// Send отправляет сообщение во все открытые подключения пользователя.
+// Запись идёт без блокировки менеджера, чтобы медленный клиент не
+// задерживал подключение и отключение других.
 func (m *ConnectionManager) Send(userID, message string) error {
 	m.mu.RLock()
-	defer m.mu.RUnlock()
+	clients := make([]*Client, 0, len(m.connections[userID]))
+	for client := range m.connections[userID] {
+		clients = append(clients, client)
+	}
+	m.mu.RUnlock()
 
-	conn, ok := m.connections[userID]
-	if !ok {
-		return errors.New("не удалось найти подключение")
+	if len(clients) == 0 {
+		return ErrNoConnections
 	}
 
-	return conn.WriteMessage(websocket.TextMessage, []byte(message))
+	var errList []error
+	for _, client := range clients {
+		if err := client.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+			errList = append(errList, err)
+		}
+	}
+	return errors.Join(errList...)
+}
+
+func (m *ConnectionManager) Publish(userID string, event Event) error {
+	eventJson, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return m.Send(userID, string(eventJson))
 }
@@ -19,7 +19,7 @@ export async function updateAdvice() {
 		advice.set(data);
 	} else {
 		console.error('Failed to fetch advice');
-		refreshUserId();
+		await refreshUserId();
 		if (!userId) throw new Error('not logged in');
 	}
 }
@@ -0,0 +1,29 @@
+const CSRF_COOKIE = 'csrf_token';
+const CSRF_HEADER = 'X-CSRF-Token';
+
+export function getCookie(name: string) {
+	const match = document.cookie.match(new RegExp('(^| )' + name + '=([^;]+)'));
+	return match ? decodeURIComponent(match[2]) : null;
+}
+
+// apiFetch is fetch for the backend API. State-changing requests repeat the
+// csrf_token cookie in the X-CSRF-Token header (double-submit CSRF check).
+export async function apiFetch(input: string, init: RequestInit = {}) {
+	const method = (init.method ?? 'GET').toUpperCase();
+	if (method === 'GET' || method === 'HEAD') {
+		return fetch(input, init);
+	}
+
+	let token = getCookie(CSRF_COOKIE);
+	if (!token) {
+		// The backend issues the token on any GET request
+		await fetch('/api/status');
+		token = getCookie(CSRF_COOKIE);
+	}
+
+	const headers = new Headers(init.headers);
+	if (token) {
+		headers.set(CSRF_HEADER, token);
+	}
+	return fetch(input, { ...init, headers });
+}
@@ -20,7 +20,7 @@ export async function updateMoods() {
 		}));
 		moods.set(parsed);
 	} else {
-		refreshUserId();
+		await refreshUserId();
 		if (!userId) throw new Error('not logged in');
 	}
 }
@@ -4,5 +4,5 @@ import { writable, type Writable } from 'svelte/store';
 export const userId: Writable<string | undefined> = writable(undefined);
 export const user: Writable<User | undefined> = writable(undefined);
 
-// run once on load
-refreshUserId();
+// run once on load; pages that redirect on the login state await it
+export const userReady = refreshUserId();
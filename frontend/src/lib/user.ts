@@ -1,79 +1,51 @@
-import { jwtDecode } from 'jwt-decode';
+import { apiFetch } from './api';
 import { user, userId } from './stores/user';
 
 export type User = {
+	uid: number;
 	username: string;
 	email: string;
 	use_ai: boolean;
+	ai_disabled_by_admin: boolean;
 	created_at: Date;
 	updated_at: Date;
 };
 
-function getCookie(name: string) {
-	const match = document.cookie.match(new RegExp('(^| )' + name + '=([^;]+)'));
-	return match ? match[2] : null;
+// aiAllowed mirrors the backend check: the user turned AI on and an admin
+// has not turned it off.
+export function aiAllowed(u: User | null | undefined) {
+	return u?.use_ai === true && !u.ai_disabled_by_admin;
 }
 
-export function setCookie(
-	name: string | number | boolean,
-	value: string | number | boolean,
-	daysToExpire: number
-) {
-	let expires = '';
-	if (daysToExpire) {
-		const date = new Date();
-		date.setTime(date.getTime() + daysToExpire * 24 * 60 * 60 * 1000);
-		expires = `expires=${date.toUTCString()};`;
-	}
-	document.cookie = `${encodeURIComponent(name)}=${encodeURIComponent(value)}; ${expires}path=/; secure; samesite=strict`;
-}
-
-export function logout() {
-	deleteCookie('access_token');
-	userId.set(undefined);
-}
-
-function deleteCookie(name: string) {
-	document.cookie = name + '=; expires=Thu, 01 Jan 1970 00:00:00 UTC; path=/;';
-}
+// The access token lives in an HttpOnly cookie set by the backend, so the
+// session is checked by asking the API who the current user is.
+export async function refreshUserId() {
+	if (typeof window === 'undefined') return;
 
-export function isTokenExpired(token: string | null) {
-	if (!token) return true; // No token means expired or invalid
 	try {
-		const decodedToken = jwtDecode(token);
-		const currentTime = Date.now() / 1000; // Current time in seconds
-		if (decodedToken.exp === undefined) {
-			return true; // Token has no expiration time
+		const response = await fetch('/api/user/get');
+		if (!response.ok) {
+			userId.set(undefined);
+			user.set(undefined);
+			return;
 		}
-		return decodedToken.exp < currentTime; // Compare expiration time with current time
+		const data: User = await response.json();
+		user.set(data);
+		userId.set(String(data.uid));
 	} catch (error) {
-		console.error('Error decoding token:', error);
-		return true; // Assume expired if decoding fails
-	}
-}
-
-export function refreshUserId() {
-	if (typeof window === 'undefined') return;
-
-	const jwtToken = getCookie('access_token');
-
-	if (isTokenExpired(jwtToken)) {
-		deleteCookie('access_token');
+		console.error('Failed to fetch user:', error);
 		userId.set(undefined);
-		return;
 	}
+}
 
-	if (jwtToken) {
-		try {
-			const { sub } = jwtDecode(jwtToken);
-			userId.set(sub);
-		} catch (error) {
-			console.error('Invalid JWT:', error);
-			userId.set(undefined);
-		}
-	} else {
-		userId.set(undefined);
+export async function logout() {
+	try {
+		await apiFetch('/api/auth/logout', { method: 'POST' });
+	} catch (error) {
+		console.error('Logout failed:', error);
 	}
+	userId.set(undefined);
+	user.set(undefined);
 }
 
 export async function refreshUser() {